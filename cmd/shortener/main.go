@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/server"
 )
@@ -28,7 +29,24 @@ func printBuildInfo() {
 	fmt.Println(formattedString)
 }
 
+// runMigrate обрабатывает подкоманду `shortener migrate up|down|status|redo [флаги]`.
+// Флаги после команды разбираются так же, как при запуске сервера.
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: shortener migrate up|down|status|redo [flags]")
+	}
+	command := args[0]
+	os.Args = append([]string{os.Args[0]}, args[1:]...)
+	if err := server.Migrate(command); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
 	printBuildInfo()
 	if err := server.Run(); err != nil {
 		log.Fatalf("An error occurred: %v", err)
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
@@ -19,6 +20,7 @@ import (
 
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/controller"
+	dbpkg "github.com/romanyakovlev/go-yandex-url-shortener/internal/db"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
@@ -34,11 +36,11 @@ func setupServer() *httptest.Server {
 	shortenerrepo := repository.MemoryURLRepository{SharedURLRows: sharedURLRows}
 	userrepo := repository.MemoryUserRepository{SharedURLRows: sharedURLRows}
 	shortener := service.NewURLShortenerService(serverConfig, &shortenerrepo, &userrepo)
-	worker := workers.InitURLDeletionWorker(shortener)
+	worker := workers.InitURLDeletionWorker(shortener, serverConfig, sugar)
 	URLCtrl := controller.NewURLShortenerController(shortener, sugar, worker)
 	db, _ := sql.Open("pgx", serverConfig.DatabaseDSN)
 	defer db.Close()
-	HealthCtrl := controller.NewHealthCheckController(db)
+	HealthCtrl := controller.NewHealthCheckController(server.InitHealthChecker(serverConfig, db, worker))
 	router := server.Router(URLCtrl, HealthCtrl, sugar)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -47,6 +49,88 @@ func setupServer() *httptest.Server {
 	return httptest.NewServer(router)
 }
 
+// setupPostgresServer запускает сервер с хранилищем в БД из DATABASE_DSN и пулом в режиме queryExecMode.
+func setupPostgresServer(b *testing.B, queryExecMode string) *httptest.Server {
+	b.Helper()
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("DATABASE_DSN is not set")
+	}
+	sugar := logger.GetLogger()
+	serverConfig := config.GetConfig(sugar)
+	serverConfig.DatabaseDSN = dsn
+	pool, err := dbpkg.InitPool(context.Background(), dsn, dbpkg.MigrationsModeAuto, dbpkg.PoolOptions{QueryExecMode: queryExecMode}, sugar)
+	require.NoError(b, err)
+	b.Cleanup(pool.Close)
+	shortenerrepo, err := server.InitURLRepository(serverConfig, repository.NewReadRouter(pool, nil, 0), nil, nil, nil, sugar)
+	require.NoError(b, err)
+	userrepo, err := repository.NewDBUserRepository(pool)
+	require.NoError(b, err)
+	shortener := service.NewURLShortenerService(serverConfig, shortenerrepo, userrepo)
+	worker := workers.InitURLDeletionWorker(shortener, serverConfig, sugar)
+	URLCtrl := controller.NewURLShortenerController(shortener, sugar, worker)
+	db, _ := sql.Open("pgx", dsn)
+	b.Cleanup(func() { db.Close() })
+	HealthCtrl := controller.NewHealthCheckController(server.InitHealthChecker(serverConfig, db, worker))
+	ts := httptest.NewServer(server.Router(URLCtrl, HealthCtrl, sugar))
+	b.Cleanup(ts.Close)
+	return ts
+}
+
+// postgresQueryExecModes режимы пула, которые сравниваются в бенчмарках с БД:
+// подготовленные запросы против выполнения с разбором каждого запроса.
+var postgresQueryExecModes = []string{
+	dbpkg.QueryExecModeCacheStatement,
+	dbpkg.QueryExecModeExec,
+	dbpkg.QueryExecModeSimpleProtocol,
+}
+
+// Benchmark_saveURLPostgres сравнивает пропускную способность сохранения URL в БД в разных режимах пула.
+// Выполняется, только если задан DATABASE_DSN.
+func Benchmark_saveURLPostgres(b *testing.B) {
+	for _, mode := range postgresQueryExecModes {
+		b.Run(mode, func(b *testing.B) {
+			ts := setupPostgresServer(b, mode)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					body := "https://practicum.yandex.ru/" + uuid.NewString()
+					resp, err := http.Post(ts.URL+"/", "text/plain", strings.NewReader(body))
+					if err == nil {
+						_, _ = io.ReadAll(resp.Body)
+						resp.Body.Close()
+					}
+				}
+			})
+		})
+	}
+}
+
+// Benchmark_getURLByIDPostgres сравнивает пропускную способность переходов по коротким ссылкам из БД в разных режимах пула.
+// Выполняется, только если задан DATABASE_DSN.
+func Benchmark_getURLByIDPostgres(b *testing.B) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	for _, mode := range postgresQueryExecModes {
+		b.Run(mode, func(b *testing.B) {
+			ts := setupPostgresServer(b, mode)
+			resp, err := http.Post(ts.URL+"/", "text/plain", strings.NewReader("https://practicum.yandex.ru/"+uuid.NewString()))
+			require.NoError(b, err)
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			shortLinkID := respBody[bytes.LastIndexByte(respBody, '/')+1:]
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Get(ts.URL + "/" + string(shortLinkID))
+					if err == nil {
+						resp.Body.Close()
+					}
+				}
+			})
+		})
+	}
+}
+
 func Benchmark_saveURL(b *testing.B) {
 	ts := setupServer()
 	defer ts.Close()
@@ -33,11 +33,11 @@ func TestMain(m *testing.M) {
 	shortenerrepo := repository.MemoryURLRepository{SharedURLRows: sharedURLRows}
 	userrepo := repository.MemoryUserRepository{SharedURLRows: sharedURLRows}
 	shortener := service.NewURLShortenerService(serverConfig, &shortenerrepo, &userrepo)
-	worker := workers.InitURLDeletionWorker(shortener)
+	worker := workers.InitURLDeletionWorker(shortener, serverConfig, sugar)
 	URLCtrl := controller.NewURLShortenerController(shortener, sugar, worker)
 	db, _ := sql.Open("pgx", serverConfig.DatabaseDSN)
 	defer db.Close()
-	HealthCtrl := controller.NewHealthCheckController(db)
+	HealthCtrl := controller.NewHealthCheckController(server.InitHealthChecker(serverConfig, db, worker))
 	router := server.Router(URLCtrl, HealthCtrl, sugar)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -120,6 +120,20 @@ func Test_getURLByID(t *testing.T) {
 
 }
 
+func Test_getUnknownURLByID(t *testing.T) {
+	resp, _ := testRequest(t, http.MethodGet, "/zzzzzzzz", nil)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+}
+
+func Test_ping(t *testing.T) {
+	resp, _ := testRequest(t, http.MethodGet, "/ping", nil)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+}
+
 func Test_shortenURL(t *testing.T) {
 	testCases := []struct {
 		method       string
@@ -165,8 +179,8 @@ func Test_shortenBatchURL(t *testing.T) {
 		{
 			method:       http.MethodPost,
 			expectedCode: http.StatusCreated,
-			body: `[{"correlation_id": "111", "short_url": "https://practicum.yandex.ru"},
-					{"correlation_id": "222", "short_url": "https://yandex.ru"}]`,
+			body: `[{"correlation_id": "111", "original_url": "https://practicum.yandex.ru/profile/"},
+					{"correlation_id": "222", "original_url": "https://yandex.ru"}]`,
 			bodyIsEmpty: false,
 		},
 	}
@@ -4,6 +4,7 @@ package apperrors
 
 import (
 	"fmt"
+	"time"
 )
 
 // OriginalURLAlreadyExists структура ошибки
@@ -15,3 +16,82 @@ type OriginalURLAlreadyExists struct {
 func (e *OriginalURLAlreadyExists) Error() string {
 	return fmt.Sprintf("original URL already exists: %s", e.URL)
 }
+
+// DeletionQueueIsFull ошибка переполнения очереди запросов на удаление
+type DeletionQueueIsFull struct {
+	RetryAfter time.Duration // Через сколько времени стоит повторить запрос.
+}
+
+// Error возвращает ошибку, если очередь на удаление переполнена
+func (e *DeletionQueueIsFull) Error() string {
+	return fmt.Sprintf("the deletion request queue is currently full, retry after %s", e.RetryAfter)
+}
+
+// URLNotFound ошибка отсутствия сокращенного URL
+type URLNotFound struct {
+	ShortURL string
+}
+
+// Error возвращает ошибку, если сокращенный URL не найден
+func (e *URLNotFound) Error() string {
+	return fmt.Sprintf("short URL not found: %s", e.ShortURL)
+}
+
+// URLAccessDenied ошибка изменения URL, принадлежащего другому пользователю
+type URLAccessDenied struct {
+	ShortURL string
+}
+
+// Error возвращает ошибку, если URL принадлежит другому пользователю
+func (e *URLAccessDenied) Error() string {
+	return fmt.Sprintf("short URL belongs to another user: %s", e.ShortURL)
+}
+
+// URLIsDeleted ошибка изменения удаленного URL
+type URLIsDeleted struct {
+	ShortURL string
+}
+
+// Error возвращает ошибку, если URL удален
+func (e *URLIsDeleted) Error() string {
+	return fmt.Sprintf("short URL is deleted: %s", e.ShortURL)
+}
+
+// InvalidQueryOptions ошибка некорректных параметров выборки
+type InvalidQueryOptions struct {
+	Reason string
+}
+
+// Error возвращает ошибку, если параметры выборки некорректны
+func (e *InvalidQueryOptions) Error() string {
+	return fmt.Sprintf("invalid query options: %s", e.Reason)
+}
+
+// ShortURLAlreadyExists ошибка сохранения URL с уже занятым коротким адресом
+type ShortURLAlreadyExists struct {
+	ShortURL string
+}
+
+// Error возвращает ошибку, если короткий адрес уже занят
+func (e *ShortURLAlreadyExists) Error() string {
+	return fmt.Sprintf("short URL already exists: %s", e.ShortURL)
+}
+
+// StorageUnavailable ошибка временной недоступности хранилища
+type StorageUnavailable struct {
+	RetryAfter time.Duration // Через сколько времени стоит повторить запрос.
+	Err        error         // Последняя ошибка хранилища; nil, если запрос к хранилищу не выполнялся.
+}
+
+// Error возвращает ошибку, если хранилище временно недоступно
+func (e *StorageUnavailable) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("storage is temporarily unavailable, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("storage is temporarily unavailable, retry after %s: %s", e.RetryAfter, e.Err)
+}
+
+// Unwrap возвращает последнюю ошибку хранилища
+func (e *StorageUnavailable) Unwrap() error {
+	return e.Err
+}
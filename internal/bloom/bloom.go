@@ -0,0 +1,198 @@
+// Package bloom содержит фильтр Блума для быстрой проверки того, что строки точно нет в множестве.
+package bloom
+
+import (
+	"context"
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// minIndexCapacity емкость, с которой индекс строится впервые.
+const minIndexCapacity = 1 << 16
+
+// Filter фильтр Блума. Не потокобезопасен.
+type Filter struct {
+	bits   []uint64     // Битовый массив.
+	m      uint64       // Количество бит.
+	k      uint64       // Количество хеш-функций.
+	seed   maphash.Seed // Затравка хеша; своя у каждого фильтра.
+	length int          // Количество добавленных строк.
+}
+
+// New создает фильтр на capacity строк с заданной вероятностью ложноположительного ответа.
+func New(capacity int, falsePositiveRate float64) *Filter {
+	n := math.Max(float64(capacity), 1)
+	m := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / n * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, k: k, seed: maphash.MakeSeed()}
+}
+
+// Add добавляет строку в фильтр.
+func (f *Filter) Add(s string) {
+	h1, h2 := f.hash(s)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.length++
+}
+
+// MayContain возвращает false, если строка точно не добавлялась в фильтр.
+func (f *Filter) MayContain(s string) bool {
+	h1, h2 := f.hash(s)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len возвращает количество добавленных строк, считая повторы.
+func (f *Filter) Len() int {
+	return f.length
+}
+
+// hash возвращает две независимые половины хеша строки для двойного хеширования.
+func (f *Filter) hash(s string) (uint64, uint64) {
+	h := maphash.String(f.seed, s)
+	return h & math.MaxUint32, h>>32 | 1
+}
+
+// Index потокобезопасный фильтр Блума, который можно перестроить из хранилища,
+// не теряя строки, добавленные во время перестроения. Пока индекс ни разу не построен
+// или мог пропустить строки (см. Suspend), он считает, что любая строка может в нем быть.
+type Index struct {
+	mu                sync.RWMutex
+	rebuildMu         sync.Mutex // Не дает перестраивать индекс одновременно.
+	falsePositiveRate float64    // Вероятность ложноположительного ответа.
+	current           *Filter    // Фильтр, по которому отвечает индекс; nil - еще не построен.
+	next              *Filter    // Фильтр, который сейчас строится; nil - перестроения нет.
+	suspended         bool       // Строки могут добавляться в хранилище мимо индекса.
+	stale             bool       // Индекс мог пропустить строки и еще не перестроен после Resume.
+	epoch             uint64     // Номер последнего Resume; перестроение, начатое до него, не снимает stale.
+}
+
+// NewIndex создает еще не построенный индекс с заданной вероятностью ложноположительного ответа.
+func NewIndex(falsePositiveRate float64) *Index {
+	return &Index{falsePositiveRate: falsePositiveRate}
+}
+
+// Add добавляет строку в индекс и в строящийся фильтр.
+func (i *Index) Add(s string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.current != nil {
+		i.current.Add(s)
+	}
+	if i.next != nil {
+		i.next.Add(s)
+	}
+}
+
+// MayContain возвращает false, если строка точно не добавлялась в индекс.
+func (i *Index) MayContain(s string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.current == nil || i.stale || i.current.MayContain(s)
+}
+
+// Remember добавляет строку, найденную в хранилище, если индекс о ней еще не знает.
+// Так в индекс попадают строки, пропущенные, пока он не был достоверным.
+func (i *Index) Remember(s string) {
+	i.mu.RLock()
+	known := i.current != nil && i.current.MayContain(s) && i.next == nil
+	i.mu.RUnlock()
+	if !known {
+		i.Add(s)
+	}
+}
+
+// Suspend сообщает, что строки могут добавляться в хранилище мимо индекса, например пока
+// не доходят события других экземпляров сервиса. До Resume и следующего за ним перестроения
+// индекс считает, что любая строка может в нем быть.
+func (i *Index) Suspend() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.suspended = true
+	i.stale = true
+}
+
+// Resume сообщает, что строки снова добавляются только через Add. Индекс станет достоверным
+// после перестроения, начатого позже этого вызова.
+func (i *Index) Resume() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.suspended = false
+	i.epoch++
+}
+
+// Len возвращает количество строк в индексе, считая повторы.
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if i.current == nil {
+		return 0
+	}
+	return i.current.Len()
+}
+
+// Rebuild строит новый фильтр из строк, которые forEach передает в add, и заменяет им текущий.
+// Емкость выбирается с запасом от прошлого размера; если строк оказалось больше,
+// фильтр сразу строится заново под их количество. Возвращает количество строк в новом фильтре.
+func (i *Index) Rebuild(ctx context.Context, forEach func(ctx context.Context, add func(s string)) error) (int, error) {
+	i.rebuildMu.Lock()
+	defer i.rebuildMu.Unlock()
+
+	capacity := max(minIndexCapacity, 2*i.Len())
+	i.mu.RLock()
+	epoch := i.epoch
+	i.mu.RUnlock()
+	for {
+		i.mu.Lock()
+		i.next = New(capacity, i.falsePositiveRate)
+		i.mu.Unlock()
+
+		err := forEach(ctx, i.addNext)
+
+		i.mu.Lock()
+		next := i.next
+		i.next = nil
+		if err == nil && next.Len() <= capacity {
+			i.current = next
+			if !i.suspended && i.epoch == epoch {
+				i.stale = false
+			}
+		}
+		i.mu.Unlock()
+
+		if err != nil {
+			return 0, err
+		}
+		if next.Len() <= capacity {
+			return next.Len(), nil
+		}
+		capacity = 2 * next.Len()
+	}
+}
+
+// addNext добавляет строку только в строящийся фильтр.
+func (i *Index) addNext(s string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.next.Add(s)
+}
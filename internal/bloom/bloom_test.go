@@ -0,0 +1,137 @@
+package bloom
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	const items = 10000
+	const falsePositiveRate = 0.01
+	f := New(items, falsePositiveRate)
+	for i := 0; i < items; i++ {
+		f.Add(fmt.Sprintf("added%d", i))
+	}
+
+	for i := 0; i < items; i++ {
+		require.True(t, f.MayContain(fmt.Sprintf("added%d", i)), "Фильтр Блума не может терять добавленные строки")
+	}
+	falsePositives := 0
+	for i := 0; i < items; i++ {
+		if f.MayContain(fmt.Sprintf("missing%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, float64(falsePositives)/items, 2*falsePositiveRate)
+	assert.Equal(t, items, f.Len())
+}
+
+func TestIndex_NotBuiltAllowsEverything(t *testing.T) {
+	index := NewIndex(0.01)
+	assert.True(t, index.MayContain("abcdefgh"))
+	index.Add("abcdefgh")
+	assert.True(t, index.MayContain("bcdefghi"))
+}
+
+func TestIndex_Rebuild(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(0.01)
+	stored := []string{"abcdefgh", "bcdefghi"}
+	forEach := func(ctx context.Context, add func(s string)) error {
+		for _, s := range stored {
+			add(s)
+		}
+		return nil
+	}
+
+	size, err := index.Rebuild(ctx, forEach)
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	assert.True(t, index.MayContain("abcdefgh"))
+	assert.False(t, index.MayContain("cdefghij"))
+
+	index.Add("cdefghij")
+	assert.True(t, index.MayContain("cdefghij"))
+
+	// После перестроения пропадают строки, которых больше нет в хранилище.
+	stored = []string{"bcdefghi"}
+	_, err = index.Rebuild(ctx, forEach)
+	require.NoError(t, err)
+	assert.False(t, index.MayContain("abcdefgh"))
+	assert.False(t, index.MayContain("cdefghij"))
+}
+
+func TestIndex_RebuildKeepsConcurrentAdds(t *testing.T) {
+	index := NewIndex(0.01)
+	_, err := index.Rebuild(context.Background(), func(ctx context.Context, add func(s string)) error {
+		add("abcdefgh")
+		// Строка сохраняется во время перестроения, уже после того, как хранилище ее прошло.
+		index.Add("bcdefghi")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, index.MayContain("abcdefgh"))
+	assert.True(t, index.MayContain("bcdefghi"))
+}
+
+func TestIndex_RebuildGrowsCapacity(t *testing.T) {
+	index := NewIndex(0.01)
+	passes := 0
+	size, err := index.Rebuild(context.Background(), func(ctx context.Context, add func(s string)) error {
+		passes++
+		for i := 0; i < 3*minIndexCapacity; i++ {
+			add(fmt.Sprintf("added%d", i))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3*minIndexCapacity, size)
+	assert.Equal(t, 2, passes, "Переполненный фильтр должен быть сразу перестроен под фактический размер")
+}
+
+func TestIndex_RebuildErrorKeepsCurrent(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(0.01)
+	_, err := index.Rebuild(ctx, func(ctx context.Context, add func(s string)) error {
+		add("abcdefgh")
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = index.Rebuild(ctx, func(ctx context.Context, add func(s string)) error {
+		return assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.True(t, index.MayContain("abcdefgh"))
+}
+
+func TestIndex_SuspendUntilResumedAndRebuilt(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(0.01)
+	rebuild := func() {
+		_, err := index.Rebuild(ctx, func(ctx context.Context, add func(s string)) error {
+			add("abcdefgh")
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	rebuild()
+	assert.False(t, index.MayContain("bcdefghi"))
+
+	index.Suspend()
+	assert.True(t, index.MayContain("bcdefghi"), "Пока строки добавляются мимо индекса, он не должен их отсекать")
+	rebuild()
+	assert.True(t, index.MayContain("bcdefghi"), "Перестроение до Resume не делает индекс достоверным")
+
+	index.Resume()
+	assert.True(t, index.MayContain("bcdefghi"), "После Resume индекс достоверен только после перестроения")
+	rebuild()
+	assert.False(t, index.MayContain("bcdefghi"))
+
+	index.Remember("bcdefghi")
+	assert.True(t, index.MayContain("bcdefghi"))
+}
@@ -0,0 +1,185 @@
+// Package cache содержит кэширующие декораторы репозиториев.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// entry результат поиска URL по короткому адресу, сохраненный в кэше.
+type entry struct {
+	shortURL  string        // Короткий адрес, по которому выполнялся поиск.
+	row       models.URLRow // Найденная строка.
+	found     bool          // Найден ли URL; false - отрицательная запись.
+	expiresAt time.Time     // Время, после которого запись устаревает.
+}
+
+// URLCache ограниченный по размеру LRU-кэш результатов поиска URL по короткому адресу.
+// Отрицательные результаты хранятся отдельное, обычно более короткое время.
+type URLCache struct {
+	mu          sync.Mutex
+	size        int                      // Максимальное количество записей.
+	ttl         time.Duration            // Время жизни найденных URL.
+	negativeTTL time.Duration            // Время жизни отрицательных записей (0 - не кэшировать).
+	order       *list.List               // Записи от недавно использованных к давно использованным.
+	items       map[string]*list.Element // Записи по короткому адресу.
+	byUUID      map[uuid.UUID]string     // Короткие адреса найденных URL по их идентификатору.
+
+	// Сбросы, которые произошли во время загрузок из хранилища. Номера сбросов запоминаются
+	// по адресам и идентификаторам, пока идет хотя бы одна загрузка, чтобы сброс одной записи
+	// не отбрасывал результаты загрузок других.
+	invalidations uint64               // Номер последнего сброса.
+	purged        uint64               // Номер последнего полного сброса.
+	loads         int                  // Количество незавершенных загрузок.
+	staleKeys     map[string]uint64    // Номер последнего сброса по короткому адресу.
+	staleUUIDs    map[uuid.UUID]uint64 // Номер последнего сброса по идентификатору URL.
+	now           func() time.Time
+}
+
+// NewURLCache создает кэш на size записей.
+func NewURLCache(size int, ttl time.Duration, negativeTTL time.Duration) *URLCache {
+	return &URLCache{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		order:       list.New(),
+		items:       make(map[string]*list.Element),
+		byUUID:      make(map[uuid.UUID]string),
+		staleKeys:   make(map[string]uint64),
+		staleUUIDs:  make(map[uuid.UUID]uint64),
+		now:         time.Now,
+	}
+}
+
+// get возвращает неустаревшую запись по короткому адресу.
+func (c *URLCache) get(shortURL string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[shortURL]
+	if !ok {
+		return entry{}, false
+	}
+	e := elem.Value.(entry)
+	if !c.now().Before(e.expiresAt) {
+		c.removeElement(elem)
+		return entry{}, false
+	}
+	c.order.MoveToFront(elem)
+	return e, true
+}
+
+// beginLoad отмечает начало загрузки из хранилища и возвращает номер последнего сброса для add.
+// Каждый вызов должен завершаться endLoad.
+func (c *URLCache) beginLoad() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loads++
+	return c.invalidations
+}
+
+// endLoad отмечает окончание загрузки. Когда загрузок не остается, запомненные сбросы больше не нужны.
+func (c *URLCache) endLoad() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loads--
+	if c.loads == 0 {
+		clear(c.staleKeys)
+		clear(c.staleUUIDs)
+	}
+}
+
+// add сохраняет результат загрузки, начатой при номере сброса started, если с тех пор
+// не сбрасывались ни этот адрес, ни найденный URL: иначе результат мог устареть еще до сохранения.
+func (c *URLCache) add(shortURL string, row models.URLRow, found bool, started uint64) {
+	ttl := c.ttl
+	if !found {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.purged > started || c.staleKeys[shortURL] > started || (found && c.staleUUIDs[row.UUID] > started) {
+		return
+	}
+	if elem, ok := c.items[shortURL]; ok {
+		c.removeElement(elem)
+	}
+	e := entry{shortURL: shortURL, row: row, found: found, expiresAt: c.now().Add(ttl)}
+	c.items[shortURL] = c.order.PushFront(e)
+	if found {
+		c.byUUID[row.UUID] = shortURL
+	}
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Invalidate удаляет из кэша записи по коротким адресам.
+func (c *URLCache) Invalidate(shortURLs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidations++
+	for _, shortURL := range shortURLs {
+		if c.loads > 0 {
+			c.staleKeys[shortURL] = c.invalidations
+		}
+		if elem, ok := c.items[shortURL]; ok {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// InvalidateUUIDs удаляет из кэша записи URL с указанными идентификаторами.
+func (c *URLCache) InvalidateUUIDs(UUIDs ...uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidations++
+	for _, UUID := range UUIDs {
+		if c.loads > 0 {
+			c.staleUUIDs[UUID] = c.invalidations
+		}
+		if shortURL, ok := c.byUUID[UUID]; ok {
+			c.removeElement(c.items[shortURL])
+		}
+	}
+}
+
+// Purge удаляет из кэша все записи.
+func (c *URLCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidations++
+	c.purged = c.invalidations
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+	c.byUUID = make(map[uuid.UUID]string)
+}
+
+// Len возвращает количество записей в кэше, включая еще не вытесненные устаревшие.
+func (c *URLCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// removeElement удаляет запись из всех индексов. Вызывается под блокировкой.
+func (c *URLCache) removeElement(elem *list.Element) {
+	e := c.order.Remove(elem).(entry)
+	delete(c.items, e.shortURL)
+	if e.found && c.byUUID[e.row.UUID] == e.shortURL {
+		delete(c.byUUID, e.row.UUID)
+	}
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+)
+
+// CachedURLRepository кэширует поиск URL по короткому адресу и сбрасывает кэш
+// при изменениях через этот же репозиторий.
+type CachedURLRepository struct {
+	repo  service.URLRepository // Исходный репозиторий.
+	cache *URLCache             // Кэш результатов поиска.
+	group singleflight.Group    // Объединяет одновременные промахи по одному короткому адресу.
+}
+
+// CachedUserRepository сбрасывает записи кэша URL, у которых меняется владелец.
+type CachedUserRepository struct {
+	repo  service.UserRepository // Исходный репозиторий.
+	cache *URLCache              // Кэш результатов поиска URL.
+}
+
+// findResult результат поиска, разделяемый между одновременными промахами.
+type findResult struct {
+	row   models.URLRow
+	found bool
+}
+
+// Find выполняет поиск URL по короткому адресу, обращаясь к хранилищу только при промахе кэша.
+// Одновременные промахи по одному адресу выполняют один запрос к хранилищу.
+// Ошибки хранилища не кэшируются, поэтому при недоступном хранилище продолжают работать только попадания.
+func (r *CachedURLRepository) Find(ctx context.Context, shortURL string) (models.URLRow, bool, error) {
+	if e, ok := r.cache.get(shortURL); ok {
+		metrics.URLCacheRequestsTotal.WithLabelValues("hit").Inc()
+		return e.row, e.found, nil
+	}
+	metrics.URLCacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	// Отмена запроса первого клиента не должна прерывать поиск для остальных.
+	ctx = context.WithoutCancel(ctx)
+	v, err, _ := r.group.Do(shortURL, func() (interface{}, error) {
+		started := r.cache.beginLoad()
+		defer r.cache.endLoad()
+		row, found, err := r.repo.Find(ctx, shortURL)
+		if err != nil {
+			return nil, err
+		}
+		r.cache.add(shortURL, row, found, started)
+		return findResult{row: row, found: found}, nil
+	})
+	if err != nil {
+		return models.URLRow{}, false, err
+	}
+	result := v.(findResult)
+	return result.row, result.found, nil
+}
+
+// Save сохраняет URL.
+func (r *CachedURLRepository) Save(ctx context.Context, url models.URLToSave) (uuid.UUID, error) {
+	defer r.cache.Invalidate(url.RandomPath)
+	return r.repo.Save(ctx, url)
+}
+
+// BatchSave сохраняет список URL.
+func (r *CachedURLRepository) BatchSave(ctx context.Context, urls []models.URLToSave) ([]uuid.UUID, error) {
+	shortURLs := make([]string, 0, len(urls))
+	for _, url := range urls {
+		shortURLs = append(shortURLs, url.RandomPath)
+	}
+	defer r.cache.Invalidate(shortURLs...)
+	return r.repo.BatchSave(ctx, urls)
+}
+
+// BatchDelete удаляет список URL.
+func (r *CachedURLRepository) BatchDelete(ctx context.Context, urls []string, userID uuid.UUID) error {
+	defer r.cache.Invalidate(urls...)
+	return r.repo.BatchDelete(ctx, urls, userID)
+}
+
+// BatchDeleteByUsers удаляет URL нескольких пользователей разом.
+func (r *CachedURLRepository) BatchDeleteByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	defer r.invalidateByUsers(urlsByUser)
+	return r.repo.BatchDeleteByUsers(ctx, urlsByUser)
+}
+
+// BatchRestoreByUsers восстанавливает удаленные URL нескольких пользователей разом.
+func (r *CachedURLRepository) BatchRestoreByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	defer r.invalidateByUsers(urlsByUser)
+	return r.repo.BatchRestoreByUsers(ctx, urlsByUser)
+}
+
+// FindByUserID ищет все URL пользователя.
+func (r *CachedURLRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.URLRow, error) {
+	return r.repo.FindByUserID(ctx, userID)
+}
+
+// FindByUserIDWithOptions выбирает страницу URL пользователя.
+func (r *CachedURLRepository) FindByUserIDWithOptions(ctx context.Context, userID uuid.UUID, opts models.URLQueryOptions) ([]models.URLRow, string, error) {
+	return r.repo.FindByUserIDWithOptions(ctx, userID, opts)
+}
+
+// FindByOriginalURL ищет URL по оригинальному адресу.
+func (r *CachedURLRepository) FindByOriginalURL(ctx context.Context, originalURL string, userID uuid.UUID) (string, bool) {
+	return r.repo.FindByOriginalURL(ctx, originalURL, userID)
+}
+
+// UpdateOriginalURL меняет исходный URL пользователя.
+func (r *CachedURLRepository) UpdateOriginalURL(ctx context.Context, shortURL string, userID uuid.UUID, originalURL string) (models.URLRow, error) {
+	defer r.cache.Invalidate(shortURL)
+	return r.repo.UpdateOriginalURL(ctx, shortURL, userID, originalURL)
+}
+
+// PurgeDeleted окончательно стирает URL, удаленные раньше deletedBefore.
+// Какие адреса стерты, неизвестно, поэтому кэш сбрасывается целиком. Если хранилище недоступно
+// и ничего не стерто, кэш сохраняется: пока хранилище не восстановится, переходы обслуживаются из него.
+func (r *CachedURLRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (int, error) {
+	purged, err := r.repo.PurgeDeleted(ctx, deletedBefore, batchSize)
+	var unavailableErr *apperrors.StorageUnavailable
+	if purged > 0 || (err != nil && !errors.As(err, &unavailableErr)) {
+		r.cache.Purge()
+	}
+	return purged, err
+}
+
+// ForEachShortURL перебирает короткие адреса всех URL, включая удаленные.
+func (r *CachedURLRepository) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	return r.repo.ForEachShortURL(ctx, fn)
+}
+
+// invalidateByUsers удаляет из кэша все адреса из списков пользователей.
+func (r *CachedURLRepository) invalidateByUsers(urlsByUser map[uuid.UUID][]string) {
+	for _, urls := range urlsByUser {
+		r.cache.Invalidate(urls...)
+	}
+}
+
+// UpdateUser привязывает URL к пользователю.
+func (r *CachedUserRepository) UpdateUser(ctx context.Context, savedURLUUID uuid.UUID, userID uuid.UUID) error {
+	defer r.cache.InvalidateUUIDs(savedURLUUID)
+	return r.repo.UpdateUser(ctx, savedURLUUID, userID)
+}
+
+// UpdateBatchUser привязывает список URL к пользователю.
+func (r *CachedUserRepository) UpdateBatchUser(ctx context.Context, savedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
+	defer r.cache.InvalidateUUIDs(savedURLUUIDs...)
+	return r.repo.UpdateBatchUser(ctx, savedURLUUIDs, userID)
+}
+
+// NewCachedURLRepository оборачивает репозиторий URL кэшем поиска по короткому адресу.
+func NewCachedURLRepository(repo service.URLRepository, cache *URLCache) *CachedURLRepository {
+	return &CachedURLRepository{repo: repo, cache: cache}
+}
+
+// NewCachedUserRepository оборачивает репозиторий пользователей сбросом кэша URL.
+func NewCachedUserRepository(repo service.UserRepository, cache *URLCache) *CachedUserRepository {
+	return &CachedUserRepository{repo: repo, cache: cache}
+}
@@ -0,0 +1,276 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+)
+
+// countingURLRepository считает обращения к Find, может задерживать их до закрытия release
+// и возвращать ошибку хранилища err.
+type countingURLRepository struct {
+	service.URLRepository
+	finds   atomic.Int32
+	release chan struct{}
+	err     error
+}
+
+func (r *countingURLRepository) Find(ctx context.Context, shortURL string) (models.URLRow, bool, error) {
+	r.finds.Add(1)
+	if r.release != nil {
+		<-r.release
+	}
+	if r.err != nil {
+		return models.URLRow{}, false, r.err
+	}
+	return r.URLRepository.Find(ctx, shortURL)
+}
+
+func (r *countingURLRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.URLRepository.PurgeDeleted(ctx, deletedBefore, batchSize)
+}
+
+func setupCachedRepository(t *testing.T, urlCache *URLCache) (*CachedURLRepository, *CachedUserRepository, *countingURLRepository) {
+	t.Helper()
+	sharedURLRows := models.NewSharedURLRows()
+	urlRepo, err := repository.NewMemoryURLRepository(sharedURLRows, repository.DedupScopeGlobal)
+	require.NoError(t, err)
+	userRepo, err := repository.NewMemoryUserRepository(sharedURLRows)
+	require.NoError(t, err)
+	counting := &countingURLRepository{URLRepository: urlRepo}
+	return NewCachedURLRepository(counting, urlCache), NewCachedUserRepository(userRepo, urlCache), counting
+}
+
+func TestCachedURLRepository_Find(t *testing.T) {
+	ctx := context.Background()
+	repo, _, counting := setupCachedRepository(t, NewURLCache(10, time.Minute, time.Minute))
+	_, err := repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "https://ya.ru"})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		row, ok, err := repo.Find(ctx, "abcdefgh")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "https://ya.ru", row.OriginalURL)
+	}
+	assert.EqualValues(t, 1, counting.finds.Load(), "Повторный поиск должен обслуживаться кэшем")
+}
+
+func TestCachedURLRepository_NegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	repo, _, counting := setupCachedRepository(t, NewURLCache(10, time.Minute, time.Minute))
+
+	_, ok, err := repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.EqualValues(t, 1, counting.finds.Load())
+
+	// Сохранение URL с этим адресом сбрасывает отрицательную запись.
+	_, err = repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "https://ya.ru"})
+	require.NoError(t, err)
+	_, ok, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestCachedURLRepository_StorageUnavailable(t *testing.T) {
+	ctx := context.Background()
+	repo, _, counting := setupCachedRepository(t, NewURLCache(10, time.Minute, time.Minute))
+	_, err := repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "https://ya.ru"})
+	require.NoError(t, err)
+	_, _, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+
+	counting.err = &apperrors.StorageUnavailable{RetryAfter: time.Second}
+	row, ok, err := repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err, "Попадания в кэш должны обслуживаться при недоступном хранилище")
+	assert.True(t, ok)
+	assert.Equal(t, "https://ya.ru", row.OriginalURL)
+	_, _, err = repo.Find(ctx, "bcdefghi")
+	assert.ErrorIs(t, err, counting.err)
+
+	// Неудачная очистка из-за недоступности хранилища не сбрасывает кэш.
+	_, err = repo.PurgeDeleted(ctx, time.Now(), 10)
+	require.Error(t, err)
+	finds := counting.finds.Load()
+	_, ok, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, finds, counting.finds.Load())
+
+	counting.err = nil
+	_, err = repo.Save(ctx, models.URLToSave{RandomPath: "bcdefghi", URLStr: "https://practicum.yandex.ru"})
+	require.NoError(t, err)
+	_, ok, err = repo.Find(ctx, "bcdefghi")
+	require.NoError(t, err)
+	assert.True(t, ok, "Ошибка хранилища не должна кэшироваться как отсутствие URL")
+	_, ok, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestCachedURLRepository_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	repo, userRepo, _ := setupCachedRepository(t, NewURLCache(10, time.Minute, time.Minute))
+	UUID, err := repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "https://ya.ru"})
+	require.NoError(t, err)
+
+	row, _, err := repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Nil, row.UserID)
+	require.NoError(t, userRepo.UpdateUser(ctx, UUID, userID))
+	row, _, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.Equal(t, userID, row.UserID, "Смена владельца должна сбрасывать запись кэша")
+
+	_, err = repo.UpdateOriginalURL(ctx, "abcdefgh", userID, "https://practicum.yandex.ru")
+	require.NoError(t, err)
+	row, _, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru", row.OriginalURL)
+
+	require.NoError(t, repo.BatchDelete(ctx, []string{"abcdefgh"}, userID))
+	row, _, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, row.DeletedFlag)
+
+	require.NoError(t, repo.BatchRestoreByUsers(ctx, map[uuid.UUID][]string{userID: {"abcdefgh"}}))
+	row, _, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.False(t, row.DeletedFlag)
+
+	require.NoError(t, repo.BatchDeleteByUsers(ctx, map[uuid.UUID][]string{userID: {"abcdefgh"}}))
+	row, _, err = repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, row.DeletedFlag)
+
+	purged, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	_, ok, err := repo.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.False(t, ok, "Стертый URL не должен оставаться в кэше")
+}
+
+func TestURLCache_TTL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	urlCache := NewURLCache(10, time.Minute, time.Second)
+	urlCache.now = func() time.Time { return now }
+	repo, _, counting := setupCachedRepository(t, urlCache)
+	_, err := repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "https://ya.ru"})
+	require.NoError(t, err)
+
+	repo.Find(ctx, "abcdefgh")
+	repo.Find(ctx, "missingx")
+	assert.EqualValues(t, 2, counting.finds.Load())
+
+	// Отрицательная запись устаревает раньше найденного URL.
+	now = now.Add(2 * time.Second)
+	repo.Find(ctx, "abcdefgh")
+	repo.Find(ctx, "missingx")
+	assert.EqualValues(t, 3, counting.finds.Load())
+
+	now = now.Add(time.Minute)
+	repo.Find(ctx, "abcdefgh")
+	assert.EqualValues(t, 4, counting.finds.Load())
+}
+
+func TestURLCache_Eviction(t *testing.T) {
+	ctx := context.Background()
+	repo, _, counting := setupCachedRepository(t, NewURLCache(2, time.Minute, time.Minute))
+
+	repo.Find(ctx, "aaaaaaaa")
+	repo.Find(ctx, "bbbbbbbb")
+	repo.Find(ctx, "aaaaaaaa")
+	repo.Find(ctx, "cccccccc")
+	assert.Equal(t, 2, repo.cache.Len())
+	assert.EqualValues(t, 3, counting.finds.Load())
+
+	// Вытесняется давно использованный адрес, а не первый добавленный.
+	repo.Find(ctx, "aaaaaaaa")
+	assert.EqualValues(t, 3, counting.finds.Load())
+	repo.Find(ctx, "bbbbbbbb")
+	assert.EqualValues(t, 4, counting.finds.Load())
+}
+
+func TestCachedURLRepository_CollapsesConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	repo, _, counting := setupCachedRepository(t, NewURLCache(10, time.Minute, time.Minute))
+	_, err := repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "https://ya.ru"})
+	require.NoError(t, err)
+	counting.release = make(chan struct{})
+
+	const clients = 10
+	var wg sync.WaitGroup
+	results := make([]bool, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, results[i], _ = repo.Find(ctx, "abcdefgh")
+		}(i)
+	}
+	require.Eventually(t, func() bool { return counting.finds.Load() == 1 }, time.Second, time.Millisecond)
+	close(counting.release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, counting.finds.Load())
+	for _, ok := range results {
+		assert.True(t, ok)
+	}
+}
+
+func TestURLCache_StaleLoadIsDiscarded(t *testing.T) {
+	urlCache := NewURLCache(10, time.Minute, time.Minute)
+	started := urlCache.beginLoad()
+	urlCache.Invalidate("abcdefgh")
+	urlCache.add("abcdefgh", models.URLRow{ShortURL: "abcdefgh"}, true, started)
+	urlCache.endLoad()
+
+	_, ok := urlCache.get("abcdefgh")
+	assert.False(t, ok, "Результат, прочитанный до изменения хранилища, не должен попадать в кэш")
+}
+
+func TestURLCache_InvalidationKeepsOtherLoads(t *testing.T) {
+	urlCache := NewURLCache(10, time.Minute, time.Minute)
+	changedUUID := uuid.New()
+
+	started := urlCache.beginLoad()
+	urlCache.Invalidate("zzzzzzzz")
+	urlCache.InvalidateUUIDs(uuid.New())
+	urlCache.add("abcdefgh", models.URLRow{ShortURL: "abcdefgh", UUID: uuid.New()}, true, started)
+	urlCache.InvalidateUUIDs(changedUUID)
+	urlCache.add("bcdefghi", models.URLRow{ShortURL: "bcdefghi", UUID: changedUUID}, true, started)
+	urlCache.endLoad()
+
+	_, ok := urlCache.get("abcdefgh")
+	assert.True(t, ok, "Сброс других записей не должен отбрасывать загрузку")
+	_, ok = urlCache.get("bcdefghi")
+	assert.False(t, ok, "Загрузка URL, измененного по идентификатору, должна отбрасываться")
+
+	urlCache.Invalidate("abcdefgh")
+	started = urlCache.beginLoad()
+	urlCache.add("abcdefgh", models.URLRow{ShortURL: "abcdefgh"}, true, started)
+	urlCache.endLoad()
+	_, ok = urlCache.get("abcdefgh")
+	assert.True(t, ok, "Сброс до начала загрузки не должен ее отбрасывать")
+}
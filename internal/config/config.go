@@ -2,15 +2,20 @@
 // Доступные пути конфигурации (указаны в порядке приоритета):
 // 1. Поиск Переменной окружения
 // 2. поиск аргумента командой строки
-// 3. значение по-умолчанию
+// 3. файл конфигурации
+// 4. значение по-умолчанию
 
 package config
 
 import (
 	"encoding/json"
 	"flag"
+	"io"
 	"os"
+	"reflect"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 
@@ -21,30 +26,157 @@ type argConfig struct {
 	flagAAddr    string
 	flagBAddr    string
 	flagFAddr    string
+	flagBoltAddr string
 	flagDAddr    string
 	flagSAddr    bool
 	flagCertAddr string
 	flagKeyAddr  string
 	flagCAddr    string
+
+	flagDBReplicaDSN           string
+	flagDBReplicaMaxLag        time.Duration
+	flagDBReplicaCheckInterval time.Duration
+	flagDBReadAfterWrite       time.Duration
+
+	flagDBMaxConns         int
+	flagDBMinConns         int
+	flagDBMaxConnLifetime  time.Duration
+	flagDBMaxConnIdleTime  time.Duration
+	flagDBStatementTimeout time.Duration
+	flagDBQueryExecMode    string
+
+	flagDBRetryAttempts      int
+	flagDBRetryBaseDelay     time.Duration
+	flagDBRetryMaxDelay      time.Duration
+	flagDBBreakerFailures    int
+	flagDBBreakerOpenTimeout time.Duration
+
+	flagDeletionWorkers       int
+	flagDeletionFlushInterval time.Duration
+	flagDeletionBatchSize     int
+	flagDeletionQueueSize     int
+
+	flagPurgeRetention time.Duration
+	flagPurgeInterval  time.Duration
+	flagPurgeBatchSize int
+
+	flagSnapshotPath     string
+	flagSnapshotInterval time.Duration
+
+	flagCacheSize        int
+	flagCacheTTL         time.Duration
+	flagCacheNegativeTTL time.Duration
+
+	flagBloomFalsePositiveRate float64
+	flagBloomRebuildInterval   time.Duration
+
+	flagMetricsAddr string
+
+	flagTracingExporter string
+	flagTracingEndpoint string
+	flagTracingFile     string
+
+	flagLogLevel              string
+	flagLogEncoding           string
+	flagLogOutputs            string
+	flagLogMaxSizeMB          int
+	flagLogMaxAgeDays         int
+	flagLogMaxBackups         int
+	flagLogSamplingInitial    int
+	flagLogSamplingThereafter int
+
+	flagMigrationsMode string
+	flagDedupScope     string
 }
 
 type envConfig struct {
 	ServerAddress   string `env:"SERVER_ADDRESS"`
 	BaseURL         string `env:"BASE_URL"`
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
+	BoltStoragePath string `env:"BOLT_STORAGE_PATH"`
 	DatabaseDSN     string `env:"DATABASE_DSN"`
 	enableHTTPS     bool   `env:"ENABLE_HTTPS"`
 	keyFile         string `env:"KEY_FILE"`
 	certFile        string `env:"CERT_FILE"`
 	config          string `env:"CONFIG"`
+
+	DatabaseReplicaDSN     []string      `env:"DATABASE_REPLICA_DSN" envSeparator:","`
+	DBReplicaMaxLag        time.Duration `env:"DB_REPLICA_MAX_LAG"`
+	DBReplicaCheckInterval time.Duration `env:"DB_REPLICA_CHECK_INTERVAL"`
+	DBReadAfterWrite       time.Duration `env:"DB_READ_AFTER_WRITE"`
+
+	DBMaxConns         int           `env:"DB_MAX_CONNS"`
+	DBMinConns         int           `env:"DB_MIN_CONNS"`
+	DBMaxConnLifetime  time.Duration `env:"DB_MAX_CONN_LIFETIME"`
+	DBMaxConnIdleTime  time.Duration `env:"DB_MAX_CONN_IDLE_TIME"`
+	DBStatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT"`
+	DBQueryExecMode    string        `env:"DB_QUERY_EXEC_MODE"`
+
+	DBRetryAttempts      int           `env:"DB_RETRY_ATTEMPTS"`
+	DBRetryBaseDelay     time.Duration `env:"DB_RETRY_BASE_DELAY"`
+	DBRetryMaxDelay      time.Duration `env:"DB_RETRY_MAX_DELAY"`
+	DBBreakerFailures    int           `env:"DB_BREAKER_FAILURES"`
+	DBBreakerOpenTimeout time.Duration `env:"DB_BREAKER_OPEN_TIMEOUT"`
+
+	DeletionWorkers       int           `env:"DELETION_WORKERS"`
+	DeletionFlushInterval time.Duration `env:"DELETION_FLUSH_INTERVAL"`
+	DeletionBatchSize     int           `env:"DELETION_BATCH_SIZE"`
+	DeletionQueueSize     int           `env:"DELETION_QUEUE_SIZE"`
+
+	PurgeRetention time.Duration `env:"PURGE_RETENTION"`
+	PurgeInterval  time.Duration `env:"PURGE_INTERVAL"`
+	PurgeBatchSize int           `env:"PURGE_BATCH_SIZE"`
+
+	SnapshotPath     string        `env:"SNAPSHOT_PATH"`
+	SnapshotInterval time.Duration `env:"SNAPSHOT_INTERVAL"`
+
+	CacheSize        int           `env:"CACHE_SIZE"`
+	CacheTTL         time.Duration `env:"CACHE_TTL"`
+	CacheNegativeTTL time.Duration `env:"CACHE_NEGATIVE_TTL"`
+
+	BloomFalsePositiveRate float64       `env:"BLOOM_FALSE_POSITIVE_RATE"`
+	BloomRebuildInterval   time.Duration `env:"BLOOM_REBUILD_INTERVAL"`
+
+	MetricsAddress string `env:"METRICS_ADDRESS"`
+
+	TracingExporter string `env:"TRACING_EXPORTER"`
+	TracingEndpoint string `env:"TRACING_ENDPOINT"`
+	TracingFile     string `env:"TRACING_FILE"`
+
+	LogLevel              string   `env:"LOG_LEVEL"`
+	LogEncoding           string   `env:"LOG_ENCODING"`
+	LogOutputs            []string `env:"LOG_OUTPUTS" envSeparator:","`
+	LogMaxSizeMB          int      `env:"LOG_MAX_SIZE_MB"`
+	LogMaxAgeDays         int      `env:"LOG_MAX_AGE_DAYS"`
+	LogMaxBackups         int      `env:"LOG_MAX_BACKUPS"`
+	LogSamplingInitial    int      `env:"LOG_SAMPLING_INITIAL"`
+	LogSamplingThereafter int      `env:"LOG_SAMPLING_THEREAFTER"`
+
+	MigrationsMode string `env:"MIGRATIONS_MODE"`
+	DedupScope     string `env:"DEDUP_SCOPE"`
 }
 
 type fileConfig struct {
-	ServerAddress   string `json:"server_address"`
-	BaseURL         string `json:"base_url"`
-	FileStoragePath string `json:"file_storage_path"`
-	DatabaseDSN     string `json:"database_dsn"`
-	EnableHTTPS     bool   `json:"enable_https"`
+	ServerAddress          string   `json:"server_address"`
+	BaseURL                string   `json:"base_url"`
+	FileStoragePath        string   `json:"file_storage_path"`
+	BoltStoragePath        string   `json:"bolt_storage_path"`
+	DatabaseDSN            string   `json:"database_dsn"`
+	DatabaseReplicaDSN     []string `json:"database_replica_dsn"`
+	EnableHTTPS            bool     `json:"enable_https"`
+	MetricsAddress         string   `json:"metrics_address"`
+	CacheSize              int      `json:"cache_size"`
+	BloomFalsePositiveRate float64  `json:"bloom_false_positive_rate"`
+	TracingExporter        string   `json:"tracing_exporter"`
+	TracingEndpoint        string   `json:"tracing_endpoint"`
+	TracingFile            string   `json:"tracing_file"`
+	LogLevel               string   `json:"log_level"`
+	LogEncoding            string   `json:"log_encoding"`
+	LogOutputs             []string `json:"log_outputs"`
+	MigrationsMode         string   `json:"migrations_mode"`
+	DedupScope             string   `json:"dedup_scope"`
+
+	set map[string]bool // Ключи, присутствующие в файле, в том числе с нулевыми значениями.
 }
 
 // Config Доступные агрументы для конфигурации
@@ -55,48 +187,217 @@ type Config struct {
 	BaseURL string
 	// FileStoragePath - Путь для сохраниния данных в файле
 	FileStoragePath string
+	// BoltStoragePath - Путь к файлу встроенной базы bbolt (имеет приоритет над FileStoragePath)
+	BoltStoragePath string
 	// DatabaseDSN - Строка с адресом подключения к БД
 	DatabaseDSN string
+	// DatabaseReplicaDSN - Строки подключения к репликам БД, с которых выполняется поиск URL
+	DatabaseReplicaDSN []string
+	// DBReplicaMaxLag - Отставание, после которого чтение с реплики переходит на основную БД
+	DBReplicaMaxLag time.Duration
+	// DBReplicaCheckInterval - Интервал проверки отставания реплик
+	DBReplicaCheckInterval time.Duration
+	// DBReadAfterWrite - Сколько после записи пользователя читать его URL с основной БД (отрицательное значение - не отслеживать записи)
+	DBReadAfterWrite time.Duration
+	// DBMaxConns - Максимальное количество соединений в пуле БД (0 - по умолчанию pgxpool)
+	DBMaxConns int
+	// DBMinConns - Сколько соединений пул БД держит открытыми
+	DBMinConns int
+	// DBMaxConnLifetime - Время, после которого соединение с БД закрывается
+	DBMaxConnLifetime time.Duration
+	// DBMaxConnIdleTime - Время простоя, после которого соединение с БД закрывается
+	DBMaxConnIdleTime time.Duration
+	// DBStatementTimeout - Ограничение времени выполнения запроса к БД (0 - без ограничения)
+	DBStatementTimeout time.Duration
+	// DBQueryExecMode - Режим выполнения запросов к БД: cache_statement, cache_describe, describe_exec, exec или simple_protocol
+	DBQueryExecMode string
+	// DBRetryAttempts - Максимальное количество попыток запроса к БД при временных ошибках (1 - без повторов)
+	DBRetryAttempts int
+	// DBRetryBaseDelay - Задержка перед первым повтором запроса к БД
+	DBRetryBaseDelay time.Duration
+	// DBRetryMaxDelay - Максимальная задержка перед повтором запроса к БД
+	DBRetryMaxDelay time.Duration
+	// DBBreakerFailures - Количество сбоев БД подряд, после которого запросы к ней отклоняются (отрицательное значение - не отклонять)
+	DBBreakerFailures int
+	// DBBreakerOpenTimeout - Сколько отклонять запросы к недоступной БД перед пробным запросом
+	DBBreakerOpenTimeout time.Duration
 	// EnableHTTPS - Включить HTTPS режим
 	EnableHTTPS bool
 	// KeyFile - путь до ключа
 	KeyFile string
 	// CertFile - путь до сертификата
 	CertFile string
+	// DeletionWorkers - Количество горутин, обрабатывающих удаление URL
+	DeletionWorkers int
+	// DeletionFlushInterval - Интервал, за который накапливаются запросы на удаление
+	DeletionFlushInterval time.Duration
+	// DeletionBatchSize - Максимальное количество URL в одной пачке на удаление
+	DeletionBatchSize int
+	// DeletionQueueSize - Емкость очереди запросов на удаление
+	DeletionQueueSize int
+	// PurgeRetention - Через сколько после удаления URL стирается окончательно (0 - не стирать)
+	PurgeRetention time.Duration
+	// PurgeInterval - Интервал запуска окончательного удаления
+	PurgeInterval time.Duration
+	// PurgeBatchSize - Сколько URL стирается за один запрос к БД
+	PurgeBatchSize int
+	// SnapshotPath - Файл снимка хранилища в памяти (пустая строка отключает снимки)
+	SnapshotPath string
+	// SnapshotInterval - Интервал сохранения снимка хранилища в памяти (0 - только при остановке и по запросу)
+	SnapshotInterval time.Duration
+	// CacheSize - Сколько результатов поиска по короткому адресу хранить в кэше (0 - без кэша)
+	CacheSize int
+	// CacheTTL - Время жизни найденного URL в кэше
+	CacheTTL time.Duration
+	// CacheNegativeTTL - Время жизни в кэше результата "URL не найден" (0 - не кэшировать)
+	CacheNegativeTTL time.Duration
+	// BloomFalsePositiveRate - Доля несуществующих коротких адресов, которые фильтр Блума пропускает к хранилищу (0 - без фильтра)
+	BloomFalsePositiveRate float64
+	// BloomRebuildInterval - Интервал перестроения фильтра Блума, после которого из него пропадают стертые URL
+	BloomRebuildInterval time.Duration
+	// MetricsAddress - Адрес служебного HTTP-сервера с метриками и ручками администрирования (пустая строка отключает сервер)
+	MetricsAddress string
+	// TracingExporter - Экспортер трассировки: none, otlp или stdout
+	TracingExporter string
+	// TracingEndpoint - Адрес коллектора OTLP (host:port)
+	TracingEndpoint string
+	// TracingFile - Файл для экспортера stdout (по умолчанию спаны пишутся в stdout)
+	TracingFile string
+	// LogLevel - Уровень логирования: debug, info, warn, error
+	LogLevel string
+	// LogEncoding - Формат логов: json или console
+	LogEncoding string
+	// LogOutputs - Куда писать логи: stderr, stdout и/или пути к файлам
+	LogOutputs []string
+	// LogMaxSizeMB - Максимальный размер файла лога до ротации
+	LogMaxSizeMB int
+	// LogMaxAgeDays - Сколько дней хранить ротированные файлы логов
+	LogMaxAgeDays int
+	// LogMaxBackups - Сколько ротированных файлов логов хранить
+	LogMaxBackups int
+	// LogSamplingInitial - Сколько одинаковых сообщений лога запросов в секунду пишется без сэмплирования (0 - без сэмплирования)
+	LogSamplingInitial int
+	// LogSamplingThereafter - После LogSamplingInitial пишется каждое N-е сообщение лога запросов
+	LogSamplingThereafter int
+	// MigrationsMode - Режим миграций: auto (применять при запуске) или manual (только проверять версию схемы)
+	MigrationsMode string
+	// DedupScope - Область дедупликации оригинальных URL: global, user или none
+	DedupScope string
 }
 
 var onceParseEnvs sync.Once
 var onceParseFlags sync.Once
 var onceParseConfFile sync.Once
 
-func parseEnvs(s *logger.Logger) envConfig {
-	var cfg envConfig
+var (
+	parsedEnvs  envConfig       // Значения переменных окружения.
+	setEnvs     map[string]bool // Заданные переменные окружения, в том числе пустые.
+	parsedFlags argConfig       // Значения флагов, включая значения по умолчанию.
+	setFlags    map[string]bool // Флаги, явно заданные в командной строке.
+)
+
+func parseEnvs(s *logger.Logger) (envConfig, map[string]bool) {
 	onceParseEnvs.Do(func() {
-		err := env.Parse(&cfg)
+		err := env.Parse(&parsedEnvs)
 		if err != nil {
 			s.Fatal(err)
 		}
+		setEnvs = lookupEnvs()
 	})
-	return cfg
+	return parsedEnvs, setEnvs
 }
 
-func parseFlags() argConfig {
-	var cfg argConfig
+// lookupEnvs возвращает имена заданных переменных окружения конфигурации. Пустое значение
+// тоже считается заданным, чтобы переменной можно было, например, отключить сервер метрик.
+func lookupEnvs() map[string]bool {
+	set := make(map[string]bool)
+	t := reflect.TypeOf(envConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		if _, ok := os.LookupEnv(name); ok {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+// parseFlags разбирает командную строку и возвращает значения флагов
+// вместе с именами флагов, заданных явно.
+func parseFlags() (argConfig, map[string]bool) {
 	onceParseFlags.Do(func() {
-		// указываем имя флага, значение по умолчанию и описание
-		flag.StringVar(&cfg.flagAAddr, "a", "localhost:8080", "Адрес запуска HTTP-сервера")
-		flag.StringVar(&cfg.flagBAddr, "b", "http://localhost:8080", "Базовый адрес результирующего сокращённого URL")
-		flag.StringVar(&cfg.flagFAddr, "f", "", "Путь для сохраниния данных в файле")
-		flag.StringVar(&cfg.flagDAddr, "d", "", "Строка с адресом подключения к БД")
-		flag.BoolVar(&cfg.flagSAddr, "s", false, "Включить HTTPS режим")
-		flag.StringVar(&cfg.flagKeyAddr, "key", "./keyfile.pem", "Путь до ключа")
-		flag.StringVar(&cfg.flagCertAddr, "cert", "./certfile.pem", "Путь до сертификата")
-		flag.StringVar(&cfg.flagCAddr, "c", "", "Путь до файла конфигурации")
-		flag.StringVar(&cfg.flagCAddr, "config", "", "Путь до файла конфигурации")
+		registerFlags(flag.CommandLine, &parsedFlags)
 		// делаем разбор командной строки
 		flag.Parse()
+		setFlags = visitFlags(flag.CommandLine)
 	})
-	return cfg
+	return parsedFlags, setFlags
+}
+
+// visitFlags возвращает имена флагов, явно заданных в командной строке.
+func visitFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// registerFlags регистрирует флаги конфигурации в fs.
+func registerFlags(fs *flag.FlagSet, cfg *argConfig) {
+	// указываем имя флага, значение по умолчанию и описание
+	fs.StringVar(&cfg.flagAAddr, "a", "localhost:8080", "Адрес запуска HTTP-сервера")
+	fs.StringVar(&cfg.flagBAddr, "b", "http://localhost:8080", "Базовый адрес результирующего сокращённого URL")
+	fs.StringVar(&cfg.flagFAddr, "f", "", "Путь для сохраниния данных в файле")
+	fs.StringVar(&cfg.flagBoltAddr, "bolt-storage-path", "", "Путь к файлу встроенной базы bbolt")
+	fs.StringVar(&cfg.flagDAddr, "d", "", "Строка с адресом подключения к БД")
+	fs.StringVar(&cfg.flagDBReplicaDSN, "database-replica-dsn", "", "Строки подключения к репликам БД через запятую")
+	fs.DurationVar(&cfg.flagDBReplicaMaxLag, "db-replica-max-lag", 10*time.Second, "Отставание, после которого чтение с реплики переходит на основную БД")
+	fs.DurationVar(&cfg.flagDBReplicaCheckInterval, "db-replica-check-interval", 5*time.Second, "Интервал проверки отставания реплик")
+	fs.DurationVar(&cfg.flagDBReadAfterWrite, "db-read-after-write", 5*time.Second, "Сколько после записи пользователя читать его URL с основной БД (отрицательное значение - не отслеживать записи)")
+	fs.IntVar(&cfg.flagDBMaxConns, "db-max-conns", 0, "Максимальное количество соединений в пуле БД (0 - по умолчанию pgxpool)")
+	fs.IntVar(&cfg.flagDBMinConns, "db-min-conns", 0, "Сколько соединений пул БД держит открытыми")
+	fs.DurationVar(&cfg.flagDBMaxConnLifetime, "db-max-conn-lifetime", time.Hour, "Время, после которого соединение с БД закрывается")
+	fs.DurationVar(&cfg.flagDBMaxConnIdleTime, "db-max-conn-idle-time", 30*time.Minute, "Время простоя, после которого соединение с БД закрывается")
+	fs.DurationVar(&cfg.flagDBStatementTimeout, "db-statement-timeout", 0, "Ограничение времени выполнения запроса к БД (0 - без ограничения)")
+	fs.StringVar(&cfg.flagDBQueryExecMode, "db-query-exec-mode", "cache_statement", "Режим выполнения запросов к БД: cache_statement (подготовленные запросы), cache_describe, describe_exec, exec или simple_protocol (для pgbouncer)")
+	fs.IntVar(&cfg.flagDBRetryAttempts, "db-retry-attempts", 3, "Максимальное количество попыток запроса к БД при временных ошибках (1 - без повторов)")
+	fs.DurationVar(&cfg.flagDBRetryBaseDelay, "db-retry-base-delay", 50*time.Millisecond, "Задержка перед первым повтором запроса к БД")
+	fs.DurationVar(&cfg.flagDBRetryMaxDelay, "db-retry-max-delay", time.Second, "Максимальная задержка перед повтором запроса к БД")
+	fs.IntVar(&cfg.flagDBBreakerFailures, "db-breaker-failures", 5, "Количество сбоев БД подряд, после которого запросы к ней отклоняются (отрицательное значение - не отклонять)")
+	fs.DurationVar(&cfg.flagDBBreakerOpenTimeout, "db-breaker-open-timeout", 10*time.Second, "Сколько отклонять запросы к недоступной БД перед пробным запросом")
+	fs.BoolVar(&cfg.flagSAddr, "s", false, "Включить HTTPS режим")
+	fs.StringVar(&cfg.flagKeyAddr, "key", "./keyfile.pem", "Путь до ключа")
+	fs.StringVar(&cfg.flagCertAddr, "cert", "./certfile.pem", "Путь до сертификата")
+	fs.StringVar(&cfg.flagCAddr, "c", "", "Путь до файла конфигурации")
+	fs.StringVar(&cfg.flagCAddr, "config", "", "Путь до файла конфигурации")
+	fs.IntVar(&cfg.flagDeletionWorkers, "deletion-workers", 4, "Количество горутин, обрабатывающих удаление URL")
+	fs.DurationVar(&cfg.flagDeletionFlushInterval, "deletion-flush-interval", 500*time.Millisecond, "Интервал накопления запросов на удаление")
+	fs.IntVar(&cfg.flagDeletionBatchSize, "deletion-batch-size", 100, "Максимальное количество URL в одной пачке на удаление")
+	fs.IntVar(&cfg.flagDeletionQueueSize, "deletion-queue-size", 1000, "Емкость очереди запросов на удаление")
+	fs.DurationVar(&cfg.flagPurgeRetention, "purge-retention", 0, "Через сколько после удаления URL стирается окончательно (0 - не стирать)")
+	fs.DurationVar(&cfg.flagPurgeInterval, "purge-interval", time.Hour, "Интервал запуска окончательного удаления")
+	fs.IntVar(&cfg.flagPurgeBatchSize, "purge-batch-size", 1000, "Сколько URL стирается за один запрос к БД")
+	fs.StringVar(&cfg.flagSnapshotPath, "snapshot-path", "", "Файл снимка хранилища в памяти (пустая строка отключает снимки)")
+	fs.DurationVar(&cfg.flagSnapshotInterval, "snapshot-interval", 5*time.Minute, "Интервал сохранения снимка хранилища в памяти (0 - только при остановке и по запросу)")
+	fs.IntVar(&cfg.flagCacheSize, "cache-size", 10000, "Сколько результатов поиска по короткому адресу хранить в кэше (0 - без кэша)")
+	fs.DurationVar(&cfg.flagCacheTTL, "cache-ttl", 5*time.Minute, "Время жизни найденного URL в кэше")
+	fs.DurationVar(&cfg.flagCacheNegativeTTL, "cache-negative-ttl", 10*time.Second, "Время жизни в кэше результата \"URL не найден\" (0 - не кэшировать)")
+	fs.Float64Var(&cfg.flagBloomFalsePositiveRate, "bloom-false-positive-rate", 0.01, "Доля несуществующих коротких адресов, которые фильтр Блума пропускает к хранилищу (0 - без фильтра)")
+	fs.DurationVar(&cfg.flagBloomRebuildInterval, "bloom-rebuild-interval", time.Hour, "Интервал перестроения фильтра Блума")
+	fs.StringVar(&cfg.flagMetricsAddr, "metrics-address", "", "Адрес служебного HTTP-сервера с метриками и ручками администрирования без авторизации (пустая строка - не запускать)")
+	fs.StringVar(&cfg.flagTracingExporter, "tracing-exporter", "none", "Экспортер трассировки: none, otlp или stdout")
+	fs.StringVar(&cfg.flagTracingEndpoint, "tracing-endpoint", "", "Адрес коллектора OTLP")
+	fs.StringVar(&cfg.flagTracingFile, "tracing-file", "", "Файл для экспортера трассировки stdout")
+	fs.StringVar(&cfg.flagLogLevel, "log-level", "debug", "Уровень логирования: debug, info, warn, error")
+	fs.StringVar(&cfg.flagLogEncoding, "log-encoding", "console", "Формат логов: json или console")
+	fs.StringVar(&cfg.flagLogOutputs, "log-outputs", "stderr", "Куда писать логи через запятую: stderr, stdout или пути к файлам")
+	fs.IntVar(&cfg.flagLogMaxSizeMB, "log-max-size", 100, "Максимальный размер файла лога в мегабайтах до ротации")
+	fs.IntVar(&cfg.flagLogMaxAgeDays, "log-max-age", 7, "Сколько дней хранить ротированные файлы логов")
+	fs.IntVar(&cfg.flagLogMaxBackups, "log-max-backups", 5, "Сколько ротированных файлов логов хранить")
+	fs.IntVar(&cfg.flagLogSamplingInitial, "log-sampling-initial", 0, "Сколько одинаковых сообщений лога запросов в секунду писать без сэмплирования")
+	fs.StringVar(&cfg.flagDedupScope, "dedup-scope", "global", "Область дедупликации оригинальных URL: global (среди всех пользователей), user (среди URL пользователя) или none")
+	fs.StringVar(&cfg.flagMigrationsMode, "migrations-mode", "auto", "Режим миграций: auto (применять при запуске) или manual (только проверять версию схемы)")
+	fs.IntVar(&cfg.flagLogSamplingThereafter, "log-sampling-thereafter", 100, "Писать каждое N-е сообщение лога запросов после порога")
 }
 
 func parseFile(configPath string, s *logger.Logger) fileConfig {
@@ -121,46 +422,143 @@ func ReadConfigFromFile(filePath string, config *fileConfig) error {
 	}
 	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(config)
+	data, err := io.ReadAll(file)
 	if err != nil {
 		return err
 	}
-
+	if err := json.Unmarshal(data, config); err != nil {
+		return err
+	}
+	// Запоминаем заданные ключи, чтобы нулевые значения из файла тоже применялись.
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	config.set = make(map[string]bool, len(keys))
+	for key := range keys {
+		config.set[key] = true
+	}
 	return nil
 }
 
 func parseFileConfig(fc *fileConfig, c *Config) {
-	if fc.ServerAddress != "" {
+	if fc.set["server_address"] {
 		c.ServerAddress = fc.ServerAddress
 	}
-	if fc.BaseURL != "" {
+	if fc.set["base_url"] {
 		c.BaseURL = fc.BaseURL
 	}
-	if fc.FileStoragePath != "" {
+	if fc.set["file_storage_path"] {
 		c.FileStoragePath = fc.FileStoragePath
 	}
-	if fc.DatabaseDSN != "" {
+	if fc.set["bolt_storage_path"] {
+		c.BoltStoragePath = fc.BoltStoragePath
+	}
+	if fc.set["database_dsn"] {
 		c.DatabaseDSN = fc.DatabaseDSN
 	}
-	if fc.EnableHTTPS {
+	if fc.set["database_replica_dsn"] {
+		c.DatabaseReplicaDSN = fc.DatabaseReplicaDSN
+	}
+	if fc.set["enable_https"] {
 		c.EnableHTTPS = fc.EnableHTTPS
 	}
+	if fc.set["metrics_address"] {
+		c.MetricsAddress = fc.MetricsAddress
+	}
+	if fc.set["cache_size"] {
+		c.CacheSize = fc.CacheSize
+	}
+	if fc.set["bloom_false_positive_rate"] {
+		c.BloomFalsePositiveRate = fc.BloomFalsePositiveRate
+	}
+	if fc.set["tracing_exporter"] {
+		c.TracingExporter = fc.TracingExporter
+	}
+	if fc.set["tracing_endpoint"] {
+		c.TracingEndpoint = fc.TracingEndpoint
+	}
+	if fc.set["tracing_file"] {
+		c.TracingFile = fc.TracingFile
+	}
+	if fc.set["log_level"] {
+		c.LogLevel = fc.LogLevel
+	}
+	if fc.set["log_encoding"] {
+		c.LogEncoding = fc.LogEncoding
+	}
+	if fc.set["log_outputs"] {
+		c.LogOutputs = fc.LogOutputs
+	}
+	if fc.set["migrations_mode"] {
+		c.MigrationsMode = fc.MigrationsMode
+	}
+	if fc.set["dedup_scope"] {
+		c.DedupScope = fc.DedupScope
+	}
 }
 
-func parseEnvConfig(ec *envConfig, c *Config) {
-	if ec.ServerAddress != "" {
+func parseEnvConfig(ec *envConfig, c *Config, isSet func(name string) bool) {
+	if isSet("SERVER_ADDRESS") {
 		c.ServerAddress = ec.ServerAddress
 	}
-	if ec.BaseURL != "" {
+	if isSet("BASE_URL") {
 		c.BaseURL = ec.BaseURL
 	}
-	if ec.FileStoragePath != "" {
+	if isSet("FILE_STORAGE_PATH") {
 		c.FileStoragePath = ec.FileStoragePath
 	}
-	if ec.DatabaseDSN != "" {
+	if isSet("BOLT_STORAGE_PATH") {
+		c.BoltStoragePath = ec.BoltStoragePath
+	}
+	if isSet("DATABASE_DSN") {
 		c.DatabaseDSN = ec.DatabaseDSN
 	}
+	if isSet("DATABASE_REPLICA_DSN") {
+		c.DatabaseReplicaDSN = ec.DatabaseReplicaDSN
+	}
+	if isSet("DB_REPLICA_MAX_LAG") {
+		c.DBReplicaMaxLag = ec.DBReplicaMaxLag
+	}
+	if isSet("DB_REPLICA_CHECK_INTERVAL") {
+		c.DBReplicaCheckInterval = ec.DBReplicaCheckInterval
+	}
+	if isSet("DB_READ_AFTER_WRITE") {
+		c.DBReadAfterWrite = ec.DBReadAfterWrite
+	}
+	if isSet("DB_MAX_CONNS") {
+		c.DBMaxConns = ec.DBMaxConns
+	}
+	if isSet("DB_MIN_CONNS") {
+		c.DBMinConns = ec.DBMinConns
+	}
+	if isSet("DB_MAX_CONN_LIFETIME") {
+		c.DBMaxConnLifetime = ec.DBMaxConnLifetime
+	}
+	if isSet("DB_MAX_CONN_IDLE_TIME") {
+		c.DBMaxConnIdleTime = ec.DBMaxConnIdleTime
+	}
+	if isSet("DB_STATEMENT_TIMEOUT") {
+		c.DBStatementTimeout = ec.DBStatementTimeout
+	}
+	if isSet("DB_QUERY_EXEC_MODE") {
+		c.DBQueryExecMode = ec.DBQueryExecMode
+	}
+	if isSet("DB_RETRY_ATTEMPTS") {
+		c.DBRetryAttempts = ec.DBRetryAttempts
+	}
+	if isSet("DB_RETRY_BASE_DELAY") {
+		c.DBRetryBaseDelay = ec.DBRetryBaseDelay
+	}
+	if isSet("DB_RETRY_MAX_DELAY") {
+		c.DBRetryMaxDelay = ec.DBRetryMaxDelay
+	}
+	if isSet("DB_BREAKER_FAILURES") {
+		c.DBBreakerFailures = ec.DBBreakerFailures
+	}
+	if isSet("DB_BREAKER_OPEN_TIMEOUT") {
+		c.DBBreakerOpenTimeout = ec.DBBreakerOpenTimeout
+	}
 	if ec.enableHTTPS {
 		c.EnableHTTPS = ec.enableHTTPS
 	}
@@ -170,46 +568,278 @@ func parseEnvConfig(ec *envConfig, c *Config) {
 	if ec.certFile != "" {
 		c.CertFile = ec.certFile
 	}
+	if isSet("DELETION_WORKERS") {
+		c.DeletionWorkers = ec.DeletionWorkers
+	}
+	if isSet("DELETION_FLUSH_INTERVAL") {
+		c.DeletionFlushInterval = ec.DeletionFlushInterval
+	}
+	if isSet("DELETION_BATCH_SIZE") {
+		c.DeletionBatchSize = ec.DeletionBatchSize
+	}
+	if isSet("DELETION_QUEUE_SIZE") {
+		c.DeletionQueueSize = ec.DeletionQueueSize
+	}
+	if isSet("PURGE_RETENTION") {
+		c.PurgeRetention = ec.PurgeRetention
+	}
+	if isSet("PURGE_INTERVAL") {
+		c.PurgeInterval = ec.PurgeInterval
+	}
+	if isSet("PURGE_BATCH_SIZE") {
+		c.PurgeBatchSize = ec.PurgeBatchSize
+	}
+	if isSet("SNAPSHOT_PATH") {
+		c.SnapshotPath = ec.SnapshotPath
+	}
+	if isSet("SNAPSHOT_INTERVAL") {
+		c.SnapshotInterval = ec.SnapshotInterval
+	}
+	if isSet("CACHE_SIZE") {
+		c.CacheSize = ec.CacheSize
+	}
+	if isSet("CACHE_TTL") {
+		c.CacheTTL = ec.CacheTTL
+	}
+	if isSet("CACHE_NEGATIVE_TTL") {
+		c.CacheNegativeTTL = ec.CacheNegativeTTL
+	}
+	if isSet("BLOOM_FALSE_POSITIVE_RATE") {
+		c.BloomFalsePositiveRate = ec.BloomFalsePositiveRate
+	}
+	if isSet("BLOOM_REBUILD_INTERVAL") {
+		c.BloomRebuildInterval = ec.BloomRebuildInterval
+	}
+	if isSet("METRICS_ADDRESS") {
+		c.MetricsAddress = ec.MetricsAddress
+	}
+	if isSet("TRACING_EXPORTER") {
+		c.TracingExporter = ec.TracingExporter
+	}
+	if isSet("TRACING_ENDPOINT") {
+		c.TracingEndpoint = ec.TracingEndpoint
+	}
+	if isSet("TRACING_FILE") {
+		c.TracingFile = ec.TracingFile
+	}
+	if isSet("LOG_LEVEL") {
+		c.LogLevel = ec.LogLevel
+	}
+	if isSet("LOG_ENCODING") {
+		c.LogEncoding = ec.LogEncoding
+	}
+	if isSet("LOG_OUTPUTS") {
+		c.LogOutputs = ec.LogOutputs
+	}
+	if isSet("LOG_MAX_SIZE_MB") {
+		c.LogMaxSizeMB = ec.LogMaxSizeMB
+	}
+	if isSet("LOG_MAX_AGE_DAYS") {
+		c.LogMaxAgeDays = ec.LogMaxAgeDays
+	}
+	if isSet("LOG_MAX_BACKUPS") {
+		c.LogMaxBackups = ec.LogMaxBackups
+	}
+	if isSet("LOG_SAMPLING_INITIAL") {
+		c.LogSamplingInitial = ec.LogSamplingInitial
+	}
+	if isSet("LOG_SAMPLING_THEREAFTER") {
+		c.LogSamplingThereafter = ec.LogSamplingThereafter
+	}
+	if isSet("MIGRATIONS_MODE") {
+		c.MigrationsMode = ec.MigrationsMode
+	}
+	if isSet("DEDUP_SCOPE") {
+		c.DedupScope = ec.DedupScope
+	}
 }
 
-func parseArgConfig(ac *argConfig, c *Config) {
-	if ac.flagAAddr != "" {
+func parseArgConfig(ac *argConfig, c *Config, isSet func(name string) bool) {
+	if isSet("a") {
 		c.ServerAddress = ac.flagAAddr
 	}
-	if ac.flagBAddr != "" {
+	if isSet("b") {
 		c.BaseURL = ac.flagBAddr
 	}
-	if ac.flagFAddr != "" {
+	if isSet("f") {
 		c.FileStoragePath = ac.flagFAddr
 	}
-	if ac.flagDAddr != "" {
+	if isSet("bolt-storage-path") {
+		c.BoltStoragePath = ac.flagBoltAddr
+	}
+	if isSet("d") {
 		c.DatabaseDSN = ac.flagDAddr
 	}
-	if ac.flagSAddr {
+	if isSet("database-replica-dsn") {
+		c.DatabaseReplicaDSN = splitList(ac.flagDBReplicaDSN)
+	}
+	if isSet("db-replica-max-lag") {
+		c.DBReplicaMaxLag = ac.flagDBReplicaMaxLag
+	}
+	if isSet("db-replica-check-interval") {
+		c.DBReplicaCheckInterval = ac.flagDBReplicaCheckInterval
+	}
+	if isSet("db-read-after-write") {
+		c.DBReadAfterWrite = ac.flagDBReadAfterWrite
+	}
+	if isSet("db-max-conns") {
+		c.DBMaxConns = ac.flagDBMaxConns
+	}
+	if isSet("db-min-conns") {
+		c.DBMinConns = ac.flagDBMinConns
+	}
+	if isSet("db-max-conn-lifetime") {
+		c.DBMaxConnLifetime = ac.flagDBMaxConnLifetime
+	}
+	if isSet("db-max-conn-idle-time") {
+		c.DBMaxConnIdleTime = ac.flagDBMaxConnIdleTime
+	}
+	if isSet("db-statement-timeout") {
+		c.DBStatementTimeout = ac.flagDBStatementTimeout
+	}
+	if isSet("db-query-exec-mode") {
+		c.DBQueryExecMode = ac.flagDBQueryExecMode
+	}
+	if isSet("db-retry-attempts") {
+		c.DBRetryAttempts = ac.flagDBRetryAttempts
+	}
+	if isSet("db-retry-base-delay") {
+		c.DBRetryBaseDelay = ac.flagDBRetryBaseDelay
+	}
+	if isSet("db-retry-max-delay") {
+		c.DBRetryMaxDelay = ac.flagDBRetryMaxDelay
+	}
+	if isSet("db-breaker-failures") {
+		c.DBBreakerFailures = ac.flagDBBreakerFailures
+	}
+	if isSet("db-breaker-open-timeout") {
+		c.DBBreakerOpenTimeout = ac.flagDBBreakerOpenTimeout
+	}
+	if isSet("s") {
 		c.EnableHTTPS = ac.flagSAddr
 	}
-	if ac.flagKeyAddr != "" {
+	if isSet("key") {
 		c.KeyFile = ac.flagKeyAddr
 	}
-	if ac.flagCertAddr != "" {
+	if isSet("cert") {
 		c.CertFile = ac.flagCertAddr
 	}
+	if isSet("deletion-workers") {
+		c.DeletionWorkers = ac.flagDeletionWorkers
+	}
+	if isSet("deletion-flush-interval") {
+		c.DeletionFlushInterval = ac.flagDeletionFlushInterval
+	}
+	if isSet("deletion-batch-size") {
+		c.DeletionBatchSize = ac.flagDeletionBatchSize
+	}
+	if isSet("deletion-queue-size") {
+		c.DeletionQueueSize = ac.flagDeletionQueueSize
+	}
+	if isSet("purge-retention") {
+		c.PurgeRetention = ac.flagPurgeRetention
+	}
+	if isSet("purge-interval") {
+		c.PurgeInterval = ac.flagPurgeInterval
+	}
+	if isSet("purge-batch-size") {
+		c.PurgeBatchSize = ac.flagPurgeBatchSize
+	}
+	if isSet("snapshot-path") {
+		c.SnapshotPath = ac.flagSnapshotPath
+	}
+	if isSet("snapshot-interval") {
+		c.SnapshotInterval = ac.flagSnapshotInterval
+	}
+	if isSet("cache-size") {
+		c.CacheSize = ac.flagCacheSize
+	}
+	if isSet("cache-ttl") {
+		c.CacheTTL = ac.flagCacheTTL
+	}
+	if isSet("cache-negative-ttl") {
+		c.CacheNegativeTTL = ac.flagCacheNegativeTTL
+	}
+	if isSet("bloom-false-positive-rate") {
+		c.BloomFalsePositiveRate = ac.flagBloomFalsePositiveRate
+	}
+	if isSet("bloom-rebuild-interval") {
+		c.BloomRebuildInterval = ac.flagBloomRebuildInterval
+	}
+	if isSet("metrics-address") {
+		c.MetricsAddress = ac.flagMetricsAddr
+	}
+	if isSet("tracing-exporter") {
+		c.TracingExporter = ac.flagTracingExporter
+	}
+	if isSet("tracing-endpoint") {
+		c.TracingEndpoint = ac.flagTracingEndpoint
+	}
+	if isSet("tracing-file") {
+		c.TracingFile = ac.flagTracingFile
+	}
+	if isSet("log-level") {
+		c.LogLevel = ac.flagLogLevel
+	}
+	if isSet("log-encoding") {
+		c.LogEncoding = ac.flagLogEncoding
+	}
+	if isSet("log-outputs") {
+		c.LogOutputs = splitList(ac.flagLogOutputs)
+	}
+	if isSet("log-max-size") {
+		c.LogMaxSizeMB = ac.flagLogMaxSizeMB
+	}
+	if isSet("log-max-age") {
+		c.LogMaxAgeDays = ac.flagLogMaxAgeDays
+	}
+	if isSet("log-max-backups") {
+		c.LogMaxBackups = ac.flagLogMaxBackups
+	}
+	if isSet("log-sampling-initial") {
+		c.LogSamplingInitial = ac.flagLogSamplingInitial
+	}
+	if isSet("log-sampling-thereafter") {
+		c.LogSamplingThereafter = ac.flagLogSamplingThereafter
+	}
+	if isSet("migrations-mode") {
+		c.MigrationsMode = ac.flagMigrationsMode
+	}
+	if isSet("dedup-scope") {
+		c.DedupScope = ac.flagDedupScope
+	}
 }
 
 // GetConfig возвращает готовый конфиг
 func GetConfig(s *logger.Logger) Config {
 	var configPath string
-	config := Config{}
-	argCfg := parseFlags()
-	envCfg := parseEnvs(s)
+	argCfg, argSet := parseFlags()
+	envCfg, envSet := parseEnvs(s)
 	if envCfg.config != "" {
 		configPath = envCfg.config
 	} else if argCfg.flagCAddr != "" {
 		configPath = argCfg.flagCAddr
 	}
 	fileCfg := parseFile(configPath, s)
+	return buildConfig(argCfg, argSet, fileCfg, envCfg, envSet)
+}
+
+// buildConfig собирает конфиг по приоритету: значения флагов по умолчанию перекрываются файлом,
+// файл - явно заданными флагами, а флаги - переменными окружения. Явно заданные нулевые значения
+// тоже применяются, поэтому настройки вида "0 - отключить" можно задать любым способом.
+func buildConfig(argCfg argConfig, argSet map[string]bool, fileCfg fileConfig, envCfg envConfig, envSet map[string]bool) Config {
+	config := Config{}
+	parseArgConfig(&argCfg, &config, func(string) bool { return true })
 	parseFileConfig(&fileCfg, &config)
-	parseArgConfig(&argCfg, &config)
-	parseEnvConfig(&envCfg, &config)
+	parseArgConfig(&argCfg, &config, func(name string) bool { return argSet[name] })
+	parseEnvConfig(&envCfg, &config, func(name string) bool { return envSet[name] })
 	return config
 }
+
+// splitList разбивает список значений через запятую; пустая строка - пустой список.
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
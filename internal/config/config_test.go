@@ -0,0 +1,91 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseTestFlags разбирает args так же, как командную строку приложения.
+func parseTestFlags(t *testing.T, args ...string) (argConfig, map[string]bool) {
+	t.Helper()
+	var cfg argConfig
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerFlags(fs, &cfg)
+	require.NoError(t, fs.Parse(args))
+	return cfg, visitFlags(fs)
+}
+
+func TestBuildConfig_Defaults(t *testing.T) {
+	argCfg, argSet := parseTestFlags(t)
+	cfg := buildConfig(argCfg, argSet, fileConfig{}, envConfig{}, nil)
+
+	assert.Equal(t, "localhost:8080", cfg.ServerAddress)
+	assert.Equal(t, 10000, cfg.CacheSize)
+	assert.Equal(t, 0.01, cfg.BloomFalsePositiveRate)
+	assert.Empty(t, cfg.MetricsAddress, "Служебный сервер без авторизации должен включаться только явно")
+	assert.Equal(t, []string{"stderr"}, cfg.LogOutputs)
+	assert.Nil(t, cfg.DatabaseReplicaDSN)
+}
+
+func TestBuildConfig_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"server_address": "localhost:8081", "base_url": "http://file", "cache_size": 5}`), 0o600))
+	var fileCfg fileConfig
+	require.NoError(t, ReadConfigFromFile(path, &fileCfg))
+	argCfg, argSet := parseTestFlags(t, "-b", "http://flag", "-cache-size", "7")
+	t.Setenv("CACHE_SIZE", "9")
+	var envCfg envConfig
+	require.NoError(t, env.Parse(&envCfg))
+
+	cfg := buildConfig(argCfg, argSet, fileCfg, envCfg, lookupEnvs())
+
+	assert.Equal(t, "localhost:8081", cfg.ServerAddress, "Файл должен перекрывать значение флага по умолчанию")
+	assert.Equal(t, "http://flag", cfg.BaseURL, "Явно заданный флаг должен перекрывать файл")
+	assert.Equal(t, 9, cfg.CacheSize, "Переменная окружения должна перекрывать флаг")
+}
+
+// TestBuildConfig_ExplicitZeroDisables проверяет, что настройки, которые отключаются нулевым
+// значением, можно отключить флагом, переменной окружения и файлом.
+func TestBuildConfig_ExplicitZeroDisables(t *testing.T) {
+	t.Run("flags", func(t *testing.T) {
+		argCfg, argSet := parseTestFlags(t, "-cache-size=0", "-bloom-false-positive-rate=0", "-metrics-address=")
+		cfg := buildConfig(argCfg, argSet, fileConfig{}, envConfig{}, nil)
+
+		assert.Zero(t, cfg.CacheSize)
+		assert.Zero(t, cfg.BloomFalsePositiveRate)
+		assert.Empty(t, cfg.MetricsAddress)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("CACHE_SIZE", "0")
+		t.Setenv("BLOOM_FALSE_POSITIVE_RATE", "0")
+		t.Setenv("METRICS_ADDRESS", "")
+		var envCfg envConfig
+		require.NoError(t, env.Parse(&envCfg))
+		argCfg, argSet := parseTestFlags(t, "-cache-size=100", "-metrics-address=localhost:9091")
+		cfg := buildConfig(argCfg, argSet, fileConfig{}, envCfg, lookupEnvs())
+
+		assert.Zero(t, cfg.CacheSize)
+		assert.Zero(t, cfg.BloomFalsePositiveRate)
+		assert.Empty(t, cfg.MetricsAddress)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"cache_size": 0, "bloom_false_positive_rate": 0, "metrics_address": ""}`), 0o600))
+		var fileCfg fileConfig
+		require.NoError(t, ReadConfigFromFile(path, &fileCfg))
+		argCfg, argSet := parseTestFlags(t)
+		cfg := buildConfig(argCfg, argSet, fileCfg, envConfig{}, nil)
+
+		assert.Zero(t, cfg.CacheSize)
+		assert.Zero(t, cfg.BloomFalsePositiveRate)
+		assert.Empty(t, cfg.MetricsAddress)
+	})
+}
@@ -0,0 +1,295 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/middlewares"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// Форматы выгрузки и загрузки url
+const (
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+// maxImportBodyBytes максимальный размер тела запроса на загрузку url
+const maxImportBodyBytes = 10 << 20
+
+// exportContentTypes Content-Type ответа для каждого формата выгрузки
+var exportContentTypes = map[string]string{
+	formatCSV:    "text/csv; charset=utf-8",
+	formatJSON:   "application/json",
+	formatNDJSON: "application/x-ndjson",
+}
+
+// csvColumns колонки csv-выгрузки
+var csvColumns = []string{"short_url", "original_url", "is_deleted", "created_at", "updated_at", "deleted_at"}
+
+// urlExportEncoder последовательно записывает url выгрузки в ответ
+type urlExportEncoder interface {
+	Encode(models.ExportedURL) error
+	Close() error
+}
+
+// ExportURLs Потоково выгружает все url пользователя в формате csv, json или ndjson (параметр format, по умолчанию json)
+func (c URLShortenerController) ExportURLs(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatJSON
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		c.handleError(r.Context(), w, fmt.Errorf("unknown format %q", format), http.StatusBadRequest, "invalid export request: %s", nil)
+		return
+	}
+
+	// Ответ начинается с первым url, чтобы ошибку хранилища до него можно было вернуть кодом 500
+	var enc urlExportEncoder
+	start := func() {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+		w.WriteHeader(http.StatusOK)
+		enc = newURLExportEncoder(format, w)
+	}
+	user, _ := middlewares.GetUserFromContext(r.Context())
+	err := c.shortener.ExportURLs(r.Context(), user, func(exported models.ExportedURL) error {
+		if enc == nil {
+			start()
+		}
+		return enc.Encode(exported)
+	})
+	if err != nil && enc == nil {
+		if c.handleStorageUnavailable(r.Context(), w, err) {
+			return
+		}
+		c.handleError(r.Context(), w, err, http.StatusInternalServerError, "Shortener service error: %s", nil)
+		return
+	}
+	if err != nil {
+		// Заголовки уже отправлены, поэтому выгрузка просто обрывается
+		c.logger.With(r.Context()).Errorf("export interrupted: %v", err)
+		return
+	}
+	if enc == nil {
+		start()
+	}
+	if err := enc.Close(); err != nil {
+		c.logger.With(r.Context()).Errorf("cannot finish export: %v", err)
+	}
+}
+
+// ImportURLs Загружает url пользователя в формате выгрузки и возвращает результат по каждой строке.
+// Формат берется из параметра format, а без него определяется по Content-Type
+func (c URLShortenerController) ImportURLs(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importFormatFromContentType(r.Header.Get("Content-Type"))
+	}
+	records, err := decodeURLImport(format, http.MaxBytesReader(w, r.Body, maxImportBodyBytes))
+	if err != nil {
+		c.handleError(r.Context(), w, err, http.StatusBadRequest, "invalid import request: %s", nil)
+		return
+	}
+	user, _ := middlewares.GetUserFromContext(r.Context())
+	results, err := c.shortener.ImportURLs(r.Context(), user, records)
+	if err != nil {
+		if c.handleStorageUnavailable(r.Context(), w, err) {
+			return
+		}
+		c.handleError(r.Context(), w, err, http.StatusInternalServerError, "Shortener service error: %s", nil)
+		return
+	}
+	c.writeJSONResponse(r.Context(), w, http.StatusOK, results)
+}
+
+// importFormatFromContentType определяет формат загрузки по Content-Type
+func importFormatFromContentType(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "text/csv"):
+		return formatCSV
+	case strings.Contains(contentType, "ndjson"):
+		return formatNDJSON
+	default:
+		return formatJSON
+	}
+}
+
+// newURLExportEncoder создает кодировщик выгрузки в указанном формате
+func newURLExportEncoder(format string, w io.Writer) urlExportEncoder {
+	switch format {
+	case formatCSV:
+		return &csvExportEncoder{w: csv.NewWriter(w)}
+	case formatNDJSON:
+		return ndjsonExportEncoder{enc: json.NewEncoder(w)}
+	default:
+		return &jsonExportEncoder{w: w}
+	}
+}
+
+// csvExportEncoder записывает url строками csv с заголовком
+type csvExportEncoder struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+// Encode записывает url строкой csv
+func (e *csvExportEncoder) Encode(exported models.ExportedURL) error {
+	if !e.headerWritten {
+		e.headerWritten = true
+		if err := e.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	deletedAt := ""
+	if exported.DeletedAt != nil {
+		deletedAt = exported.DeletedAt.Format(time.RFC3339Nano)
+	}
+	return e.w.Write([]string{
+		exported.ShortURL,
+		exported.OriginalURL,
+		strconv.FormatBool(exported.Deleted),
+		exported.CreatedAt.Format(time.RFC3339Nano),
+		exported.UpdatedAt.Format(time.RFC3339Nano),
+		deletedAt,
+	})
+}
+
+// Close дописывает заголовок пустой выгрузки и сбрасывает буфер
+func (e *csvExportEncoder) Close() error {
+	if !e.headerWritten {
+		e.headerWritten = true
+		if err := e.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonExportEncoder записывает url элементами json-массива
+type jsonExportEncoder struct {
+	w       io.Writer
+	started bool
+}
+
+// Encode записывает url очередным элементом массива
+func (e *jsonExportEncoder) Encode(exported models.ExportedURL) error {
+	data, err := json.Marshal(exported)
+	if err != nil {
+		return err
+	}
+	prefix := ","
+	if !e.started {
+		e.started, prefix = true, "["
+	}
+	_, err = io.WriteString(e.w, prefix+string(data))
+	return err
+}
+
+// Close закрывает массив
+func (e *jsonExportEncoder) Close() error {
+	suffix := "]\n"
+	if !e.started {
+		suffix = "[]\n"
+	}
+	_, err := io.WriteString(e.w, suffix)
+	return err
+}
+
+// ndjsonExportEncoder записывает каждый url отдельной строкой json
+type ndjsonExportEncoder struct {
+	enc *json.Encoder
+}
+
+// Encode записывает url строкой json
+func (e ndjsonExportEncoder) Encode(exported models.ExportedURL) error {
+	return e.enc.Encode(exported)
+}
+
+// Close ничего не делает: строки ndjson не требуют завершения
+func (e ndjsonExportEncoder) Close() error {
+	return nil
+}
+
+// decodeURLImport разбирает url для загрузки в указанном формате
+func decodeURLImport(format string, body io.Reader) ([]models.ExportedURL, error) {
+	switch format {
+	case formatCSV:
+		return decodeCSVImport(body)
+	case formatNDJSON:
+		var records []models.ExportedURL
+		dec := json.NewDecoder(body)
+		for {
+			var record models.ExportedURL
+			err := dec.Decode(&record)
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", len(records)+1, err)
+			}
+			records = append(records, record)
+		}
+	case formatJSON:
+		var records []models.ExportedURL
+		if err := json.NewDecoder(body).Decode(&records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// decodeCSVImport разбирает csv с заголовком; обязательна только колонка original_url,
+// неизвестные колонки и метки времени игнорируются
+func decodeCSVImport(body io.Reader) ([]models.ExportedURL, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, errors.New("csv header has no original_url column")
+	}
+	value := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var records []models.ExportedURL
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		deleted, _ := strconv.ParseBool(value(row, "is_deleted"))
+		records = append(records, models.ExportedURL{
+			ShortURL:    value(row, "short_url"),
+			OriginalURL: value(row, "original_url"),
+			Deleted:     deleted,
+		})
+	}
+}
@@ -1,32 +1,52 @@
 package controller
 
 import (
-	"context"
-	"database/sql"
+	"encoding/json"
 	"net/http"
-	"time"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/health"
 )
 
 // HealthCheckController Контроллер для проверки «состояния здоровья» системы
 type HealthCheckController struct {
-	db *sql.DB
+	checker *health.Checker
 }
 
-// Ping проверяет:
-// 1. подключение к БД
+// Ping выполняет те же проверки, что и Readyz, включая доступность выбранного хранилища,
+// и возвращает 500, если хотя бы одна из них не пройдена.
 func (hc HealthCheckController) Ping(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	if err := hc.db.PingContext(ctx); err != nil {
-		http.Error(w, "Failed to connect to the database", http.StatusInternalServerError)
+	if report := hc.checker.Run(r.Context()); report.Status != health.StatusOK {
+		http.Error(w, "Storage is unavailable", http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// Healthz сообщает, что процесс жив и обрабатывает запросы.
+func (hc HealthCheckController) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, http.StatusOK, health.Report{Status: health.StatusOK, Checks: []health.CheckResult{}})
+}
+
+// Readyz выполняет проверки зависимостей и сообщает, готово ли приложение принимать трафик.
+// Если хотя бы одна проверка не пройдена, возвращается 503.
+func (hc HealthCheckController) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := hc.checker.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealthResponse(w, status, report)
+}
+
+// writeHealthResponse записывает отчет о проверках в формате JSON.
+func writeHealthResponse(w http.ResponseWriter, status int, report health.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
 // NewHealthCheckController создает HealthCheckController
-func NewHealthCheckController(db *sql.DB) *HealthCheckController {
-	return &HealthCheckController{db: db}
+func NewHealthCheckController(checker *health.Checker) *HealthCheckController {
+	return &HealthCheckController{checker: checker}
 }
@@ -2,16 +2,24 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/middlewares"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/workers"
@@ -20,21 +28,29 @@ import (
 // URLShortener Интерфейс сервиса сокращения ссылок
 type URLShortener interface {
 	// AddURL добавление url
-	AddURL(urlStr string) (models.SavedURL, error)
+	AddURL(ctx context.Context, urlStr string, user models.User) (models.SavedURL, error)
 	// AddBatchURL добавление списка url
-	AddBatchURL(batchArray []models.ShortenBatchURLRequestElement) ([]models.CorrelationSavedURL, error)
+	AddBatchURL(ctx context.Context, batchArray []models.ShortenBatchURLRequestElement, user models.User) ([]models.CorrelationSavedURL, error)
 	// AddUserToURL присвоение url пользователю
-	AddUserToURL(SavedURL models.SavedURL, user models.User) error
+	AddUserToURL(ctx context.Context, SavedURL models.SavedURL, user models.User) error
 	// AddBatchUserToURL присвоение списка url пользователю
-	AddBatchUserToURL(SavedURLs []models.SavedURL, user models.User) error
+	AddBatchUserToURL(ctx context.Context, SavedURLs []models.SavedURL, user models.User) error
 	// GetURL Получение url по короткой ссылке
-	GetURL(shortURL string) (models.URLRow, bool)
+	GetURL(ctx context.Context, shortURL string) (models.URLRow, bool, error)
 	// GetURLByUser Получение всех url, присвоенных пользователю
-	GetURLByUser(user models.User) ([]models.URLByUserResponseElement, bool)
+	GetURLByUser(ctx context.Context, user models.User) ([]models.URLByUserResponseElement, error)
+	// GetURLByUserPage Получение страницы url пользователя с сортировкой и фильтрами
+	GetURLByUserPage(ctx context.Context, user models.User, opts models.URLQueryOptions) ([]models.URLByUserResponseElement, string, error)
 	// GetURLByOriginalURL Получение короткой ссылки для url
-	GetURLByOriginalURL(originalURL string) (string, bool)
+	GetURLByOriginalURL(ctx context.Context, originalURL string, user models.User) (string, bool)
+	// UpdateURL изменение исходного url короткой ссылки пользователя
+	UpdateURL(ctx context.Context, shortURL string, originalURL string, user models.User) (models.UpdateURLResponse, error)
+	// ExportURLs передача всех url пользователя в fn в порядке создания
+	ExportURLs(ctx context.Context, user models.User, fn func(models.ExportedURL) error) error
+	// ImportURLs загрузка url пользователя с результатом по каждой строке
+	ImportURLs(ctx context.Context, user models.User, records []models.ExportedURL) ([]models.ImportURLResult, error)
 	// DeleteBatchURL удаление списка url
-	DeleteBatchURL(urls []string, user models.User) error
+	DeleteBatchURL(ctx context.Context, urls []string, user models.User) error
 	// ConvertCorrelationSavedURLsToResponse преобразование модели данных []models.CorrelationSavedURL
 	// в response-модель []models.ShortenBatchURLResponseElement для API-хелдлера
 	ConvertCorrelationSavedURLsToResponse(correlationSavedURLs []models.CorrelationSavedURL) []models.ShortenBatchURLResponseElement
@@ -54,147 +70,321 @@ type URLShortenerController struct {
 func (c URLShortenerController) SaveURL(w http.ResponseWriter, r *http.Request) {
 	bytes, _ := io.ReadAll(r.Body)
 	urlStr := string(bytes)
-	savedURL, err := c.shortener.AddURL(urlStr)
+	user, _ := middlewares.GetUserFromContext(r.Context())
+	savedURL, err := c.shortener.AddURL(r.Context(), urlStr, user)
 	if err != nil {
-		c.handleShortenerServiceError(w, err, urlStr, "text")
+		c.handleShortenerServiceError(r.Context(), w, err, urlStr, "text")
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "%v", savedURL.ShortURL)
-	user, _ := middlewares.GetUserFromContext(r.Context())
-	if err := c.shortener.AddUserToURL(savedURL, user); err != nil {
-		c.handleError(w, err, http.StatusInternalServerError, "something went wrong: %s", nil)
-	}
 }
 
 // DeleteBatchURL Удаляет список url
 func (c URLShortenerController) DeleteBatchURL(w http.ResponseWriter, r *http.Request) {
+	c.sendBatchToWorker(w, r, workers.OperationDelete)
+}
+
+// RestoreBatchURL Восстанавливает список удаленных url пользователя
+func (c URLShortenerController) RestoreBatchURL(w http.ResponseWriter, r *http.Request) {
+	c.sendBatchToWorker(w, r, workers.OperationRestore)
+}
+
+// sendBatchToWorker ставит операцию над списком url из тела запроса в очередь фонового пула
+func (c URLShortenerController) sendBatchToWorker(w http.ResponseWriter, r *http.Request, operation workers.Operation) {
 	var urls []string
 	if err := json.NewDecoder(r.Body).Decode(&urls); err != nil {
-		c.handleError(w, err, http.StatusInternalServerError, "cannot decode request JSON body: %s", nil)
+		c.handleError(r.Context(), w, err, http.StatusInternalServerError, "cannot decode request JSON body: %s", nil)
 		return
 	}
 	user, _ := middlewares.GetUserFromContext(r.Context())
-	req := workers.DeletionRequest{User: user, URLs: urls}
+	requestID, _ := logger.RequestIDFromContext(r.Context())
+	req := workers.DeletionRequest{
+		Operation:   operation,
+		User:        user,
+		URLs:        urls,
+		SpanContext: trace.SpanContextFromContext(r.Context()),
+		RequestID:   requestID,
+	}
 	if err := c.worker.SendDeletionRequestToWorker(req); err != nil {
-		c.handleError(w, err, http.StatusInternalServerError, "error sending to deletion worker request: %s", nil)
+		var queueErr *apperrors.DeletionQueueIsFull
+		if errors.As(err, &queueErr) {
+			w.Header().Set("Retry-After", retryAfterSeconds(queueErr.RetryAfter))
+			c.handleError(r.Context(), w, err, http.StatusServiceUnavailable, "error sending to deletion worker request: %s", nil)
+			return
+		}
+		c.handleError(r.Context(), w, err, http.StatusInternalServerError, "error sending to deletion worker request: %s", nil)
 	} else {
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
 
+// handleStorageUnavailable отвечает 503 с заголовком Retry-After, если хранилище временно недоступно,
+// и сообщает, была ли ошибка обработана
+func (c URLShortenerController) handleStorageUnavailable(ctx context.Context, w http.ResponseWriter, err error) bool {
+	var unavailableErr *apperrors.StorageUnavailable
+	if !errors.As(err, &unavailableErr) {
+		return false
+	}
+	w.Header().Set("Retry-After", retryAfterSeconds(unavailableErr.RetryAfter))
+	c.handleError(ctx, w, err, http.StatusServiceUnavailable, "Shortener service error: %s", nil)
+	return true
+}
+
+// retryAfterSeconds переводит длительность в значение заголовка Retry-After (целое число секунд, не меньше 1)
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
 // GetURLByID возвращает url на основе короткой ссылки
 func (c URLShortenerController) GetURLByID(w http.ResponseWriter, r *http.Request) {
 	shortURL := chi.URLParam(r, "shortURL")
-	urlRow, ok := c.shortener.GetURL(shortURL)
+	urlRow, ok, err := c.shortener.GetURL(r.Context(), shortURL)
+	if err != nil {
+		metrics.RedirectsTotal.WithLabelValues("error").Inc()
+		if !c.handleStorageUnavailable(r.Context(), w, err) {
+			c.handleError(r.Context(), w, err, http.StatusInternalServerError, "Shortener service error: %s", nil)
+		}
+		return
+	}
 	if urlRow.DeletedFlag {
+		metrics.RedirectsTotal.WithLabelValues("gone").Inc()
 		w.WriteHeader(http.StatusGone)
 		return
 	}
 	if ok {
+		metrics.RedirectsTotal.WithLabelValues("hit").Inc()
 		w.Header().Set("Location", urlRow.OriginalURL)
 		w.WriteHeader(http.StatusTemporaryRedirect)
 	} else {
-		w.WriteHeader(http.StatusBadRequest)
+		metrics.RedirectsTotal.WithLabelValues("miss").Inc()
+		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
-// GetURLByUser возвращает список url, которые пользователь загрузил в систему
+// maxURLsPageLimit максимальный размер страницы списка url пользователя
+const maxURLsPageLimit = 1000
+
+// nextCursorHeader заголовок ответа с курсором следующей страницы списка url пользователя
+const nextCursorHeader = "X-Next-Cursor"
+
+// GetURLByUser возвращает список url, которые пользователь загрузил в систему.
+// Без параметров запроса отдается полный список; с параметрами limit, cursor, sort, search, status,
+// created_after и created_before - страница списка, а курсор следующей страницы передается в заголовке X-Next-Cursor
 func (c URLShortenerController) GetURLByUser(w http.ResponseWriter, r *http.Request) {
 	user, _ := middlewares.GetUserFromContext(r.Context())
-	resp, ok := c.shortener.GetURLByUser(user)
-	if ok {
-		c.writeJSONResponse(w, http.StatusOK, resp)
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
+	if r.URL.RawQuery != "" {
+		c.getURLByUserPage(w, r, user)
+		return
 	}
+	resp, err := c.shortener.GetURLByUser(r.Context(), user)
+	if err != nil {
+		if !c.handleStorageUnavailable(r.Context(), w, err) {
+			c.handleError(r.Context(), w, err, http.StatusInternalServerError, "Shortener service error: %s", nil)
+		}
+		return
+	}
+	c.writeJSONResponse(r.Context(), w, http.StatusOK, resp)
+}
+
+// getURLByUserPage отдает страницу списка url пользователя по параметрам запроса
+func (c URLShortenerController) getURLByUserPage(w http.ResponseWriter, r *http.Request, user models.User) {
+	opts, err := parseURLQueryOptions(r.URL.Query())
+	if err != nil {
+		c.handleError(r.Context(), w, err, http.StatusBadRequest, "invalid query options: %s", nil)
+		return
+	}
+	resp, nextCursor, err := c.shortener.GetURLByUserPage(r.Context(), user, opts)
+	if err != nil {
+		var optsErr *apperrors.InvalidQueryOptions
+		if errors.As(err, &optsErr) {
+			c.handleError(r.Context(), w, err, http.StatusBadRequest, "invalid query options: %s", nil)
+			return
+		}
+		if c.handleStorageUnavailable(r.Context(), w, err) {
+			return
+		}
+		c.handleError(r.Context(), w, err, http.StatusInternalServerError, "Shortener service error: %s", nil)
+		return
+	}
+	if nextCursor != "" {
+		w.Header().Set(nextCursorHeader, nextCursor)
+	}
+	c.writeJSONResponse(r.Context(), w, http.StatusOK, resp)
+}
+
+// parseURLQueryOptions разбирает параметры запроса списка url пользователя.
+// sort принимает created_at или original_url, с префиксом "-" для сортировки по убыванию;
+// status принимает live, deleted или all; даты передаются в формате RFC 3339
+func parseURLQueryOptions(query url.Values) (models.URLQueryOptions, error) {
+	opts := models.URLQueryOptions{
+		Cursor: query.Get("cursor"),
+		Search: query.Get("search"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > maxURLsPageLimit {
+			return opts, &apperrors.InvalidQueryOptions{Reason: fmt.Sprintf("limit must be between 1 and %d", maxURLsPageLimit)}
+		}
+		opts.Limit = value
+	}
+	if sortBy := query.Get("sort"); sortBy != "" {
+		opts.Descending = strings.HasPrefix(sortBy, "-")
+		opts.SortBy = strings.TrimPrefix(sortBy, "-")
+	}
+	switch query.Get("status") {
+	case "", "all":
+	case "live":
+		deleted := false
+		opts.Deleted = &deleted
+	case "deleted":
+		deleted := true
+		opts.Deleted = &deleted
+	default:
+		return opts, &apperrors.InvalidQueryOptions{Reason: "status must be live, deleted or all"}
+	}
+	for name, target := range map[string]**time.Time{
+		"created_after":  &opts.CreatedAfter,
+		"created_before": &opts.CreatedBefore,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, &apperrors.InvalidQueryOptions{Reason: name + " must be an RFC 3339 timestamp"}
+		}
+		*target = &parsed
+	}
+	return opts, nil
 }
 
 // ShortenURL Принимает url и возвращает короткую ссылку (ожидает url в json body)
 func (c URLShortenerController) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	var req models.ShortenURLRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		c.handleError(w, err, http.StatusInternalServerError, "cannot decode request JSON body: %s", nil)
+		c.handleError(r.Context(), w, err, http.StatusInternalServerError, "cannot decode request JSON body: %s", nil)
 		return
 	}
-	savedURL, err := c.shortener.AddURL(req.URL)
+	user, _ := middlewares.GetUserFromContext(r.Context())
+	savedURL, err := c.shortener.AddURL(r.Context(), req.URL, user)
 	if err != nil {
-		c.handleShortenerServiceError(w, err, req.URL, "json")
+		c.handleShortenerServiceError(r.Context(), w, err, req.URL, "json")
 		return
 	}
 	resp := models.ShortenURLResponse{Result: savedURL.ShortURL}
-	user, _ := middlewares.GetUserFromContext(r.Context())
-	if err := c.shortener.AddUserToURL(savedURL, user); err != nil {
-		c.handleError(w, err, http.StatusInternalServerError, "something went wrong: %s", nil)
-		return
-	}
-	c.writeJSONResponse(w, http.StatusCreated, resp)
+	c.writeJSONResponse(r.Context(), w, http.StatusCreated, resp)
 }
 
 // ShortenBatchURL Принимает список url в формате json и возвращает список коротких ссылок
 func (c URLShortenerController) ShortenBatchURL(w http.ResponseWriter, r *http.Request) {
 	var req []models.ShortenBatchURLRequestElement
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		c.handleError(w, err, http.StatusInternalServerError, "cannot decode request JSON body: %s", nil)
+		c.handleError(r.Context(), w, err, http.StatusInternalServerError, "cannot decode request JSON body: %s", nil)
 		return
 	}
 	user, _ := middlewares.GetUserFromContext(r.Context())
-	correlationSavedURLs, err := c.shortener.AddBatchURL(req)
+	correlationSavedURLs, err := c.shortener.AddBatchURL(r.Context(), req, user)
 	resp := c.shortener.ConvertCorrelationSavedURLsToResponse(correlationSavedURLs)
 	if err != nil {
-		c.handleError(w, err, http.StatusInternalServerError, "Shortener service error: %s", nil)
+		if c.handleStorageUnavailable(r.Context(), w, err) {
+			return
+		}
+		c.handleError(r.Context(), w, err, http.StatusInternalServerError, "Shortener service error: %s", nil)
+		return
+	}
+	c.writeJSONResponse(r.Context(), w, http.StatusCreated, resp)
+}
+
+// UpdateURL Меняет исходный url короткой ссылки, принадлежащей пользователю (ожидает url в json body)
+func (c URLShortenerController) UpdateURL(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.handleError(r.Context(), w, err, http.StatusBadRequest, "cannot decode request JSON body: %s", nil)
 		return
 	}
-	savedURLs := c.shortener.ConvertCorrelationSavedURLsToSavedURL(correlationSavedURLs)
-	if err := c.shortener.AddBatchUserToURL(savedURLs, user); err != nil {
-		c.handleError(w, err, http.StatusInternalServerError, "something went wrong: %s", nil)
+	if req.URL == "" {
+		c.handleError(r.Context(), w, errors.New("url is empty"), http.StatusBadRequest, "invalid request: %s", nil)
 		return
 	}
-	c.writeJSONResponse(w, http.StatusCreated, resp)
+	user, _ := middlewares.GetUserFromContext(r.Context())
+	resp, err := c.shortener.UpdateURL(r.Context(), chi.URLParam(r, "shortURL"), req.URL, user)
+	if err != nil {
+		c.handleUpdateURLError(r.Context(), w, err, req.URL)
+		return
+	}
+	c.writeJSONResponse(r.Context(), w, http.StatusOK, resp)
+}
+
+// handleUpdateURLError обрабатывает ошибки изменения ссылки; конфликт дедупликации обрабатывается как при создании
+func (c URLShortenerController) handleUpdateURLError(ctx context.Context, w http.ResponseWriter, err error, urlStr string) {
+	var notFoundErr *apperrors.URLNotFound
+	var accessErr *apperrors.URLAccessDenied
+	var deletedErr *apperrors.URLIsDeleted
+	switch {
+	case errors.As(err, &notFoundErr):
+		c.handleError(ctx, w, err, http.StatusNotFound, "Shortener service error: %s", nil)
+	case errors.As(err, &accessErr):
+		c.handleError(ctx, w, err, http.StatusForbidden, "Shortener service error: %s", nil)
+	case errors.As(err, &deletedErr):
+		c.handleError(ctx, w, err, http.StatusGone, "Shortener service error: %s", nil)
+	default:
+		c.handleShortenerServiceError(ctx, w, err, urlStr, "json")
+	}
 }
 
 // handleError обарабатывает ошибки, возникающие при вызове методов в контроллере
-func (c URLShortenerController) handleError(w http.ResponseWriter, err error, statusCode int, logMessage string, resp interface{}) {
-	c.logger.Debugf(logMessage, err)
+func (c URLShortenerController) handleError(ctx context.Context, w http.ResponseWriter, err error, statusCode int, logMessage string, resp interface{}) {
+	requestLogger := c.logger.With(ctx)
+	requestLogger.Debugf(logMessage, err)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	if resp != nil {
 		enc := json.NewEncoder(w)
 		if encodeErr := enc.Encode(resp); encodeErr != nil {
-			c.logger.Debugf("cannot encode response JSON body: %s", encodeErr)
+			requestLogger.Debugf("cannot encode response JSON body: %s", encodeErr)
 		}
 	}
 }
 
 // handleShortenerServiceError обарабатывает специфичные ошибки URLShortener сервиса
-func (c URLShortenerController) handleShortenerServiceError(w http.ResponseWriter, err error, urlStr string, responseType string) {
+func (c URLShortenerController) handleShortenerServiceError(ctx context.Context, w http.ResponseWriter, err error, urlStr string, responseType string) {
 	var appError *apperrors.OriginalURLAlreadyExists
 	if ok := errors.As(err, &appError); ok {
-		c.logger.Debugf("Shortener service error: %s", err)
-		value, ok := c.shortener.GetURLByOriginalURL(urlStr)
+		c.logger.With(ctx).Debugf("Shortener service error: %s", err)
+		// Ищутся только неудаленные ссылки: удаленную ссылку, отвечающую 410, возвращать нельзя.
+		user, _ := middlewares.GetUserFromContext(ctx)
+		value, ok := c.shortener.GetURLByOriginalURL(ctx, urlStr, user)
 		if !ok {
-			w.WriteHeader(http.StatusBadRequest)
+			// Конфликтующая ссылка успела удалиться, клиенту стоит повторить запрос.
+			c.handleError(ctx, w, err, http.StatusInternalServerError, "conflicting URL is no longer available: %s", nil)
+			return
 		}
 		if responseType == "json" {
 			resp := models.ShortenURLResponse{Result: value}
-			c.writeJSONResponse(w, http.StatusConflict, resp)
+			c.writeJSONResponse(ctx, w, http.StatusConflict, resp)
 		} else {
 			w.WriteHeader(http.StatusConflict)
 			fmt.Fprintf(w, "%v", value)
 		}
-	} else {
-		c.logger.Debugf("Shortener service error: %s", err)
+	} else if !c.handleStorageUnavailable(ctx, w, err) {
+		c.logger.With(ctx).Debugf("Shortener service error: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
 // writeJSONResponse отправляет json ответ с хэндлингом ошибки
-func (c URLShortenerController) writeJSONResponse(w http.ResponseWriter, statusCode int, responseBody interface{}) {
+func (c URLShortenerController) writeJSONResponse(ctx context.Context, w http.ResponseWriter, statusCode int, responseBody interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	if responseBody != nil {
 		if err := json.NewEncoder(w).Encode(responseBody); err != nil {
-			c.handleError(w, err, http.StatusInternalServerError, "cannot encode response JSON body: %s", nil)
+			c.handleError(ctx, w, err, http.StatusInternalServerError, "cannot encode response JSON body: %s", nil)
 		}
 	}
 }
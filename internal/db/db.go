@@ -2,28 +2,106 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 
 	"github.com/pressly/goose/v3"
 
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/migrations"
 )
 
-const migrationsDir = "./migrations"
+// Режимы применения миграций при запуске сервера.
+const (
+	MigrationsModeAuto   = "auto"   // Миграции применяются автоматически при запуске.
+	MigrationsModeManual = "manual" // Миграции применяются командой migrate, сервер только проверяет версию схемы.
+)
+
+// Команды подкоманды migrate.
+const (
+	MigrateUp     = "up"     // Применить все новые миграции.
+	MigrateDown   = "down"   // Откатить последнюю миграцию.
+	MigrateStatus = "status" // Показать состояние миграций.
+	MigrateRedo   = "redo"   // Откатить и заново применить последнюю миграцию.
+)
+
+// migrationsDir каталог с миграциями внутри встроенной файловой системы.
+const migrationsDir = "."
 
-// InitDB инициализирует БД
-func InitDB(DatabaseDSN string, sugar *logger.Logger) (*sql.DB, error) {
+func init() {
+	goose.SetBaseFS(migrations.FS)
+}
+
+// InitDB инициализирует БД. В режиме auto применяет миграции,
+// в режиме manual возвращает ошибку, если схема БД отстает от миграций.
+func InitDB(DatabaseDSN string, migrationsMode string, sugar *logger.Logger) (*sql.DB, error) {
 	db, err := sql.Open("pgx", DatabaseDSN)
 	if err != nil {
-		sugar.Errorf("Server error: %v", err)
 		return nil, err
 	}
 
-	if DatabaseDSN != "" {
+	if DatabaseDSN == "" {
+		return db, nil
+	}
+
+	if err := prepareSchema(db, migrationsMode, sugar); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// prepareSchema в режиме auto применяет миграции, в режиме manual проверяет, что схема БД не отстает от них.
+func prepareSchema(db *sql.DB, migrationsMode string, sugar *logger.Logger) error {
+	switch migrationsMode {
+	case "", MigrationsModeAuto:
 		if err := goose.Up(db, migrationsDir); err != nil {
-			sugar.Fatalf("goose Up failed: %v\n", err)
+			return fmt.Errorf("goose up failed: %w", err)
 		}
+	case MigrationsModeManual:
+		if err := CheckSchemaVersion(context.Background(), db); err != nil {
+			return err
+		}
+		sugar.Infoln("Automatic migrations are disabled, database schema is up to date")
+	default:
+		return fmt.Errorf("unknown migrations mode: %s", migrationsMode)
 	}
+	return nil
+}
 
-	return db, nil
+// CheckSchemaVersion возвращает ошибку, если к БД применены не все встроенные миграции.
+func CheckSchemaVersion(ctx context.Context, db *sql.DB) error {
+	migrations, err := goose.CollectMigrations(migrationsDir, 0, goose.MaxVersion)
+	if err != nil {
+		return err
+	}
+	last, err := migrations.Last()
+	if err != nil {
+		return err
+	}
+	current, err := goose.GetDBVersionContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current < last.Version {
+		return fmt.Errorf("database schema version %d is behind latest migration %d, run `shortener migrate up`", current, last.Version)
+	}
+	return nil
+}
+
+// Migrate выполняет команду управления миграциями: up, down, status или redo.
+func Migrate(ctx context.Context, db *sql.DB, command string) error {
+	switch command {
+	case MigrateUp:
+		return goose.UpContext(ctx, db, migrationsDir)
+	case MigrateDown:
+		return goose.DownContext(ctx, db, migrationsDir)
+	case MigrateStatus:
+		return goose.StatusContext(ctx, db, migrationsDir)
+	case MigrateRedo:
+		return goose.RedoContext(ctx, db, migrationsDir)
+	default:
+		return fmt.Errorf("unknown migrate command: %s", command)
+	}
 }
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+)
+
+// Режимы выполнения запросов пулом; названия совпадают с параметром default_query_exec_mode строки подключения pgx.
+const (
+	QueryExecModeCacheStatement = "cache_statement" // Запросы подготавливаются один раз на соединение и кэшируются.
+	QueryExecModeCacheDescribe  = "cache_describe"  // Кэшируется только описание параметров и результата запроса.
+	QueryExecModeDescribeExec   = "describe_exec"   // Каждый запрос описывается и выполняется заново.
+	QueryExecModeExec           = "exec"            // Запрос выполняется без подготовки; совместим с pgbouncer.
+	QueryExecModeSimpleProtocol = "simple_protocol" // Простой протокол с подстановкой параметров на клиенте.
+)
+
+var queryExecModes = map[string]pgx.QueryExecMode{
+	QueryExecModeCacheStatement: pgx.QueryExecModeCacheStatement,
+	QueryExecModeCacheDescribe:  pgx.QueryExecModeCacheDescribe,
+	QueryExecModeDescribeExec:   pgx.QueryExecModeDescribeExec,
+	QueryExecModeExec:           pgx.QueryExecModeExec,
+	QueryExecModeSimpleProtocol: pgx.QueryExecModeSimpleProtocol,
+}
+
+// PoolOptions настройки пула подключений. Нулевые значения оставляют настройки
+// из строки подключения или значения pgxpool по умолчанию.
+type PoolOptions struct {
+	MaxConns         int32         // Максимальное количество соединений.
+	MinConns         int32         // Количество соединений, которые пул держит открытыми.
+	MaxConnLifetime  time.Duration // Время, после которого соединение закрывается.
+	MaxConnIdleTime  time.Duration // Время простоя, после которого соединение закрывается.
+	StatementTimeout time.Duration // Ограничение времени выполнения запроса на стороне БД.
+	QueryExecMode    string        // Режим выполнения запросов, одна из констант QueryExecMode*.
+}
+
+// poolConfig разбирает строку подключения и применяет к ней настройки пула.
+func poolConfig(DatabaseDSN string, opts PoolOptions) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(DatabaseDSN)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = opts.MaxConns
+	}
+	if opts.MinConns > 0 {
+		cfg.MinConns = opts.MinConns
+	}
+	if cfg.MinConns > cfg.MaxConns {
+		return nil, fmt.Errorf("min connections %d exceed max connections %d", cfg.MinConns, cfg.MaxConns)
+	}
+	if opts.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+	if opts.StatementTimeout > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+	if opts.QueryExecMode != "" {
+		mode, ok := queryExecModes[opts.QueryExecMode]
+		if !ok {
+			return nil, fmt.Errorf("unknown query exec mode: %s", opts.QueryExecMode)
+		}
+		cfg.ConnConfig.DefaultQueryExecMode = mode
+	}
+	return cfg, nil
+}
+
+// InitPool создает пул подключений к БД и, как InitDB, применяет или проверяет миграции.
+// Частые запросы в режиме по умолчанию подготавливаются один раз на соединение.
+func InitPool(ctx context.Context, DatabaseDSN string, migrationsMode string, opts PoolOptions, sugar *logger.Logger) (*pgxpool.Pool, error) {
+	cfg, err := poolConfig(DatabaseDSN, opts)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Миграции выполняются через database/sql поверх того же пула; закрытие обертки пул не закрывает.
+	DB := stdlib.OpenDBFromPool(pool)
+	defer DB.Close()
+	if err := prepareSchema(DB, migrationsMode, sugar); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
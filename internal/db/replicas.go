@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// replicaLagQuery возвращает отставание реплики в секундах. Если реплика применила все полученные
+// изменения, отставание считается нулевым, даже когда на основной БД давно не было записей.
+const replicaLagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END::float8`
+
+// ErrReplicaNotChecked реплика еще ни разу не проверялась.
+var ErrReplicaNotChecked = errors.New("replica has not been checked yet")
+
+// Replica пул подключений к реплике БД и результат ее последней проверки.
+// Пока реплика не проверена, чтение с нее не выполняется.
+type Replica struct {
+	Host   string        // Адрес реплики для логов и проверок готовности.
+	pool   *pgxpool.Pool // Пул подключений к реплике.
+	maxLag time.Duration // Отставание, после которого реплика считается непригодной.
+
+	mu  sync.RWMutex
+	lag time.Duration // Последнее измеренное отставание.
+	err error         // Причина непригодности; nil - реплика исправна.
+}
+
+// Check измеряет отставание реплики и запоминает, можно ли с нее читать.
+func (r *Replica) Check(ctx context.Context) error {
+	var seconds float64
+	err := r.pool.QueryRow(ctx, replicaLagQuery).Scan(&seconds)
+	lag := time.Duration(seconds * float64(time.Second))
+	if err == nil && lag > r.maxLag {
+		err = fmt.Errorf("replica lag %s exceeds %s", lag.Round(time.Millisecond), r.maxLag)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lag, r.err = lag, err
+	return err
+}
+
+// Status возвращает последнее измеренное отставание и причину непригодности реплики.
+func (r *Replica) Status() (time.Duration, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lag, r.err
+}
+
+// Replicas набор реплик, между которыми по кругу распределяется чтение.
+type Replicas struct {
+	replicas []*Replica
+	next     atomic.Uint64 // Счетчик для выбора следующей реплики.
+}
+
+// InitReplicas создает пулы подключений к репликам. Подключения открываются при первом запросе,
+// поэтому недоступная при запуске реплика не мешает запуску сервиса.
+func InitReplicas(ctx context.Context, DSNs []string, maxLag time.Duration, opts PoolOptions) (*Replicas, error) {
+	replicas := &Replicas{}
+	for _, DSN := range DSNs {
+		cfg, err := poolConfig(DSN, opts)
+		if err != nil {
+			replicas.Close()
+			return nil, err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, cfg)
+		if err != nil {
+			replicas.Close()
+			return nil, err
+		}
+		replicas.replicas = append(replicas.replicas, &Replica{
+			Host:   fmt.Sprintf("%s:%d", cfg.ConnConfig.Host, cfg.ConnConfig.Port),
+			pool:   pool,
+			maxLag: maxLag,
+			err:    ErrReplicaNotChecked,
+		})
+	}
+	return replicas, nil
+}
+
+// Pool возвращает пул следующей исправной реплики или nil, если исправных реплик нет.
+func (r *Replicas) Pool() *pgxpool.Pool {
+	n := uint64(len(r.replicas))
+	start := r.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		replica := r.replicas[(start+i)%n]
+		if _, err := replica.Status(); err == nil {
+			return replica.pool
+		}
+	}
+	return nil
+}
+
+// All возвращает все реплики набора.
+func (r *Replicas) All() []*Replica {
+	return r.replicas
+}
+
+// Check проверяет все реплики и возвращает их ошибки.
+func (r *Replicas) Check(ctx context.Context) error {
+	var errs []error
+	for _, replica := range r.replicas {
+		if err := replica.Check(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", replica.Host, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close закрывает пулы подключений ко всем репликам.
+func (r *Replicas) Close() {
+	for _, replica := range r.replicas {
+		replica.pool.Close()
+	}
+}
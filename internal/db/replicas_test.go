@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicasPool(t *testing.T) {
+	// Пулы открывают подключения только при первом запросе, поэтому БД для теста не нужна.
+	replicas, err := InitReplicas(context.Background(), []string{
+		"postgres://user@replica1:5432/shortener",
+		"postgres://user@replica2:5433/shortener",
+	}, time.Second, PoolOptions{})
+	require.NoError(t, err)
+	defer replicas.Close()
+	first, second := replicas.All()[0], replicas.All()[1]
+	assert.Equal(t, "replica1:5432", first.Host)
+
+	assert.Nil(t, replicas.Pool(), "Непроверенные реплики не должны использоваться для чтения")
+
+	first.err = nil
+	for i := 0; i < 3; i++ {
+		assert.Same(t, first.pool, replicas.Pool())
+	}
+
+	second.err = nil
+	used := map[any]bool{replicas.Pool(): true, replicas.Pool(): true}
+	assert.Len(t, used, 2, "Чтение должно распределяться между исправными репликами")
+}
+
+func TestPoolConfig(t *testing.T) {
+	cfg, err := poolConfig("postgres://user@localhost:5432/shortener", PoolOptions{
+		MaxConns:         8,
+		MinConns:         2,
+		MaxConnLifetime:  time.Minute,
+		StatementTimeout: 1500 * time.Millisecond,
+		QueryExecMode:    QueryExecModeSimpleProtocol,
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 8, cfg.MaxConns)
+	assert.EqualValues(t, 2, cfg.MinConns)
+	assert.Equal(t, time.Minute, cfg.MaxConnLifetime)
+	assert.Equal(t, "1500", cfg.ConnConfig.RuntimeParams["statement_timeout"])
+	assert.Equal(t, pgx.QueryExecModeSimpleProtocol, cfg.ConnConfig.DefaultQueryExecMode)
+
+	_, err = poolConfig("postgres://user@localhost:5432/shortener", PoolOptions{QueryExecMode: "prepared"})
+	assert.Error(t, err)
+	_, err = poolConfig("postgres://user@localhost:5432/shortener", PoolOptions{MaxConns: 1, MinConns: 2})
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/db"
+)
+
+// DeletionQueue очередь запросов на удаление, заполненность которой проверяется.
+type DeletionQueue interface {
+	QueueLen() int // Текущее количество запросов в очереди.
+	QueueCap() int // Вместимость очереди.
+}
+
+// DatabaseCheck проверяет подключение к Postgres.
+func DatabaseCheck(db *sql.DB) Check {
+	return Check{
+		Name: "postgres",
+		Check: func(ctx context.Context) error {
+			return db.PingContext(ctx)
+		},
+	}
+}
+
+// MigrationsCheck проверяет, что к базе применены все встроенные миграции.
+func MigrationsCheck(DB *sql.DB) Check {
+	return Check{
+		Name: "migrations",
+		Check: func(ctx context.Context) error {
+			return db.CheckSchemaVersion(ctx, DB)
+		},
+	}
+}
+
+// ReplicaCheck проверяет отставание реплики БД и сообщает его в отчете. Чтение с непригодной
+// реплики переходит на основную БД, поэтому проверка не делает приложение неготовым.
+func ReplicaCheck(replica *db.Replica) Check {
+	return Check{
+		Name:  "postgres_replica:" + replica.Host,
+		Check: replica.Check,
+		Detail: func() string {
+			lag, _ := replica.Status()
+			return "lag " + lag.Round(time.Millisecond).String()
+		},
+		Optional: true,
+	}
+}
+
+// FileStorageCheck проверяет, что в каталог файла хранилища можно писать
+// и на диске осталось не меньше minFreeBytes свободного места.
+func FileStorageCheck(path string, minFreeBytes uint64) Check {
+	return Check{
+		Name: "file_storage",
+		Check: func(ctx context.Context) error {
+			dir := filepath.Dir(path)
+			probe, err := os.CreateTemp(dir, ".readyz-*")
+			if err != nil {
+				return fmt.Errorf("storage directory is not writable: %w", err)
+			}
+			probe.Close()
+			if err := os.Remove(probe.Name()); err != nil {
+				return err
+			}
+
+			free, ok, err := freeDiskSpace(dir)
+			if err != nil {
+				return err
+			}
+			if ok && free < minFreeBytes {
+				return fmt.Errorf("only %d bytes free on disk, need at least %d", free, minFreeBytes)
+			}
+			return nil
+		},
+	}
+}
+
+// DeletionQueueCheck проверяет, что очередь удаления заполнена не больше чем на maxRatio.
+func DeletionQueueCheck(queue DeletionQueue, maxRatio float64) Check {
+	return Check{
+		Name: "deletion_queue",
+		Check: func(ctx context.Context) error {
+			capacity := queue.QueueCap()
+			if capacity == 0 {
+				return nil
+			}
+			length := queue.QueueLen()
+			if float64(length)/float64(capacity) > maxRatio {
+				return fmt.Errorf("deletion queue is saturated: %d of %d", length, capacity)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package health
+
+// freeDiskSpace на платформах без statfs не проверяет свободное место.
+func freeDiskSpace(dir string) (uint64, bool, error) {
+	return 0, false, nil
+}
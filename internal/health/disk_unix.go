@@ -0,0 +1,14 @@
+//go:build unix
+
+package health
+
+import "syscall"
+
+// freeDiskSpace возвращает количество байт, доступных непривилегированному пользователю на диске с каталогом dir.
+func freeDiskSpace(dir string) (uint64, bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, false, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), true, nil
+}
@@ -0,0 +1,106 @@
+// Package health содержит проверки готовности приложения к обработке запросов.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Статусы проверок и итогового отчета.
+const (
+	StatusOK   = "ok"   // Проверка пройдена.
+	StatusFail = "fail" // Проверка не пройдена.
+)
+
+// defaultCheckTimeout время на выполнение одной проверки, если оно не задано.
+const defaultCheckTimeout = 5 * time.Second
+
+// Check именованная проверка одной зависимости приложения.
+type Check struct {
+	Name     string                          // Название проверки в отчете.
+	Check    func(ctx context.Context) error // Функция проверки; nil-ошибка означает успех.
+	Detail   func() string                   // Пояснение к результату, вызывается после проверки; может быть nil.
+	Optional bool                            // Неудача попадает в отчет, но не делает приложение неготовым.
+}
+
+// CheckResult результат выполнения одной проверки.
+type CheckResult struct {
+	Name      string  `json:"name"`               // Название проверки.
+	Status    string  `json:"status"`             // Статус: ok или fail.
+	LatencyMS float64 `json:"latency_ms"`         // Время выполнения проверки в миллисекундах.
+	Error     string  `json:"error,omitempty"`    // Текст ошибки, если проверка не пройдена.
+	Detail    string  `json:"detail,omitempty"`   // Пояснение к результату проверки.
+	Optional  bool    `json:"optional,omitempty"` // Неудача проверки не влияет на итоговый статус.
+}
+
+// Report итоговый отчет о готовности приложения.
+type Report struct {
+	Status string        `json:"status"` // ok, если пройдены все проверки, иначе fail.
+	Checks []CheckResult `json:"checks"` // Результаты отдельных проверок.
+}
+
+// Checker выполняет набор проверок готовности.
+type Checker struct {
+	checks  []Check       // Зарегистрированные проверки.
+	timeout time.Duration // Время на выполнение одной проверки.
+}
+
+// Register добавляет проверку в набор.
+func (c *Checker) Register(check Check) {
+	c.checks = append(c.checks, check)
+}
+
+// Run параллельно выполняет все проверки и возвращает отчет
+// с результатами в порядке регистрации.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make([]CheckResult, len(c.checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			report.Checks[i] = c.runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK && !result.Optional {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// runCheck выполняет одну проверку с ограничением по времени и замеряет ее длительность.
+func (c *Checker) runCheck(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(ctx)
+	result := CheckResult{
+		Name:      check.Name,
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Optional:  check.Optional,
+	}
+	if check.Detail != nil {
+		result.Detail = check.Detail()
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// NewChecker создает Checker с указанными проверками.
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	return &Checker{checks: checks, timeout: timeout}
+}
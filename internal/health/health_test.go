@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testQueue struct {
+	length   int
+	capacity int
+}
+
+func (q testQueue) QueueLen() int { return q.length }
+func (q testQueue) QueueCap() int { return q.capacity }
+
+func TestCheckerRun(t *testing.T) {
+	checker := NewChecker(time.Second,
+		Check{Name: "ok", Check: func(ctx context.Context) error { return nil }},
+		Check{Name: "broken", Check: func(ctx context.Context) error { return errors.New("boom") }},
+	)
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, StatusFail, report.Status)
+	require.Len(t, report.Checks, 2)
+	assert.Equal(t, "ok", report.Checks[0].Name)
+	assert.Equal(t, StatusOK, report.Checks[0].Status)
+	assert.Equal(t, "broken", report.Checks[1].Name)
+	assert.Equal(t, StatusFail, report.Checks[1].Status)
+	assert.Equal(t, "boom", report.Checks[1].Error)
+}
+
+func TestCheckerRunOptional(t *testing.T) {
+	checker := NewChecker(time.Second, Check{
+		Name:     "replica",
+		Check:    func(ctx context.Context) error { return errors.New("lagging") },
+		Detail:   func() string { return "lag 1m0s" },
+		Optional: true,
+	})
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, StatusOK, report.Status, "Неудача необязательной проверки не должна делать приложение неготовым")
+	assert.Equal(t, StatusFail, report.Checks[0].Status)
+	assert.Equal(t, "lag 1m0s", report.Checks[0].Detail)
+	assert.True(t, report.Checks[0].Optional)
+}
+
+func TestCheckerRunTimeout(t *testing.T) {
+	checker := NewChecker(10*time.Millisecond, Check{Name: "slow", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[0].Error)
+}
+
+func TestDeletionQueueCheck(t *testing.T) {
+	testCases := []struct {
+		name    string
+		queue   testQueue
+		wantErr bool
+	}{
+		{name: "empty queue", queue: testQueue{length: 0, capacity: 10}},
+		{name: "below threshold", queue: testQueue{length: 9, capacity: 10}},
+		{name: "saturated", queue: testQueue{length: 10, capacity: 10}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := DeletionQueueCheck(tc.queue, 0.9).Check(context.Background())
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestFileStorageCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	err := FileStorageCheck(filepath.Join(dir, "storage.json"), 0).Check(context.Background())
+	assert.NoError(t, err)
+
+	err = FileStorageCheck(filepath.Join(dir, "missing", "storage.json"), 0).Check(context.Background())
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ package jwt
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -30,7 +31,8 @@ type Claims struct {
 
 // Cache предоставляет механизм кэширования данных о токенах.
 type Cache struct {
-	sync.Map // Использование встроенной карты с синхронизацией для безопасного доступа из разных горутин
+	sync.Map              // Использование встроенной карты с синхронизацией для безопасного доступа из разных горутин
+	size     atomic.Int64 // Количество токенов в кэше
 }
 
 // NewCache создает новый экземпляр кэша.
@@ -49,7 +51,19 @@ func (c *Cache) Get(tokenString string) (*cachedData, bool) {
 
 // Set сохраняет данные в кэш по строке токена.
 func (c *Cache) Set(tokenString string, data *cachedData) {
-	c.Store(tokenString, data)
+	if _, loaded := c.Swap(tokenString, data); !loaded {
+		c.size.Add(1)
+	}
+}
+
+// Len возвращает количество токенов в кэше.
+func (c *Cache) Len() int {
+	return int(c.size.Load())
+}
+
+// CacheSize возвращает количество токенов в кэше приложения.
+func CacheSize() int {
+	return cachedMap.Len()
 }
 
 // cachedData содержит закэшированные данные о токене.
@@ -2,9 +2,17 @@
 package logger
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // LoggerInterface определяет интерфейс для логгера, поддерживающего различные уровни логирования.
@@ -24,14 +32,41 @@ type LoggerInterface interface {
 	Panicf(template string, args ...interface{})
 	Fatalf(template string, args ...interface{})
 	Infoln(args ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
 }
 
 // Logger представляет собой структуру логгера, использующего zap.SugaredLogger для логирования.
 type Logger struct {
 	zapLogger *zap.SugaredLogger // zapLogger - это обертка для упрощенного логирования.
+	level     zap.AtomicLevel    // Уровень логирования, который можно менять во время работы.
+	sampling  Sampling           // Настройки сэмплирования для высоконагруженных логов.
 }
 
-// GetLogger создает и возвращает новый экземпляр Logger.
+// Options настройки логгера.
+type Options struct {
+	Level    string   // Уровень логирования: debug, info, warn, error.
+	Encoding string   // Формат вывода: json или console.
+	Outputs  []string // Куда писать логи: stderr, stdout или пути к файлам.
+	Rotation Rotation // Настройки ротации файлов.
+	Sampling Sampling // Настройки сэмплирования лога запросов.
+}
+
+// Rotation настройки ротации файлов логов по размеру и возрасту.
+type Rotation struct {
+	MaxSizeMB  int // Максимальный размер файла в мегабайтах до ротации.
+	MaxAgeDays int // Сколько дней хранить старые файлы.
+	MaxBackups int // Сколько старых файлов хранить.
+}
+
+// Sampling настройки сэмплирования: в течение секунды пишутся первые Initial
+// одинаковых сообщений, а затем каждое Thereafter-е. Нулевой Initial отключает сэмплирование.
+type Sampling struct {
+	Initial    int
+	Thereafter int
+}
+
+// GetLogger создает и возвращает новый экземпляр Logger с настройками для разработки.
+// Используется до чтения конфигурации и в тестах.
 func GetLogger() *Logger {
 	logger, err := zap.NewDevelopment()
 	if err != nil {
@@ -39,7 +74,128 @@ func GetLogger() *Logger {
 	}
 	return &Logger{
 		zapLogger: logger.Sugar(), // Использование SugaredLogger для более удобного синтаксиса.
+		level:     zap.NewAtomicLevelAt(zap.DebugLevel),
+	}
+}
+
+// NewLogger создает Logger по переданным настройкам.
+func NewLogger(opts Options) (*Logger, error) {
+	level := zap.NewAtomicLevel()
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return nil, err
+		}
+	}
+
+	encoder, err := newEncoder(opts.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := opts.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{"stderr"}
 	}
+	var syncers []zapcore.WriteSyncer
+	for _, output := range outputs {
+		syncers = append(syncers, newWriteSyncer(output, opts.Rotation))
+	}
+
+	core := zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(syncers...), level)
+	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
+	return &Logger{
+		zapLogger: logger.Sugar(),
+		level:     level,
+		sampling:  opts.Sampling,
+	}, nil
+}
+
+// newEncoder создает энкодер для указанного формата вывода.
+func newEncoder(encoding string) (zapcore.Encoder, error) {
+	switch encoding {
+	case "", "console":
+		return zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), nil
+	case "json":
+		encoderConfig := zap.NewProductionEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		return zapcore.NewJSONEncoder(encoderConfig), nil
+	default:
+		return nil, fmt.Errorf("unknown log encoding: %s", encoding)
+	}
+}
+
+// newWriteSyncer создает приемник логов. Файлы ротируются по размеру и возрасту.
+func newWriteSyncer(output string, rotation Rotation) zapcore.WriteSyncer {
+	switch output {
+	case "stderr":
+		return zapcore.Lock(os.Stderr)
+	case "stdout":
+		return zapcore.Lock(os.Stdout)
+	default:
+		return zapcore.AddSync(&lumberjack.Logger{
+			Filename:   output,
+			MaxSize:    rotation.MaxSizeMB,
+			MaxAge:     rotation.MaxAgeDays,
+			MaxBackups: rotation.MaxBackups,
+		})
+	}
+}
+
+// Sampled возвращает логгер с сэмплированием для высоконагруженных сообщений,
+// например лога запросов. Если сэмплирование не настроено, возвращается исходный логгер.
+func (l *Logger) Sampled() *Logger {
+	if l.sampling.Initial <= 0 {
+		return l
+	}
+	sampled := l.zapLogger.Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSamplerWithOptions(core, time.Second, l.sampling.Initial, l.sampling.Thereafter)
+	}))
+	return &Logger{zapLogger: sampled.Sugar(), level: l.level, sampling: l.sampling}
+}
+
+// LevelHandler возвращает http-обработчик для просмотра (GET) и изменения (PUT)
+// уровня логирования во время работы, например: {"level":"debug"}.
+func (l *Logger) LevelHandler() http.Handler {
+	return l.level
+}
+
+// Sync сбрасывает буферизованные записи логгера.
+func (l *Logger) Sync() error {
+	return l.zapLogger.Sync()
+}
+
+type contextKey string
+
+const requestIDContextKey contextKey = "requestID"
+
+// ContextWithRequestID возвращает контекст с идентификатором запроса.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestIDFromContext получает идентификатор запроса из контекста.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDContextKey).(string)
+	return requestID, ok
+}
+
+// With возвращает логгер, дополненный идентификатором запроса,
+// а также идентификаторами трассы и спана из контекста.
+func (l *Logger) With(ctx context.Context) *Logger {
+	var fields []interface{}
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		fields = append(fields, "request_id", requestID)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		fields = append(fields,
+			"trace_id", spanContext.TraceID().String(),
+			"span_id", spanContext.SpanID().String(),
+		)
+	}
+	if len(fields) == 0 {
+		return l
+	}
+	return &Logger{zapLogger: l.zapLogger.With(fields...), level: l.level, sampling: l.sampling}
 }
 
 // Debug логирует сообщение с уровнем Debug.
@@ -116,3 +272,9 @@ func (l *Logger) Fatalf(template string, args ...interface{}) {
 func (l *Logger) Infoln(args ...interface{}) {
 	l.zapLogger.Info(args...)
 }
+
+// Infow логирует сообщение с уровнем Info и структурированными полями.
+// Сообщение не меняется от записи к записи, поэтому такие записи сэмплируются.
+func (l *Logger) Infow(msg string, keysAndValues ...interface{}) {
+	l.zapLogger.Infow(msg, keysAndValues...)
+}
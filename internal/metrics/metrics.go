@@ -0,0 +1,195 @@
+// Package metrics содержит метрики приложения в формате Prometheus.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/jwt"
+)
+
+const namespace = "shortener"
+
+// Registry реестр, в котором зарегистрированы все метрики приложения.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequestsTotal количество обработанных HTTP-запросов.
+	HTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Количество обработанных HTTP-запросов.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPRequestDuration время обработки HTTP-запросов.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Время обработки HTTP-запросов в секундах.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// RedirectsTotal количество переходов по коротким ссылкам (hit, miss, gone, error).
+	RedirectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Количество переходов по коротким ссылкам.",
+	}, []string{"result"})
+
+	// DeletionQueueDepth текущее количество запросов в очереди на удаление.
+	DeletionQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deletion_queue_depth",
+		Help:      "Количество запросов в очереди на удаление.",
+	})
+
+	// DeletionFailuresTotal количество неудачных попыток удаления пачки URL.
+	DeletionFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deletion_failures_total",
+		Help:      "Количество неудачных попыток удаления пачки URL.",
+	})
+
+	// RestoreFailuresTotal количество неудачных попыток восстановления пачки URL.
+	RestoreFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "restore_failures_total",
+		Help:      "Количество неудачных попыток восстановления пачки URL.",
+	})
+
+	// PurgedURLsTotal количество окончательно стертых удаленных URL.
+	PurgedURLsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "purged_urls_total",
+		Help:      "Количество окончательно стертых удаленных URL.",
+	})
+
+	// SnapshotsTotal количество сохранений снимка хранилища в памяти (success, failure).
+	SnapshotsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshots_total",
+		Help:      "Количество сохранений снимка хранилища в памяти.",
+	}, []string{"result"})
+
+	// SnapshotLastSuccessTimestamp время последнего успешного сохранения снимка в секундах Unix.
+	SnapshotLastSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "snapshot_last_success_timestamp_seconds",
+		Help:      "Время последнего успешного сохранения снимка хранилища в памяти в секундах Unix.",
+	})
+
+	// URLCacheRequestsTotal количество поисков URL по короткому адресу через кэш (hit, miss).
+	URLCacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "url_cache_requests_total",
+		Help:      "Количество поисков URL по короткому адресу через кэш.",
+	}, []string{"result"})
+
+	// ShortURLFilterSize количество коротких адресов в фильтре Блума после последнего перестроения.
+	ShortURLFilterSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "short_url_filter_size",
+		Help:      "Количество коротких адресов в фильтре Блума после последнего перестроения.",
+	})
+
+	// URLEventsReceivedTotal количество полученных событий об изменении URL от других экземпляров сервиса.
+	URLEventsReceivedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "url_events_received_total",
+		Help:      "Количество полученных событий об изменении URL.",
+	}, []string{"op"})
+
+	// URLEventsReconnectsTotal количество переподключений к каналу событий об изменении URL.
+	URLEventsReconnectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "url_events_reconnects_total",
+		Help:      "Количество переподключений к каналу событий об изменении URL.",
+	})
+
+	// DBReplicaLagSeconds отставание реплик БД по последней проверке.
+	DBReplicaLagSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_replica_lag_seconds",
+		Help:      "Отставание реплики БД в секундах по последней проверке.",
+	}, []string{"replica"})
+
+	// DBReplicaHealthy исправность реплик БД: 1 - с реплики читаются URL, 0 - чтение идет с основной БД.
+	DBReplicaHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_replica_healthy",
+		Help:      "Используется ли реплика БД для чтения (1) или нет (0).",
+	}, []string{"replica"})
+
+	// DBBreakerState состояние автоматического выключателя БД: 0 - замкнут, 1 - разомкнут, 2 - пробный запрос.
+	DBBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_breaker_state",
+		Help:      "Состояние автоматического выключателя БД (0 - замкнут, 1 - разомкнут, 2 - пробный запрос).",
+	})
+
+	// DBBreakerRejectionsTotal количество запросов к БД, отклоненных разомкнутым выключателем.
+	DBBreakerRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_breaker_rejections_total",
+		Help:      "Количество запросов к БД, отклоненных разомкнутым автоматическим выключателем.",
+	}, []string{"operation"})
+
+	// DBRetriesTotal количество повторов запросов к БД после временных ошибок.
+	DBRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_retries_total",
+		Help:      "Количество повторов запросов к БД после временных ошибок.",
+	}, []string{"operation"})
+
+	// RepositoryOperationDuration время выполнения операций с хранилищем.
+	RepositoryOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_operation_duration_seconds",
+		Help:      "Время выполнения операций с хранилищем в секундах.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+
+	// JWTCacheSize количество токенов в кэше JWT.
+	JWTCacheSize = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "jwt_cache_size",
+		Help:      "Количество токенов в кэше JWT.",
+	}, func() float64 {
+		return float64(jwt.CacheSize())
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		RedirectsTotal,
+		DeletionQueueDepth,
+		DeletionFailuresTotal,
+		RestoreFailuresTotal,
+		PurgedURLsTotal,
+		SnapshotsTotal,
+		SnapshotLastSuccessTimestamp,
+		URLCacheRequestsTotal,
+		ShortURLFilterSize,
+		URLEventsReceivedTotal,
+		URLEventsReconnectsTotal,
+		DBReplicaLagSeconds,
+		DBReplicaHealthy,
+		DBBreakerState,
+		DBBreakerRejectionsTotal,
+		DBRetriesTotal,
+		RepositoryOperationDuration,
+		JWTCacheSize,
+	)
+}
+
+// Handler возвращает http-обработчик, отдающий метрики в текстовом формате Prometheus.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbPoolCollector снимает статистику пула подключений к БД в момент сбора метрик.
+type dbPoolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns           *prometheus.Desc
+	idleConns               *prometheus.Desc
+	constructingConns       *prometheus.Desc
+	totalConns              *prometheus.Desc
+	maxConns                *prometheus.Desc
+	acquiresTotal           *prometheus.Desc
+	acquireDuration         *prometheus.Desc
+	emptyAcquiresTotal      *prometheus.Desc
+	canceledAcquiresTotal   *prometheus.Desc
+	newConnsTotal           *prometheus.Desc
+	maxLifetimeDestroyTotal *prometheus.Desc
+	maxIdleDestroyTotal     *prometheus.Desc
+}
+
+func dbPoolDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+}
+
+// NewDBPoolCollector создает сборщик метрик пула подключений к БД.
+func NewDBPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	return &dbPoolCollector{
+		pool:                    pool,
+		acquiredConns:           dbPoolDesc("acquired_conns", "Количество соединений, занятых запросами."),
+		idleConns:               dbPoolDesc("idle_conns", "Количество простаивающих соединений."),
+		constructingConns:       dbPoolDesc("constructing_conns", "Количество открываемых соединений."),
+		totalConns:              dbPoolDesc("total_conns", "Общее количество соединений в пуле."),
+		maxConns:                dbPoolDesc("max_conns", "Максимальное количество соединений в пуле."),
+		acquiresTotal:           dbPoolDesc("acquires_total", "Количество успешных получений соединения из пула."),
+		acquireDuration:         dbPoolDesc("acquire_duration_seconds_total", "Суммарное время получения соединений из пула в секундах."),
+		emptyAcquiresTotal:      dbPoolDesc("empty_acquires_total", "Количество получений соединения, ждавших освобождения или открытия соединения."),
+		canceledAcquiresTotal:   dbPoolDesc("canceled_acquires_total", "Количество получений соединения, отмененных контекстом."),
+		newConnsTotal:           dbPoolDesc("new_conns_total", "Количество открытых соединений."),
+		maxLifetimeDestroyTotal: dbPoolDesc("max_lifetime_destroy_total", "Количество соединений, закрытых по истечении времени жизни."),
+		maxIdleDestroyTotal:     dbPoolDesc("max_idle_destroy_total", "Количество соединений, закрытых после простоя."),
+	}
+}
+
+// Describe передает описания всех метрик пула.
+func (c *dbPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+// Collect передает текущие значения статистики пула.
+func (c *dbPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.constructingConns, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquiresTotal, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquiresTotal, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquiresTotal, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.newConnsTotal, prometheus.CounterValue, float64(stat.NewConnsCount()))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeDestroyTotal, prometheus.CounterValue, float64(stat.MaxLifetimeDestroyCount()))
+	ch <- prometheus.MustNewConstMetric(c.maxIdleDestroyTotal, prometheus.CounterValue, float64(stat.MaxIdleDestroyCount()))
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+)
+
+// instrumentedURLRepository замеряет время выполнения операций репозитория URL.
+type instrumentedURLRepository struct {
+	repo    service.URLRepository // Исходный репозиторий.
+	backend string                // Название хранилища для метки метрики.
+}
+
+// instrumentedUserRepository замеряет время выполнения операций репозитория пользователей.
+type instrumentedUserRepository struct {
+	repo    service.UserRepository // Исходный репозиторий.
+	backend string                 // Название хранилища для метки метрики.
+}
+
+// observe записывает время выполнения операции в гистограмму.
+func observe(backend, operation string, start time.Time) {
+	RepositoryOperationDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+}
+
+// Save сохраняет URL.
+func (r instrumentedURLRepository) Save(ctx context.Context, url models.URLToSave) (uuid.UUID, error) {
+	defer observe(r.backend, "save", time.Now())
+	return r.repo.Save(ctx, url)
+}
+
+// BatchSave сохраняет список URL.
+func (r instrumentedURLRepository) BatchSave(ctx context.Context, urls []models.URLToSave) ([]uuid.UUID, error) {
+	defer observe(r.backend, "batch_save", time.Now())
+	return r.repo.BatchSave(ctx, urls)
+}
+
+// BatchDelete удаляет список URL.
+func (r instrumentedURLRepository) BatchDelete(ctx context.Context, urls []string, userID uuid.UUID) error {
+	defer observe(r.backend, "batch_delete", time.Now())
+	return r.repo.BatchDelete(ctx, urls, userID)
+}
+
+// BatchDeleteByUsers удаляет URL нескольких пользователей разом.
+func (r instrumentedURLRepository) BatchDeleteByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	defer observe(r.backend, "batch_delete_by_users", time.Now())
+	return r.repo.BatchDeleteByUsers(ctx, urlsByUser)
+}
+
+// BatchRestoreByUsers восстанавливает удаленные URL нескольких пользователей разом.
+func (r instrumentedURLRepository) BatchRestoreByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	defer observe(r.backend, "batch_restore_by_users", time.Now())
+	return r.repo.BatchRestoreByUsers(ctx, urlsByUser)
+}
+
+// FindByUserIDWithOptions выбирает страницу URL пользователя.
+func (r instrumentedURLRepository) FindByUserIDWithOptions(ctx context.Context, userID uuid.UUID, opts models.URLQueryOptions) ([]models.URLRow, string, error) {
+	defer observe(r.backend, "find_by_user_id_with_options", time.Now())
+	return r.repo.FindByUserIDWithOptions(ctx, userID, opts)
+}
+
+// UpdateOriginalURL меняет исходный URL пользователя.
+func (r instrumentedURLRepository) UpdateOriginalURL(ctx context.Context, shortURL string, userID uuid.UUID, originalURL string) (models.URLRow, error) {
+	defer observe(r.backend, "update_original_url", time.Now())
+	return r.repo.UpdateOriginalURL(ctx, shortURL, userID, originalURL)
+}
+
+// PurgeDeleted окончательно стирает URL, удаленные раньше deletedBefore.
+func (r instrumentedURLRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (int, error) {
+	defer observe(r.backend, "purge_deleted", time.Now())
+	return r.repo.PurgeDeleted(ctx, deletedBefore, batchSize)
+}
+
+// ForEachShortURL перебирает короткие адреса всех URL, включая удаленные.
+func (r instrumentedURLRepository) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	defer observe(r.backend, "for_each_short_url", time.Now())
+	return r.repo.ForEachShortURL(ctx, fn)
+}
+
+// Find выполняет поиск URL по короткому адресу.
+func (r instrumentedURLRepository) Find(ctx context.Context, shortURL string) (models.URLRow, bool, error) {
+	defer observe(r.backend, "find", time.Now())
+	return r.repo.Find(ctx, shortURL)
+}
+
+// FindByUserID ищет все URL, принадлежащие пользователю.
+func (r instrumentedURLRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.URLRow, error) {
+	defer observe(r.backend, "find_by_user_id", time.Now())
+	return r.repo.FindByUserID(ctx, userID)
+}
+
+// FindByOriginalURL ищет URL по оригинальному адресу.
+func (r instrumentedURLRepository) FindByOriginalURL(ctx context.Context, originalURL string, userID uuid.UUID) (string, bool) {
+	defer observe(r.backend, "find_by_original_url", time.Now())
+	return r.repo.FindByOriginalURL(ctx, originalURL, userID)
+}
+
+// UpdateUser привязывает URL к пользователю.
+func (r instrumentedUserRepository) UpdateUser(ctx context.Context, savedURLUUID uuid.UUID, userID uuid.UUID) error {
+	defer observe(r.backend, "update_user", time.Now())
+	return r.repo.UpdateUser(ctx, savedURLUUID, userID)
+}
+
+// UpdateBatchUser привязывает список URL к пользователю.
+func (r instrumentedUserRepository) UpdateBatchUser(ctx context.Context, savedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
+	defer observe(r.backend, "update_batch_user", time.Now())
+	return r.repo.UpdateBatchUser(ctx, savedURLUUIDs, userID)
+}
+
+// InstrumentURLRepository оборачивает репозиторий URL сбором метрик времени выполнения операций.
+func InstrumentURLRepository(repo service.URLRepository, backend string) service.URLRepository {
+	return instrumentedURLRepository{repo: repo, backend: backend}
+}
+
+// InstrumentUserRepository оборачивает репозиторий пользователей сбором метрик времени выполнения операций.
+func InstrumentUserRepository(repo service.UserRepository, backend string) service.UserRepository {
+	return instrumentedUserRepository{repo: repo, backend: backend}
+}
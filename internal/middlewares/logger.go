@@ -2,9 +2,13 @@ package middlewares
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
 )
 
 type (
@@ -34,7 +38,28 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // Захватываем статус ответа.
 }
 
-// RequestLoggerMiddleware логирует информацию о каждом запросе и ответе.
+// routePattern возвращает шаблон маршрута chi, чтобы метрики не разрастались по каждому короткому URL.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return "unmatched"
+}
+
+// observeRequest записывает метрики количества и времени обработки запроса.
+func observeRequest(r *http.Request, status int, duration time.Duration) {
+	if status == 0 {
+		status = http.StatusOK // Статус не выставлялся явно, net/http отправит 200.
+	}
+	labels := []string{routePattern(r), r.Method, strconv.Itoa(status)}
+	metrics.HTTPRequestsTotal.WithLabelValues(labels...).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(duration.Seconds())
+}
+
+// RequestLoggerMiddleware логирует информацию о каждом запросе и ответе
+// и собирает метрики количества и времени обработки запросов.
 func RequestLoggerMiddleware(s *logger.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		logFn := func(w http.ResponseWriter, r *http.Request) {
@@ -51,9 +76,11 @@ func RequestLoggerMiddleware(s *logger.Logger) func(next http.Handler) http.Hand
 			next.ServeHTTP(&lw, r) // Внедряем нашу реализацию http.ResponseWriter.
 
 			duration := time.Since(start)
+			observeRequest(r, responseData.status, duration)
 
-			// Логирование информации о запросе и ответе.
-			s.Infoln(
+			// Логирование информации о запросе и ответе. Сообщение постоянное, а данные запроса
+			// передаются полями: сэмплирование отбирает записи по сообщению.
+			s.With(r.Context()).Infow("request",
 				"uri", r.RequestURI,
 				"method", r.Method,
 				"status", responseData.status, // Получаем перехваченный код статуса ответа.
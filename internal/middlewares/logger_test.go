@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+)
+
+func TestRequestLoggerMiddlewareSampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.log")
+	sugar, err := logger.NewLogger(logger.Options{
+		Level:    "info",
+		Encoding: "json",
+		Outputs:  []string{path},
+		Sampling: logger.Sampling{Initial: 2, Thereafter: 5},
+	})
+	require.NoError(t, err)
+
+	handler := RequestLoggerMiddleware(sugar.Sampled())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	// У каждого запроса свой адрес, но сэмплирование все равно должно срабатывать.
+	for i := 0; i < 12; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abcdefg"+strconv.Itoa(i), nil))
+	}
+	require.NoError(t, sugar.Sync())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+
+	// Первые 2 записи, затем 7-я и 12-я.
+	require.Len(t, entries, 4)
+	assert.Equal(t, "request", entries[0]["msg"])
+	assert.Equal(t, "/abcdefg0", entries[0]["uri"])
+	assert.EqualValues(t, http.StatusTemporaryRedirect, entries[0]["status"])
+	assert.Equal(t, "/abcdefg6", entries[2]["uri"])
+}
@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	testCases := []struct {
+		name       string
+		requestID  string
+		expectSame bool
+	}{
+		{name: "id from client", requestID: "client-request-1", expectSame: true},
+		{name: "generated id", requestID: "", expectSame: false},
+		{name: "invalid id is replaced", requestID: "bad id\n", expectSame: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ctxRequestID string
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxRequestID, _ = logger.RequestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.requestID != "" {
+				req.Header.Set(RequestIDHeader, tc.requestID)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			respRequestID := rec.Header().Get(RequestIDHeader)
+			assert.NotEmpty(t, respRequestID)
+			assert.Equal(t, respRequestID, ctxRequestID)
+			if tc.expectSame {
+				assert.Equal(t, tc.requestID, respRequestID)
+			} else {
+				assert.NotEqual(t, tc.requestID, respRequestID)
+			}
+		})
+	}
+}
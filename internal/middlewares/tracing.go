@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
+)
+
+// TracingMiddleware создает корневой спан для каждого запроса.
+// Если клиент передал заголовок traceparent, спан продолжает его трассу.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		responseData := &responseData{}
+		lw := loggingResponseWriter{ResponseWriter: w, responseData: responseData}
+		next.ServeHTTP(&lw, r.WithContext(ctx))
+
+		status := responseData.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := routePattern(r)
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -11,6 +12,25 @@ type ShortenURLRequest struct {
 	URL string `json:"url"` // URL для сокращения.
 }
 
+// UpdateURLRequest структура для запроса на изменение сокращенного URL.
+type UpdateURLRequest struct {
+	URL string `json:"url"` // Новый исходный URL.
+}
+
+// UpdateURLResponse структура для ответа на изменение сокращенного URL.
+type UpdateURLResponse struct {
+	ShortURL    string             `json:"short_url"`    // Сокращенный URL.
+	OriginalURL string             `json:"original_url"` // Текущий исходный URL.
+	UpdatedAt   time.Time          `json:"updated_at"`   // Время последнего изменения URL.
+	History     []URLHistoryRecord `json:"history"`      // Предыдущие исходные URL, от старых к новым.
+}
+
+// URLHistoryRecord запись истории изменения исходного URL.
+type URLHistoryRecord struct {
+	OriginalURL string    `json:"original_url" db:"original_url"` // Исходный URL до изменения.
+	ReplacedAt  time.Time `json:"replaced_at" db:"replaced_at"`   // Время, когда URL был заменен.
+}
+
 // ShortenBatchURLRequestElement элемент пакетного запроса на сокращение URL.
 type ShortenBatchURLRequestElement struct {
 	CorrelationID string `json:"correlation_id"` // Идентификатор для корреляции в ответе.
@@ -30,8 +50,9 @@ type ShortenURLResponse struct {
 
 // URLToSave структура для сохранения URL в хранилище.
 type URLToSave struct {
-	RandomPath string // Случайный путь, используемый в качестве сокращенного URL.
-	URLStr     string // Исходный URL.
+	RandomPath string    // Случайный путь, используемый в качестве сокращенного URL.
+	URLStr     string    // Исходный URL.
+	UserID     uuid.UUID // Идентификатор пользователя, сокращающего URL.
 }
 
 // User структура пользователя.
@@ -54,17 +75,95 @@ type CorrelationSavedURL struct {
 
 // URLByUserResponseElement элемент ответа на запрос URL, принадлежащих пользователю.
 type URLByUserResponseElement struct {
-	ShortURL    string `json:"short_url"`    // Сокращенный URL.
-	OriginalURL string `json:"original_url"` // Исходный URL.
+	ShortURL    string     `json:"short_url"`            // Сокращенный URL.
+	OriginalURL string     `json:"original_url"`         // Исходный URL.
+	CreatedAt   time.Time  `json:"created_at"`           // Время создания URL.
+	UpdatedAt   time.Time  `json:"updated_at"`           // Время последнего изменения URL.
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // Время удаления URL, если он удален.
+}
+
+// ExportedURL URL пользователя в выгрузке; в том же формате URL принимаются при загрузке.
+type ExportedURL struct {
+	ShortURL    string     `json:"short_url"`            // Сокращенный URL.
+	OriginalURL string     `json:"original_url"`         // Исходный URL.
+	Deleted     bool       `json:"is_deleted"`           // Флаг, указывающий на удаление URL.
+	CreatedAt   time.Time  `json:"created_at"`           // Время создания URL.
+	UpdatedAt   time.Time  `json:"updated_at"`           // Время последнего изменения URL.
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // Время удаления URL, если он удален.
+}
+
+// Статусы строк загрузки URL.
+const (
+	ImportStatusImported = "imported" // URL сохранен под прежним коротким адресом.
+	ImportStatusRenamed  = "renamed"  // Прежний короткий адрес занят или некорректен, URL сохранен под новым.
+	ImportStatusConflict = "conflict" // URL уже сокращен, в ответе указан существующий короткий адрес.
+	ImportStatusSkipped  = "skipped"  // Удаленный URL не загружается.
+	ImportStatusInvalid  = "invalid"  // Строка не содержит исходного URL.
+)
+
+// ImportURLResult результат загрузки одной строки.
+type ImportURLResult struct {
+	Row         int    `json:"row"`                 // Номер строки в загруженных данных, начиная с 1.
+	ShortURL    string `json:"short_url,omitempty"` // Сокращенный URL, под которым сохранен или уже сокращен URL.
+	OriginalURL string `json:"original_url"`        // Исходный URL.
+	Status      string `json:"status"`              // Статус загрузки строки.
+	Error       string `json:"error,omitempty"`     // Причина, по которой строка не загружена.
+}
+
+// Поля сортировки списка URL пользователя.
+const (
+	SortByCreatedAt   = "created_at"   // Сортировка по времени создания.
+	SortByOriginalURL = "original_url" // Сортировка по исходному URL.
+)
+
+// URLQueryOptions параметры постраничной выборки URL пользователя.
+type URLQueryOptions struct {
+	Limit         int        // Максимальное количество URL на странице; 0 - без ограничения.
+	Cursor        string     // Курсор, полученный вместе с предыдущей страницей.
+	SortBy        string     // Поле сортировки; по умолчанию время создания.
+	Descending    bool       // Сортировка по убыванию.
+	Search        string     // Подстрока, которую должен содержать исходный URL.
+	Deleted       *bool      // Только удаленные (true) или только неудаленные (false) URL; nil - все.
+	CreatedAfter  *time.Time // URL, созданные не раньше указанного времени.
+	CreatedBefore *time.Time // URL, созданные раньше указанного времени.
 }
 
 // URLRow структура строки URL в БД
 type URLRow struct {
-	UUID        uuid.UUID `json:"uuid" db:"uuid"`                 // Уникальный идентификатор URL.
-	ShortURL    string    `json:"short_url" db:"short_url"`       // Сокращенный URL.
-	OriginalURL string    `json:"original_url" db:"original_url"` // Исходный URL.
-	DeletedFlag bool      `db:"is_deleted"`                       // Флаг, указывающий на удаление URL.
-	UserID      uuid.UUID `json:"user_id" db:"user_id"`           // Идентификатор пользователя, владельца URL.
+	UUID        uuid.UUID          `json:"uuid" db:"uuid"`                       // Уникальный идентификатор URL.
+	ShortURL    string             `json:"short_url" db:"short_url"`             // Сокращенный URL.
+	OriginalURL string             `json:"original_url" db:"original_url"`       // Исходный URL.
+	DeletedFlag bool               `db:"is_deleted"`                             // Флаг, указывающий на удаление URL.
+	UserID      uuid.UUID          `json:"user_id" db:"user_id"`                 // Идентификатор пользователя, владельца URL.
+	CreatedAt   time.Time          `json:"created_at" db:"created_at"`           // Время создания URL.
+	UpdatedAt   time.Time          `json:"updated_at" db:"updated_at"`           // Время последнего изменения URL.
+	DeletedAt   *time.Time         `json:"deleted_at,omitempty" db:"deleted_at"` // Время удаления URL.
+	History     []URLHistoryRecord `json:"history,omitempty" db:"-"`             // Предыдущие исходные URL.
+}
+
+// MarkDeleted помечает URL как удаленный в указанное время. Уже удаленный URL не меняется,
+// чтобы повторное удаление не откладывало его окончательное стирание.
+func (r *URLRow) MarkDeleted(at time.Time) {
+	if r.DeletedFlag {
+		return
+	}
+	r.DeletedFlag = true
+	r.DeletedAt = &at
+	r.UpdatedAt = at
+}
+
+// ChangeOriginalURL меняет исходный URL в указанное время и сохраняет прежний в истории.
+func (r *URLRow) ChangeOriginalURL(originalURL string, at time.Time) {
+	r.History = append(r.History, URLHistoryRecord{OriginalURL: r.OriginalURL, ReplacedAt: at})
+	r.OriginalURL = originalURL
+	r.UpdatedAt = at
+}
+
+// Restore снимает с URL пометку об удалении в указанное время.
+func (r *URLRow) Restore(at time.Time) {
+	r.DeletedFlag = false
+	r.DeletedAt = nil
+	r.UpdatedAt = at
 }
 
 // SharedURLRows структура для хранения и синхронизации списка URL.
@@ -79,3 +178,16 @@ func NewSharedURLRows() *SharedURLRows {
 		URLRows: make([]URLRow, 0),
 	}
 }
+
+// Виды событий об изменении URL, которыми обмениваются экземпляры сервиса.
+const (
+	URLEventSaved   = "saved"   // Сохранены новые URL.
+	URLEventChanged = "changed" // URL удалены, восстановлены, изменены или стерты.
+	URLEventFlush   = "flush"   // Изменения неизвестны; нужно сбросить все локальные кэши.
+)
+
+// URLEvent событие об изменении URL в общем хранилище.
+type URLEvent struct {
+	Op        string   `json:"op"`                   // Вид события.
+	ShortURLs []string `json:"short_urls,omitempty"` // Короткие адреса затронутых URL.
+}
@@ -0,0 +1,576 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
+)
+
+// Бакеты встроенной базы. Строки URL хранятся в json по UUID, остальные бакеты - индексы,
+// которые обновляются в той же транзакции, что и строка.
+var (
+	boltURLsBucket         = []byte("urls")          // UUID -> строка URL.
+	boltShortURLsBucket    = []byte("short_urls")    // Короткий адрес -> UUID.
+	boltOriginalURLsBucket = []byte("original_urls") // Оригинальный URL, 0x00, UUID -> пусто.
+	boltUserURLsBucket     = []byte("user_urls")     // UUID пользователя, время создания, UUID -> пусто.
+)
+
+// boltOpenTimeout время ожидания блокировки файла базы, занятой другим процессом.
+const boltOpenTimeout = time.Second
+
+// BoltURLRepository представляет репозиторий URL во встроенной базе bbolt.
+type BoltURLRepository struct {
+	db         *bolt.DB // Встроенная база.
+	DedupScope string   // Область дедупликации оригинальных URL.
+}
+
+// BoltUserRepository представляет репозиторий пользователей во встроенной базе bbolt.
+type BoltUserRepository struct {
+	db *bolt.DB // Встроенная база.
+}
+
+// OpenBoltDB открывает файл встроенной базы, создавая его и бакеты при первом запуске.
+// Файл открывается одним процессом: репозитории URL и пользователей должны делить одну базу.
+func OpenBoltDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open bolt storage %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltURLsBucket, boltShortURLsBucket, boltOriginalURLsBucket, boltUserURLsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Save сохраняет новый URL в базе.
+func (r *BoltURLRepository) Save(ctx context.Context, url models.URLToSave) (uuid.UUID, error) {
+	_, span := tracing.Start(ctx, "BoltURLRepository.Save")
+	defer span.End()
+
+	UUIDs, err := r.save([]models.URLToSave{url})
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return UUIDs[0], nil
+}
+
+// BatchSave сохраняет несколько URL в базе одной транзакцией.
+func (r *BoltURLRepository) BatchSave(ctx context.Context, urls []models.URLToSave) ([]uuid.UUID, error) {
+	_, span := tracing.Start(ctx, "BoltURLRepository.BatchSave")
+	defer span.End()
+
+	return r.save(urls)
+}
+
+// save сохраняет URL одной транзакцией. Конфликт хотя бы одного URL с существующей ссылкой
+// или с другим URL списка отменяет сохранение всего списка.
+func (r *BoltURLRepository) save(urls []models.URLToSave) ([]uuid.UUID, error) {
+	var UUIDs []uuid.UUID
+	now := time.Now().UTC()
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		for _, url := range urls {
+			if err := r.checkConflicts(tx, url); err != nil {
+				return err
+			}
+			newURLRow := models.URLRow{
+				UUID:        uuid.New(),
+				ShortURL:    url.RandomPath,
+				OriginalURL: url.URLStr,
+				UserID:      url.UserID,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if err := putBoltURLRow(tx, nil, newURLRow); err != nil {
+				return err
+			}
+			UUIDs = append(UUIDs, newURLRow.UUID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return UUIDs, nil
+}
+
+// checkConflicts проверяет, что короткий адрес URL свободен, а сам URL еще не сокращен
+// в области дедупликации. Проверяются только строки из индексов короткого и оригинального адреса.
+func (r *BoltURLRepository) checkConflicts(tx *bolt.Tx, url models.URLToSave) error {
+	if urlRow, found, err := findBoltURLRowByShortURL(tx, url.RandomPath); err != nil {
+		return err
+	} else if found {
+		if err := checkConflict(r.DedupScope, urlRow, url); err != nil {
+			return err
+		}
+	}
+	return forEachBoltURLRowByOriginalURL(tx, url.URLStr, func(urlRow models.URLRow) (bool, error) {
+		return false, checkConflict(r.DedupScope, urlRow, url)
+	})
+}
+
+// Find ищет URL по сокращенному адресу в базе.
+func (r *BoltURLRepository) Find(ctx context.Context, shortURL string) (models.URLRow, bool, error) {
+	_, span := tracing.Start(ctx, "BoltURLRepository.Find")
+	defer span.End()
+
+	var urlRow models.URLRow
+	var found bool
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		urlRow, found, err = findBoltURLRowByShortURL(tx, shortURL)
+		return err
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+		return models.URLRow{}, false, err
+	}
+	return urlRow, found, nil
+}
+
+// FindByOriginalURL ищет сокращенный URL по оригинальному адресу среди неудаленных URL в базе.
+// В области дедупликации user и none поиск ведется только среди URL пользователя.
+func (r *BoltURLRepository) FindByOriginalURL(ctx context.Context, originalURL string, userID uuid.UUID) (string, bool) {
+	_, span := tracing.Start(ctx, "BoltURLRepository.FindByOriginalURL")
+	defer span.End()
+
+	var shortURL string
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return forEachBoltURLRowByOriginalURL(tx, originalURL, func(urlRow models.URLRow) (bool, error) {
+			if dedupMatches(r.DedupScope, urlRow, originalURL, userID) {
+				shortURL = urlRow.ShortURL
+				return true, nil
+			}
+			return false, nil
+		})
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+		return "", false
+	}
+	return shortURL, shortURL != ""
+}
+
+// FindByUserID ищет все URL, принадлежащие пользователю, в базе в порядке создания.
+// Пустой список - успешный результат.
+func (r *BoltURLRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.URLRow, error) {
+	_, span := tracing.Start(ctx, "BoltURLRepository.FindByUserID")
+	defer span.End()
+
+	var matchedURLs []models.URLRow
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		matchedURLs, err = findBoltURLRowsByUserID(tx, userID)
+		return err
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	return matchedURLs, nil
+}
+
+// FindByUserIDWithOptions выбирает страницу URL пользователя в базе с сортировкой и фильтрами.
+func (r *BoltURLRepository) FindByUserIDWithOptions(ctx context.Context, userID uuid.UUID, opts models.URLQueryOptions) ([]models.URLRow, string, error) {
+	_, span := tracing.Start(ctx, "BoltURLRepository.FindByUserIDWithOptions")
+	defer span.End()
+
+	var userURLs []models.URLRow
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		userURLs, err = findBoltURLRowsByUserID(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return queryURLRows(userURLs, opts)
+}
+
+// BatchDelete помечает URL как удаленные для указанного пользователя в базе.
+func (r *BoltURLRepository) BatchDelete(ctx context.Context, urls []string, userID uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "BoltURLRepository.BatchDelete")
+	defer span.End()
+
+	return r.BatchDeleteByUsers(ctx, map[uuid.UUID][]string{userID: urls})
+}
+
+// BatchDeleteByUsers помечает URL как удаленные сразу для нескольких пользователей одной транзакцией.
+func (r *BoltURLRepository) BatchDeleteByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	_, span := tracing.Start(ctx, "BoltURLRepository.BatchDeleteByUsers")
+	defer span.End()
+
+	now := time.Now().UTC()
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return forEachBoltUserURLRow(tx, urlsByUser, func(urlRow models.URLRow) error {
+			if urlRow.DeletedFlag {
+				return nil
+			}
+			updated := urlRow
+			updated.MarkDeleted(now)
+			return putBoltURLRow(tx, &urlRow, updated)
+		})
+	})
+}
+
+// BatchRestoreByUsers снимает пометку об удалении с URL нескольких пользователей в базе.
+// URL не восстанавливается, если его оригинальный адрес уже сокращен заново в области дедупликации.
+func (r *BoltURLRepository) BatchRestoreByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	_, span := tracing.Start(ctx, "BoltURLRepository.BatchRestoreByUsers")
+	defer span.End()
+
+	now := time.Now().UTC()
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return forEachBoltUserURLRow(tx, urlsByUser, func(urlRow models.URLRow) error {
+			if !urlRow.DeletedFlag {
+				return nil
+			}
+			if r.DedupScope != DedupScopeNone {
+				hasDuplicate := false
+				err := forEachBoltURLRowByOriginalURL(tx, urlRow.OriginalURL, func(other models.URLRow) (bool, error) {
+					hasDuplicate = dedupMatches(r.DedupScope, other, urlRow.OriginalURL, urlRow.UserID)
+					return hasDuplicate, nil
+				})
+				if err != nil || hasDuplicate {
+					return err
+				}
+			}
+			updated := urlRow
+			updated.Restore(now)
+			return putBoltURLRow(tx, &urlRow, updated)
+		})
+	})
+}
+
+// UpdateOriginalURL меняет исходный URL пользователя в базе, сохраняя прежний в истории.
+func (r *BoltURLRepository) UpdateOriginalURL(ctx context.Context, shortURL string, userID uuid.UUID, originalURL string) (models.URLRow, error) {
+	_, span := tracing.Start(ctx, "BoltURLRepository.UpdateOriginalURL")
+	defer span.End()
+
+	var updated models.URLRow
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		urlRow, found, err := findBoltURLRowByShortURL(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return &apperrors.URLNotFound{ShortURL: shortURL}
+		}
+		// Для проверки правил хватает самой строки и строк с новым оригинальным адресом.
+		urlRows := []models.URLRow{urlRow}
+		err = forEachBoltURLRowByOriginalURL(tx, originalURL, func(other models.URLRow) (bool, error) {
+			urlRows = append(urlRows, other)
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+		updated, err = updateURLRows(r.DedupScope, urlRows, shortURL, userID, originalURL, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		return putBoltURLRow(tx, &urlRow, updated)
+	})
+	if err != nil {
+		return models.URLRow{}, err
+	}
+	return updated, nil
+}
+
+// PurgeDeleted окончательно стирает из базы URL, удаленные раньше deletedBefore.
+// Каждые batchSize строк стираются отдельной транзакцией, чтобы не задерживать запись надолго.
+func (r *BoltURLRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (int, error) {
+	ctx, span := tracing.Start(ctx, "BoltURLRepository.PurgeDeleted")
+	defer span.End()
+
+	batchSize = purgeBatchSize(batchSize)
+	total := 0
+	for {
+		purged := 0
+		err := r.db.Update(func(tx *bolt.Tx) error {
+			var toPurge []models.URLRow
+			err := tx.Bucket(boltURLsBucket).ForEach(func(_, data []byte) error {
+				if len(toPurge) == batchSize {
+					return nil
+				}
+				urlRow, err := decodeBoltURLRow(data)
+				if err != nil {
+					return err
+				}
+				if isPurgeable(urlRow, deletedBefore) {
+					toPurge = append(toPurge, urlRow)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, urlRow := range toPurge {
+				if err := deleteBoltURLRow(tx, urlRow); err != nil {
+					return err
+				}
+			}
+			purged = len(toPurge)
+			return nil
+		})
+		total += purged
+		if err != nil {
+			return total, err
+		}
+		if purged < batchSize {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// ForEachShortURL передает в fn короткие адреса всех URL в базе, включая удаленные.
+// Адреса читаются из индекса, без разбора строк URL.
+func (r *BoltURLRepository) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	_, span := tracing.Start(ctx, "BoltURLRepository.ForEachShortURL")
+	defer span.End()
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltShortURLsBucket).ForEach(func(shortURL, _ []byte) error {
+			fn(string(shortURL))
+			return nil
+		})
+	})
+	tracing.RecordError(span, err)
+	return err
+}
+
+// UpdateUser обновляет пользователя для указанного URL в базе.
+func (r *BoltUserRepository) UpdateUser(ctx context.Context, SavedURLUUID uuid.UUID, userID uuid.UUID) error {
+	_, span := tracing.Start(ctx, "BoltUserRepository.UpdateUser")
+	defer span.End()
+
+	updated, err := r.updateUsers([]uuid.UUID{SavedURLUUID}, userID)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errors.New("URL не найден")
+	}
+	return nil
+}
+
+// UpdateBatchUser обновляет пользователя для нескольких URL в базе одной транзакцией.
+func (r *BoltUserRepository) UpdateBatchUser(ctx context.Context, SavedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
+	_, span := tracing.Start(ctx, "BoltUserRepository.UpdateBatchUser")
+	defer span.End()
+
+	updated, err := r.updateUsers(SavedURLUUIDs, userID)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errors.New("URL для обновления не найдены")
+	}
+	return nil
+}
+
+// updateUsers привязывает URL к пользователю и возвращает количество найденных URL.
+func (r *BoltUserRepository) updateUsers(SavedURLUUIDs []uuid.UUID, userID uuid.UUID) (int, error) {
+	updated := 0
+	now := time.Now().UTC()
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		updated = 0
+		for _, id := range SavedURLUUIDs {
+			urlRow, found, err := getBoltURLRow(tx, id)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			newURLRow := urlRow
+			newURLRow.UserID = userID
+			newURLRow.UpdatedAt = now
+			if err := putBoltURLRow(tx, &urlRow, newURLRow); err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// getBoltURLRow читает строку URL по UUID.
+func getBoltURLRow(tx *bolt.Tx, id uuid.UUID) (models.URLRow, bool, error) {
+	data := tx.Bucket(boltURLsBucket).Get(id[:])
+	if data == nil {
+		return models.URLRow{}, false, nil
+	}
+	urlRow, err := decodeBoltURLRow(data)
+	return urlRow, err == nil, err
+}
+
+// findBoltURLRowByShortURL ищет строку URL по короткому адресу через индекс.
+func findBoltURLRowByShortURL(tx *bolt.Tx, shortURL string) (models.URLRow, bool, error) {
+	id := tx.Bucket(boltShortURLsBucket).Get([]byte(shortURL))
+	if id == nil {
+		return models.URLRow{}, false, nil
+	}
+	return getBoltURLRow(tx, uuid.UUID(id))
+}
+
+// forEachBoltURLRowByOriginalURL передает в fn строки с указанным оригинальным адресом,
+// пока fn не вернет true или ошибку.
+func forEachBoltURLRowByOriginalURL(tx *bolt.Tx, originalURL string, fn func(urlRow models.URLRow) (bool, error)) error {
+	prefix := append([]byte(originalURL), 0)
+	c := tx.Bucket(boltOriginalURLsBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		urlRow, found, err := getBoltURLRow(tx, uuid.UUID(k[len(prefix):]))
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if stop, err := fn(urlRow); err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+// findBoltURLRowsByUserID возвращает строки URL пользователя в порядке создания.
+func findBoltURLRowsByUserID(tx *bolt.Tx, userID uuid.UUID) ([]models.URLRow, error) {
+	var urlRows []models.URLRow
+	c := tx.Bucket(boltUserURLsBucket).Cursor()
+	for k, _ := c.Seek(userID[:]); k != nil && bytes.HasPrefix(k, userID[:]); k, _ = c.Next() {
+		urlRow, found, err := getBoltURLRow(tx, uuid.UUID(k[len(k)-len(uuid.UUID{}):]))
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			urlRows = append(urlRows, urlRow)
+		}
+	}
+	return urlRows, nil
+}
+
+// forEachBoltUserURLRow передает в fn строки с короткими адресами из urlsByUser, принадлежащие своим пользователям.
+// Чужие и несуществующие адреса пропускаются.
+func forEachBoltUserURLRow(tx *bolt.Tx, urlsByUser map[uuid.UUID][]string, fn func(urlRow models.URLRow) error) error {
+	for userID, urls := range urlsByUser {
+		for _, shortURL := range urls {
+			urlRow, found, err := findBoltURLRowByShortURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			if !found || urlRow.UserID != userID {
+				continue
+			}
+			if err := fn(urlRow); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// putBoltURLRow записывает строку URL и обновляет индексы. Если передана прежняя версия строки,
+// ее записи в индексах сначала удаляются.
+func putBoltURLRow(tx *bolt.Tx, old *models.URLRow, urlRow models.URLRow) error {
+	if old != nil {
+		if err := deleteBoltURLRowIndexes(tx, *old); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(urlRow)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltURLsBucket).Put(urlRow.UUID[:], data); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltShortURLsBucket).Put([]byte(urlRow.ShortURL), urlRow.UUID[:]); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltOriginalURLsBucket).Put(boltOriginalURLKey(urlRow), nil); err != nil {
+		return err
+	}
+	return tx.Bucket(boltUserURLsBucket).Put(boltUserURLKey(urlRow), nil)
+}
+
+// deleteBoltURLRow удаляет строку URL вместе с ее записями в индексах.
+func deleteBoltURLRow(tx *bolt.Tx, urlRow models.URLRow) error {
+	if err := deleteBoltURLRowIndexes(tx, urlRow); err != nil {
+		return err
+	}
+	return tx.Bucket(boltURLsBucket).Delete(urlRow.UUID[:])
+}
+
+// deleteBoltURLRowIndexes удаляет записи строки URL в индексах.
+func deleteBoltURLRowIndexes(tx *bolt.Tx, urlRow models.URLRow) error {
+	if err := tx.Bucket(boltShortURLsBucket).Delete([]byte(urlRow.ShortURL)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltOriginalURLsBucket).Delete(boltOriginalURLKey(urlRow)); err != nil {
+		return err
+	}
+	return tx.Bucket(boltUserURLsBucket).Delete(boltUserURLKey(urlRow))
+}
+
+// boltOriginalURLKey ключ строки в индексе оригинальных адресов.
+func boltOriginalURLKey(urlRow models.URLRow) []byte {
+	key := make([]byte, 0, len(urlRow.OriginalURL)+1+len(urlRow.UUID))
+	key = append(key, urlRow.OriginalURL...)
+	key = append(key, 0)
+	return append(key, urlRow.UUID[:]...)
+}
+
+// boltUserURLKey ключ строки в индексе владельцев: строки пользователя упорядочены по времени создания.
+func boltUserURLKey(urlRow models.URLRow) []byte {
+	key := make([]byte, 0, len(urlRow.UserID)+8+len(urlRow.UUID))
+	key = append(key, urlRow.UserID[:]...)
+	key = binary.BigEndian.AppendUint64(key, uint64(urlRow.CreatedAt.UnixNano()))
+	return append(key, urlRow.UUID[:]...)
+}
+
+// decodeBoltURLRow раскодирует строку URL из json.
+func decodeBoltURLRow(data []byte) (models.URLRow, error) {
+	var urlRow models.URLRow
+	if err := json.Unmarshal(data, &urlRow); err != nil {
+		return models.URLRow{}, fmt.Errorf("corrupted URL row in bolt storage: %w", err)
+	}
+	return urlRow, nil
+}
+
+// NewBoltURLRepository создает новый экземпляр репозитория URL во встроенной базе.
+func NewBoltURLRepository(db *bolt.DB, dedupScope string) (*BoltURLRepository, error) {
+	scope, err := parseDedupScope(dedupScope)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltURLRepository{db: db, DedupScope: scope}, nil
+}
+
+// NewBoltUserRepository создает новый экземпляр репозитория пользователей во встроенной базе.
+func NewBoltUserRepository(db *bolt.DB) (*BoltUserRepository, error) {
+	return &BoltUserRepository{db: db}, nil
+}
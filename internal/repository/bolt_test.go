@@ -0,0 +1,57 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+)
+
+// TestBoltRepository_SurvivesReopen проверяет, что URL и отметки об удалении сохраняются
+// после повторного открытия файла базы.
+func TestBoltRepository_SurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+	open := func() (*repository.BoltURLRepository, func()) {
+		boltDB, err := repository.OpenBoltDB(path)
+		require.NoError(t, err)
+		urls, err := repository.NewBoltURLRepository(boltDB, repository.DedupScopeGlobal)
+		require.NoError(t, err)
+		return urls, func() { boltDB.Close() }
+	}
+	owner := uuid.New()
+
+	urls, closeDB := open()
+	_, err := urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/kept", UserID: owner},
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/deleted", UserID: owner},
+	})
+	require.NoError(t, err)
+	require.NoError(t, urls.BatchDelete(ctx, []string{"bcdefghi"}, owner))
+	closeDB()
+
+	urls, closeDB = open()
+	defer closeDB()
+	urlRow, found, err := urls.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "http://practicum.yandex.ru/kept", urlRow.OriginalURL)
+	urlRow, found, err = urls.Find(ctx, "bcdefghi")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, urlRow.DeletedFlag)
+
+	purged, err := urls.PurgeDeleted(ctx, time.Now().Add(time.Hour), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	_, found, err = urls.Find(ctx, "bcdefghi")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
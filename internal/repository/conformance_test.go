@@ -0,0 +1,562 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/db"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+)
+
+// repositories репозитории одного хранилища, которые проверяет набор тестов.
+type repositories struct {
+	urls  service.URLRepository
+	users service.UserRepository
+}
+
+// backend создает пустое хранилище с указанной областью дедупликации.
+type backend func(t *testing.T, dedupScope string) repositories
+
+// backends возвращает все хранилища, которые должны вести себя одинаково.
+// Postgres проверяется, только если задан DATABASE_DSN; его таблицы очищаются перед каждым тестом.
+func backends(t *testing.T) map[string]backend {
+	backends := map[string]backend{
+		"memory": func(t *testing.T, dedupScope string) repositories {
+			sharedURLRows := models.NewSharedURLRows()
+			urls, err := repository.NewMemoryURLRepository(sharedURLRows, dedupScope)
+			require.NoError(t, err)
+			users, err := repository.NewMemoryUserRepository(sharedURLRows)
+			require.NoError(t, err)
+			return repositories{urls: urls, users: users}
+		},
+		"file": func(t *testing.T, dedupScope string) repositories {
+			cfg := config.Config{FileStoragePath: filepath.Join(t.TempDir(), "storage.json"), DedupScope: dedupScope}
+			urls, err := repository.NewFileURLRepository(cfg, logger.GetLogger())
+			require.NoError(t, err)
+			users, err := repository.NewFileUserRepository(cfg, logger.GetLogger())
+			require.NoError(t, err)
+			return repositories{urls: urls, users: users}
+		},
+		"bolt": func(t *testing.T, dedupScope string) repositories {
+			boltDB, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "storage.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { boltDB.Close() })
+			urls, err := repository.NewBoltURLRepository(boltDB, dedupScope)
+			require.NoError(t, err)
+			users, err := repository.NewBoltUserRepository(boltDB)
+			require.NoError(t, err)
+			return repositories{urls: urls, users: users}
+		},
+	}
+
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		return backends
+	}
+	pool, err := db.InitPool(context.Background(), dsn, db.MigrationsModeAuto, db.PoolOptions{}, logger.GetLogger())
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	backends["postgres"] = func(t *testing.T, dedupScope string) repositories {
+		truncate(t, pool)
+		urls, err := repository.NewDBURLRepository(pool, dedupScope)
+		require.NoError(t, err)
+		require.NoError(t, urls.PrepareDedupIndex(context.Background(), true))
+		users, err := repository.NewDBUserRepository(pool)
+		require.NoError(t, err)
+		return repositories{urls: urls, users: users}
+	}
+	return backends
+}
+
+// truncate очищает таблицы тестовой базы.
+func truncate(t *testing.T, pool *pgxpool.Pool) {
+	t.Helper()
+	_, err := pool.Exec(context.Background(), "TRUNCATE url_rows, users CASCADE")
+	require.NoError(t, err)
+}
+
+// conformanceCases поведение, одинаковое для всех хранилищ.
+var conformanceCases = []struct {
+	name string
+	run  func(t *testing.T, newRepositories backend)
+}{
+	{name: "save and find", run: testSaveAndFind},
+	{name: "short url conflict", run: testShortURLConflict},
+	{name: "dedup scopes", run: testDedupScopes},
+	{name: "batch save is atomic", run: testBatchSaveIsAtomic},
+	{name: "find by user id", run: testFindByUserID},
+	{name: "batch delete checks owner", run: testBatchDeleteChecksOwner},
+	{name: "repeated delete keeps deleted at", run: testRepeatedDeleteKeepsDeletedAt},
+	{name: "delete and restore by users", run: testDeleteAndRestoreByUsers},
+	{name: "update user", run: testUpdateUser},
+	{name: "update original url", run: testUpdateOriginalURL},
+	{name: "find with options", run: testFindWithOptions},
+	{name: "purge deleted", run: testPurgeDeleted},
+	{name: "purge deleted without batch size", run: testPurgeDeletedWithoutBatchSize},
+	{name: "for each short url", run: testForEachShortURL},
+}
+
+// TestConformance проверяет, что все хранилища ведут себя одинаково.
+func TestConformance(t *testing.T) {
+	for name, newRepositories := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range conformanceCases {
+				t.Run(tc.name, func(t *testing.T) {
+					tc.run(t, newRepositories)
+				})
+			}
+		})
+	}
+}
+
+func testSaveAndFind(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	id, err := repos.urls.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/", UserID: userID})
+	require.NoError(t, err)
+
+	urlRow, found, err := repos.urls.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, id, urlRow.UUID)
+	assert.Equal(t, "http://practicum.yandex.ru/", urlRow.OriginalURL)
+	assert.Equal(t, userID, urlRow.UserID)
+	assert.False(t, urlRow.DeletedFlag)
+	assert.Nil(t, urlRow.DeletedAt)
+	assert.False(t, urlRow.CreatedAt.IsZero())
+
+	_, found, err = repos.urls.Find(ctx, "missing0")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func testShortURLConflict(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeNone)
+	ctx := context.Background()
+
+	_, err := repos.urls.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/", UserID: uuid.New()})
+	require.NoError(t, err)
+	_, err = repos.urls.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://ya.ru/", UserID: uuid.New()})
+	var shortErr *apperrors.ShortURLAlreadyExists
+	assert.ErrorAs(t, err, &shortErr)
+}
+
+func testDedupScopes(t *testing.T, newRepositories backend) {
+	testCases := []struct {
+		scope             string
+		sameUserConflict  bool
+		otherUserConflict bool
+	}{
+		{scope: repository.DedupScopeGlobal, sameUserConflict: true, otherUserConflict: true},
+		{scope: repository.DedupScopeUser, sameUserConflict: true, otherUserConflict: false},
+		{scope: repository.DedupScopeNone, sameUserConflict: false, otherUserConflict: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.scope, func(t *testing.T) {
+			repos := newRepositories(t, tc.scope)
+			ctx := context.Background()
+			owner, other := uuid.New(), uuid.New()
+			const originalURL = "http://practicum.yandex.ru/"
+
+			_, err := repos.urls.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: originalURL, UserID: owner})
+			require.NoError(t, err)
+
+			shortURL, found := repos.urls.FindByOriginalURL(ctx, originalURL, owner)
+			assert.True(t, found)
+			assert.Equal(t, "abcdefgh", shortURL)
+			_, found = repos.urls.FindByOriginalURL(ctx, originalURL, other)
+			assert.Equal(t, tc.scope == repository.DedupScopeGlobal, found)
+
+			var conflictErr *apperrors.OriginalURLAlreadyExists
+			_, err = repos.urls.Save(ctx, models.URLToSave{RandomPath: "bcdefghi", URLStr: originalURL, UserID: owner})
+			assert.Equal(t, tc.sameUserConflict, errors.As(err, &conflictErr))
+			_, err = repos.urls.Save(ctx, models.URLToSave{RandomPath: "cdefghij", URLStr: originalURL, UserID: other})
+			assert.Equal(t, tc.otherUserConflict, errors.As(err, &conflictErr))
+
+			// Анонимные URL дедуплицируются между собой так же, как URL одного пользователя.
+			const anonymousURL = "http://practicum.yandex.ru/anonymous"
+			_, err = repos.urls.Save(ctx, models.URLToSave{RandomPath: "defghijk", URLStr: anonymousURL})
+			require.NoError(t, err)
+			_, err = repos.urls.Save(ctx, models.URLToSave{RandomPath: "efghijkl", URLStr: anonymousURL})
+			assert.Equal(t, tc.sameUserConflict, errors.As(err, &conflictErr))
+		})
+	}
+}
+
+func testBatchSaveIsAtomic(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	_, err := repos.urls.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/taken", UserID: userID})
+	require.NoError(t, err)
+
+	var conflictErr *apperrors.OriginalURLAlreadyExists
+	_, err = repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/new", UserID: userID},
+		{RandomPath: "cdefghij", URLStr: "http://practicum.yandex.ru/taken", UserID: userID},
+	})
+	assert.ErrorAs(t, err, &conflictErr)
+	_, found, err := repos.urls.Find(ctx, "bcdefghi")
+	require.NoError(t, err)
+	assert.False(t, found, "URL from a failed batch must not be saved")
+
+	// Одинаковые URL внутри одного списка тоже конфликтуют.
+	_, err = repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "defghijk", URLStr: "http://practicum.yandex.ru/twice", UserID: userID},
+		{RandomPath: "efghijkl", URLStr: "http://practicum.yandex.ru/twice", UserID: userID},
+	})
+	assert.ErrorAs(t, err, &conflictErr)
+	_, found, err = repos.urls.Find(ctx, "defghijk")
+	require.NoError(t, err)
+	assert.False(t, found, "URL from a failed batch must not be saved")
+
+	ids, err := repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "fghijklm", URLStr: "http://practicum.yandex.ru/1", UserID: userID},
+		{RandomPath: "ghijklmn", URLStr: "http://practicum.yandex.ru/2", UserID: userID},
+	})
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+	urlRow, found, err := repos.urls.Find(ctx, "ghijklmn")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, ids[1], urlRow.UUID)
+}
+
+func testFindByUserID(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	owner, other := uuid.New(), uuid.New()
+
+	// Пустой список - успешный результат, в том числе в еще пустом хранилище.
+	urlRows, err := repos.urls.FindByUserID(ctx, owner)
+	require.NoError(t, err)
+	assert.Empty(t, urlRows)
+
+	_, err = repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/1", UserID: owner},
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/2", UserID: owner},
+		{RandomPath: "cdefghij", URLStr: "http://practicum.yandex.ru/3", UserID: other},
+	})
+	require.NoError(t, err)
+
+	urlRows, err = repos.urls.FindByUserID(ctx, owner)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"abcdefgh", "bcdefghi"}, shortURLs(urlRows))
+	for _, urlRow := range urlRows {
+		assert.Equal(t, owner, urlRow.UserID)
+	}
+
+	urlRows, err = repos.urls.FindByUserID(ctx, uuid.New())
+	require.NoError(t, err)
+	assert.Empty(t, urlRows)
+}
+
+func testBatchDeleteChecksOwner(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	owner, other := uuid.New(), uuid.New()
+
+	_, err := repos.urls.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/", UserID: owner})
+	require.NoError(t, err)
+
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"abcdefgh", "missing0"}, other))
+	urlRow, _, err := repos.urls.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.False(t, urlRow.DeletedFlag, "URL must not be deleted by another user")
+
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"abcdefgh"}, owner))
+	urlRow, found, err := repos.urls.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.True(t, urlRow.DeletedFlag)
+	assert.NotNil(t, urlRow.DeletedAt)
+
+	// Удаленный URL не участвует в дедупликации.
+	_, found = repos.urls.FindByOriginalURL(ctx, "http://practicum.yandex.ru/", owner)
+	assert.False(t, found)
+	_, err = repos.urls.Save(ctx, models.URLToSave{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/", UserID: owner})
+	assert.NoError(t, err)
+}
+
+func testRepeatedDeleteKeepsDeletedAt(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	owner := uuid.New()
+
+	_, err := repos.urls.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/", UserID: owner})
+	require.NoError(t, err)
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"abcdefgh"}, owner))
+	first, _, err := repos.urls.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	require.NotNil(t, first.DeletedAt)
+
+	// Повторное удаление не должно откладывать окончательное стирание URL.
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"abcdefgh"}, owner))
+	require.NoError(t, repos.urls.BatchDeleteByUsers(ctx, map[uuid.UUID][]string{owner: {"abcdefgh"}}))
+	second, _, err := repos.urls.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	require.NotNil(t, second.DeletedAt)
+	assert.True(t, first.DeletedAt.Equal(*second.DeletedAt))
+	assert.True(t, first.UpdatedAt.Equal(second.UpdatedAt))
+}
+
+func testDeleteAndRestoreByUsers(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	first, second := uuid.New(), uuid.New()
+
+	_, err := repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/1", UserID: first},
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/2", UserID: second},
+		{RandomPath: "cdefghij", URLStr: "http://practicum.yandex.ru/3", UserID: second},
+	})
+	require.NoError(t, err)
+
+	// Чужой URL в списке пользователя пропускается.
+	require.NoError(t, repos.urls.BatchDeleteByUsers(ctx, map[uuid.UUID][]string{
+		first:  {"abcdefgh", "bcdefghi"},
+		second: {"cdefghij"},
+	}))
+	assert.Equal(t, map[string]bool{"abcdefgh": true, "bcdefghi": false, "cdefghij": true}, deletedFlags(t, repos, "abcdefgh", "bcdefghi", "cdefghij"))
+
+	// URL, адрес которого уже сокращен заново, не восстанавливается.
+	_, err = repos.urls.Save(ctx, models.URLToSave{RandomPath: "defghijk", URLStr: "http://practicum.yandex.ru/3", UserID: first})
+	require.NoError(t, err)
+	require.NoError(t, repos.urls.BatchRestoreByUsers(ctx, map[uuid.UUID][]string{
+		first:  {"abcdefgh"},
+		second: {"cdefghij"},
+	}))
+	assert.Equal(t, map[string]bool{"abcdefgh": false, "cdefghij": true}, deletedFlags(t, repos, "abcdefgh", "cdefghij"))
+	urlRow, _, err := repos.urls.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.Nil(t, urlRow.DeletedAt)
+}
+
+func testUpdateUser(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	owner := uuid.New()
+
+	id, err := repos.urls.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/"})
+	require.NoError(t, err)
+	require.NoError(t, repos.users.UpdateUser(ctx, id, owner))
+
+	urlRow, found, err := repos.urls.Find(ctx, "abcdefgh")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, id, urlRow.UUID, "UpdateUser must not change the URL UUID")
+	assert.Equal(t, owner, urlRow.UserID)
+	urlRows, _ := repos.urls.FindByUserID(ctx, owner)
+	assert.Equal(t, []string{"abcdefgh"}, shortURLs(urlRows))
+
+	assert.Error(t, repos.users.UpdateUser(ctx, uuid.New(), owner))
+
+	ids, err := repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/1"},
+		{RandomPath: "cdefghij", URLStr: "http://practicum.yandex.ru/2"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, repos.users.UpdateBatchUser(ctx, ids, owner))
+	urlRows, _ = repos.urls.FindByUserID(ctx, owner)
+	assert.ElementsMatch(t, []string{"abcdefgh", "bcdefghi", "cdefghij"}, shortURLs(urlRows))
+
+	assert.Error(t, repos.users.UpdateBatchUser(ctx, []uuid.UUID{uuid.New()}, owner))
+}
+
+func testUpdateOriginalURL(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	owner, other := uuid.New(), uuid.New()
+
+	_, err := repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/typo", UserID: owner},
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/taken", UserID: other},
+		{RandomPath: "cdefghij", URLStr: "http://practicum.yandex.ru/deleted", UserID: owner},
+	})
+	require.NoError(t, err)
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"cdefghij"}, owner))
+
+	urlRow, err := repos.urls.UpdateOriginalURL(ctx, "abcdefgh", owner, "http://practicum.yandex.ru/fixed")
+	require.NoError(t, err)
+	assert.Equal(t, "http://practicum.yandex.ru/fixed", urlRow.OriginalURL)
+	require.Len(t, urlRow.History, 1)
+	assert.Equal(t, "http://practicum.yandex.ru/typo", urlRow.History[0].OriginalURL)
+
+	// Прежний адрес освобождается, новый занят.
+	_, found := repos.urls.FindByOriginalURL(ctx, "http://practicum.yandex.ru/typo", owner)
+	assert.False(t, found)
+	shortURL, found := repos.urls.FindByOriginalURL(ctx, "http://practicum.yandex.ru/fixed", owner)
+	assert.True(t, found)
+	assert.Equal(t, "abcdefgh", shortURL)
+
+	// Тот же адрес ничего не меняет.
+	urlRow, err = repos.urls.UpdateOriginalURL(ctx, "abcdefgh", owner, "http://practicum.yandex.ru/fixed")
+	require.NoError(t, err)
+	assert.Len(t, urlRow.History, 1)
+
+	var notFoundErr *apperrors.URLNotFound
+	_, err = repos.urls.UpdateOriginalURL(ctx, "missing0", owner, "http://practicum.yandex.ru/other")
+	assert.ErrorAs(t, err, &notFoundErr)
+	var accessErr *apperrors.URLAccessDenied
+	_, err = repos.urls.UpdateOriginalURL(ctx, "abcdefgh", other, "http://practicum.yandex.ru/other")
+	assert.ErrorAs(t, err, &accessErr)
+	var deletedErr *apperrors.URLIsDeleted
+	_, err = repos.urls.UpdateOriginalURL(ctx, "cdefghij", owner, "http://practicum.yandex.ru/other")
+	assert.ErrorAs(t, err, &deletedErr)
+	var conflictErr *apperrors.OriginalURLAlreadyExists
+	_, err = repos.urls.UpdateOriginalURL(ctx, "abcdefgh", owner, "http://practicum.yandex.ru/taken")
+	assert.ErrorAs(t, err, &conflictErr)
+}
+
+func testFindWithOptions(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	owner := uuid.New()
+
+	_, err := repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/c", UserID: owner},
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/a", UserID: owner},
+		{RandomPath: "cdefghij", URLStr: "http://yandex.ru/e", UserID: owner},
+		{RandomPath: "defghijk", URLStr: "http://practicum.yandex.ru/b", UserID: owner},
+		{RandomPath: "efghijkl", URLStr: "http://practicum.yandex.ru/d", UserID: owner},
+		{RandomPath: "fghijklm", URLStr: "http://practicum.yandex.ru/other", UserID: uuid.New()},
+	})
+	require.NoError(t, err)
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"efghijkl"}, owner))
+
+	live := false
+	opts := models.URLQueryOptions{Limit: 2, SortBy: models.SortByOriginalURL, Search: "practicum", Deleted: &live}
+	var originalURLs []string
+	for page := 0; page < 3; page++ {
+		urlRows, nextCursor, err := repos.urls.FindByUserIDWithOptions(ctx, owner, opts)
+		require.NoError(t, err)
+		for _, urlRow := range urlRows {
+			originalURLs = append(originalURLs, urlRow.OriginalURL)
+		}
+		if nextCursor == "" {
+			break
+		}
+		opts.Cursor = nextCursor
+	}
+	assert.Equal(t, []string{
+		"http://practicum.yandex.ru/a",
+		"http://practicum.yandex.ru/b",
+		"http://practicum.yandex.ru/c",
+	}, originalURLs)
+
+	var optsErr *apperrors.InvalidQueryOptions
+	_, _, err = repos.urls.FindByUserIDWithOptions(ctx, owner, models.URLQueryOptions{SortBy: "short_url"})
+	assert.ErrorAs(t, err, &optsErr)
+}
+
+func testPurgeDeleted(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	owner := uuid.New()
+
+	_, err := repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/1", UserID: owner},
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/2", UserID: owner},
+		{RandomPath: "cdefghij", URLStr: "http://practicum.yandex.ru/3", UserID: owner},
+	})
+	require.NoError(t, err)
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"abcdefgh", "bcdefghi"}, owner))
+
+	purged, err := repos.urls.PurgeDeleted(ctx, time.Now().Add(-time.Hour), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged, "recently deleted URLs must be kept")
+
+	purged, err = repos.urls.PurgeDeleted(ctx, time.Now().Add(time.Hour), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	urlRows, _ := repos.urls.FindByUserID(ctx, owner)
+	assert.Equal(t, []string{"cdefghij"}, shortURLs(urlRows))
+}
+
+func testPurgeDeletedWithoutBatchSize(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	owner := uuid.New()
+
+	_, err := repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/1", UserID: owner},
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/2", UserID: owner},
+	})
+	require.NoError(t, err)
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"abcdefgh", "bcdefghi"}, owner))
+
+	// Неположительный размер пачки означает стирание всех URL одной пачкой.
+	for _, batchSize := range []int{0, -1} {
+		purged, err := repos.urls.PurgeDeleted(ctx, time.Now().Add(time.Hour), batchSize)
+		require.NoError(t, err)
+		if batchSize == 0 {
+			assert.Equal(t, 2, purged)
+		} else {
+			assert.Equal(t, 0, purged)
+		}
+	}
+}
+
+func testForEachShortURL(t *testing.T, newRepositories backend) {
+	repos := newRepositories(t, repository.DedupScopeGlobal)
+	ctx := context.Background()
+	owner := uuid.New()
+
+	var visited []string
+	require.NoError(t, repos.urls.ForEachShortURL(ctx, func(shortURL string) { visited = append(visited, shortURL) }))
+	assert.Empty(t, visited)
+
+	_, err := repos.urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/1", UserID: owner},
+		{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/2", UserID: owner},
+	})
+	require.NoError(t, err)
+	require.NoError(t, repos.urls.BatchDelete(ctx, []string{"abcdefgh"}, owner))
+
+	require.NoError(t, repos.urls.ForEachShortURL(ctx, func(shortURL string) { visited = append(visited, shortURL) }))
+	sort.Strings(visited)
+	assert.Equal(t, []string{"abcdefgh", "bcdefghi"}, visited, "deleted URLs must be visited too")
+}
+
+// shortURLs возвращает короткие адреса строк.
+func shortURLs(urlRows []models.URLRow) []string {
+	var result []string
+	for _, urlRow := range urlRows {
+		result = append(result, urlRow.ShortURL)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// deletedFlags возвращает пометки об удалении URL по коротким адресам.
+func deletedFlags(t *testing.T, repos repositories, shortURLs ...string) map[string]bool {
+	t.Helper()
+	flags := make(map[string]bool, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		urlRow, found, err := repos.urls.Find(context.Background(), shortURL)
+		require.NoError(t, err)
+		require.True(t, found, shortURL)
+		flags[shortURL] = urlRow.DeletedFlag
+	}
+	return flags
+}
@@ -1,88 +1,326 @@
 package repository
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
 )
 
 // DBURLRepository представляет репозиторий для работы с URL в базе данных.
+// Сохранения и изменения URL публикуются событиями в канал URLEventsChannel,
+// чтобы другие экземпляры сервиса сбрасывали свои кэши.
 type DBURLRepository struct {
-	db *sql.DB // db представляет подключение к базе данных.
+	db         *pgxpool.Pool // db представляет пул подключений к основной базе данных.
+	reads      *ReadRouter   // Выбор пула для чтения: реплика или основная БД.
+	dedupScope string        // Область дедупликации оригинальных URL.
 }
 
 // DBUserRepository представляет репозиторий для работы с пользователями в базе данных.
 type DBUserRepository struct {
-	db *sql.DB // db представляет подключение к базе данных.
+	db    *pgxpool.Pool // db представляет пул подключений к основной базе данных.
+	reads *ReadRouter   // Отметки о записях для чтения с основной БД сразу после них.
 }
 
-// Find ищет URL по сокращенному адресу.
-func (r DBURLRepository) Find(shortURL string) (models.URLRow, bool) {
+// Find ищет URL по сокращенному адресу. Если на реплике URL не найден, он мог еще не дойти до нее,
+// поэтому поиск повторяется на основной БД; так же поиск повторяется, если реплика не ответила.
+func (r DBURLRepository) Find(ctx context.Context, shortURL string) (_ models.URLRow, _ bool, err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.Find")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	pool := r.reads.forShortURL(shortURL)
+	urlRow, ok, err := find(ctx, pool, shortURL)
+	if (!ok || err != nil) && pool != r.db {
+		urlRow, ok, err = find(ctx, r.db, shortURL)
+	}
+	return urlRow, ok, err
+}
+
+// find ищет URL по сокращенному адресу через q.
+func find(ctx context.Context, q querier, shortURL string) (models.URLRow, bool, error) {
 	var urlRow models.URLRow
-	row := r.db.QueryRow("SELECT uuid, short_url, original_url, is_deleted FROM url_rows WHERE short_url = $1", shortURL)
-	err := row.Scan(&urlRow.UUID, &urlRow.ShortURL, &urlRow.OriginalURL, &urlRow.DeletedFlag)
+	var userID uuid.NullUUID
+	row := q.QueryRow(ctx, "SELECT uuid, short_url, original_url, user_id, is_deleted, created_at, updated_at, deleted_at FROM url_rows WHERE short_url = $1", shortURL)
+	err := row.Scan(&urlRow.UUID, &urlRow.ShortURL, &urlRow.OriginalURL, &userID, &urlRow.DeletedFlag, &urlRow.CreatedAt, &urlRow.UpdatedAt, &urlRow.DeletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.URLRow{}, false, nil
+	}
 	if err != nil {
-		return models.URLRow{}, false
+		return models.URLRow{}, false, err
 	}
-	return urlRow, true
+	urlRow.UserID = userID.UUID
+	return urlRow, true, nil
 }
 
-// FindByOriginalURL ищет сокращенный URL по оригинальному адресу.
-func (r DBURLRepository) FindByOriginalURL(originalURL string) (string, bool) {
-	var urlRow models.URLRow
-	row := r.db.QueryRow("SELECT uuid, short_url, original_url FROM url_rows WHERE original_url = $1", originalURL)
-	err := row.Scan(&urlRow.UUID, &urlRow.ShortURL, &urlRow.OriginalURL)
+// FindByOriginalURL ищет сокращенный URL по оригинальному адресу среди неудаленных URL.
+// В области дедупликации user и none поиск ведется только среди URL пользователя.
+// Как и Find, при промахе или ошибке реплики поиск повторяется на основной БД: конфликтующий URL
+// другого пользователя мог еще не дойти до реплики.
+func (r DBURLRepository) FindByOriginalURL(ctx context.Context, originalURL string, userID uuid.UUID) (string, bool) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.FindByOriginalURL")
+	defer span.End()
+
+	pool := r.reads.forUser(userID)
+	shortURL, ok, err := r.findByOriginalURL(ctx, pool, originalURL, userID)
+	if (!ok || err != nil) && pool != r.db {
+		shortURL, ok, err = r.findByOriginalURL(ctx, r.db, originalURL, userID)
+	}
 	if err != nil {
 		return "", false
 	}
-	return urlRow.ShortURL, true
+	return shortURL, ok
 }
 
-// FindByUserID ищет все URL, принадлежащие пользователю.
-func (r *DBURLRepository) FindByUserID(userID uuid.UUID) ([]models.URLRow, bool) {
-	var urlRows []models.URLRow
+// findByOriginalURL ищет неудаленный URL с учетом области дедупликации.
+// URL анонимных пользователей хранятся с user_id NULL, поэтому пользователь сравнивается через IS NOT DISTINCT FROM.
+func (r DBURLRepository) findByOriginalURL(ctx context.Context, q querier, originalURL string, userID uuid.UUID) (string, bool, error) {
+	query := "SELECT short_url FROM url_rows WHERE original_url = $1 AND NOT is_deleted LIMIT 1"
+	args := []any{originalURL}
+	if r.dedupScope != DedupScopeGlobal {
+		query = "SELECT short_url FROM url_rows WHERE original_url = $1 AND user_id IS NOT DISTINCT FROM $2 AND NOT is_deleted LIMIT 1"
+		args = append(args, uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil})
+	}
+
+	var shortURL string
+	err := q.QueryRow(ctx, query, args...).Scan(&shortURL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return shortURL, true, nil
+}
+
+// FindByUserID ищет все URL, принадлежащие пользователю. Пустой список - успешный результат.
+// Если реплика не ответила или не нашла URL, поиск повторяется на основной БД.
+func (r *DBURLRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.URLRow, error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.FindByUserID")
+	defer span.End()
 
-	rows, err := r.db.Query("SELECT uuid, short_url, original_url FROM url_rows WHERE user_id = $1", userID)
+	pool := r.reads.forUser(userID)
+	urlRows, err := findByUserID(ctx, pool, userID)
+	if (len(urlRows) == 0 || err != nil) && pool != r.db {
+		urlRows, err = findByUserID(ctx, r.db, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return urlRows, nil
+}
+
+// findByUserID выбирает все URL пользователя из пула pool.
+func findByUserID(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) ([]models.URLRow, error) {
+	rows, err := pool.Query(ctx, "SELECT uuid, short_url, original_url, is_deleted, created_at, updated_at, deleted_at FROM url_rows WHERE user_id = $1", userID)
 	if err != nil {
-		return nil, false
+		return nil, err
 	}
 	defer rows.Close()
 
+	var urlRows []models.URLRow
 	for rows.Next() {
 		var urlRow models.URLRow
-		if err := rows.Scan(&urlRow.UUID, &urlRow.ShortURL, &urlRow.OriginalURL); err != nil {
-			return nil, false
+		urlRow.UserID = userID
+		if err := rows.Scan(&urlRow.UUID, &urlRow.ShortURL, &urlRow.OriginalURL, &urlRow.DeletedFlag, &urlRow.CreatedAt, &urlRow.UpdatedAt, &urlRow.DeletedAt); err != nil {
+			return nil, err
 		}
 		urlRows = append(urlRows, urlRow)
 	}
+	return urlRows, rows.Err()
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, false
+// FindByUserIDWithOptions выбирает страницу URL пользователя keyset-пагинацией:
+// следующая страница начинается строго после пары (ключ сортировки, uuid) из курсора,
+// что позволяет обходиться индексами по user_id без OFFSET.
+func (r *DBURLRepository) FindByUserIDWithOptions(ctx context.Context, userID uuid.UUID, opts models.URLQueryOptions) (_ []models.URLRow, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.FindByUserIDWithOptions")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	opts, cursor, err := normalizeQueryOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Исходные URL сравниваются побайтово, как и в остальных хранилищах.
+	sortColumn := "created_at"
+	if opts.SortBy == models.SortByOriginalURL {
+		sortColumn = `original_url COLLATE "C"`
+	}
+	order, comparison := "ASC", ">"
+	if opts.Descending {
+		order, comparison = "DESC", "<"
+	}
+
+	args := []any{userID}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions := []string{"user_id = $1"}
+	if opts.Search != "" {
+		conditions = append(conditions, "strpos(original_url, "+arg(opts.Search)+") > 0")
 	}
+	if opts.Deleted != nil {
+		conditions = append(conditions, "is_deleted = "+arg(*opts.Deleted))
+	}
+	if opts.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+arg(*opts.CreatedAfter))
+	}
+	if opts.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*opts.CreatedBefore))
+	}
+	if cursor != nil {
+		var key any = cursor.Key
+		if opts.SortBy == models.SortByCreatedAt {
+			key = cursor.row().CreatedAt
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, uuid) %s (%s, %s)", sortColumn, comparison, arg(key), arg(cursor.UUID)))
+	}
+
+	query := fmt.Sprintf(
+		"SELECT uuid, short_url, original_url, is_deleted, created_at, updated_at, deleted_at FROM url_rows WHERE %s ORDER BY %s %s, uuid %s",
+		strings.Join(conditions, " AND "), sortColumn, order, order)
+	if opts.Limit > 0 {
+		// Лишняя строка показывает, что за страницей есть продолжение.
+		query += " LIMIT " + arg(opts.Limit+1)
+	}
+
+	// Как и FindByUserID, при ошибке или пустой странице с реплики запрос повторяется на основной БД.
+	pool := r.reads.forUser(userID)
+	urlRows, err := queryUserURLRows(ctx, pool, userID, query, args)
+	if (len(urlRows) == 0 || err != nil) && pool != r.db {
+		urlRows, err = queryUserURLRows(ctx, r.db, userID, query, args)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if opts.Limit == 0 || len(urlRows) <= opts.Limit {
+		return urlRows, "", nil
+	}
+	urlRows = urlRows[:opts.Limit]
+	return urlRows, encodeCursor(opts, urlRows[len(urlRows)-1]), nil
+}
+
+// queryUserURLRows выполняет в пуле pool запрос страницы URL пользователя.
+func queryUserURLRows(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID, query string, args []any) ([]models.URLRow, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return urlRows, true
+	var urlRows []models.URLRow
+	for rows.Next() {
+		urlRow := models.URLRow{UserID: userID}
+		if err := rows.Scan(&urlRow.UUID, &urlRow.ShortURL, &urlRow.OriginalURL, &urlRow.DeletedFlag, &urlRow.CreatedAt, &urlRow.UpdatedAt, &urlRow.DeletedAt); err != nil {
+			return nil, err
+		}
+		urlRows = append(urlRows, urlRow)
+	}
+	return urlRows, rows.Err()
 }
 
 // Save сохраняет новый URL в базу данных.
-func (r DBURLRepository) Save(url models.URLToSave) (uuid.UUID, error) {
-	query := "INSERT INTO url_rows (uuid, short_url, original_url) VALUES ($1, $2, $3)"
-	UUID := uuid.New()
-	_, err := r.db.Exec(query, UUID, url.RandomPath, url.URLStr)
+func (r DBURLRepository) Save(ctx context.Context, url models.URLToSave) (_ uuid.UUID, err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.Save")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	UUID, err := r.insert(ctx, tx, url)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	if err := notifyURLEvent(ctx, tx, models.URLEventSaved, []string{url.RandomPath}); err != nil {
+		return uuid.UUID{}, err
+	}
+	r.reads.wrote(url.UserID, url.RandomPath)
+	return UUID, tx.Commit(ctx)
+}
+
+// BatchSave сохраняет несколько URL в базу данных одной транзакцией.
+func (r DBURLRepository) BatchSave(ctx context.Context, urls []models.URLToSave) (_ []uuid.UUID, err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.BatchSave")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var UUIDs []uuid.UUID
+	var shortURLs []string
+	for _, url := range urls {
+		UUID, err := r.insert(ctx, tx, url)
+		if err != nil {
+			return nil, err
+		}
+		UUIDs = append(UUIDs, UUID)
+		shortURLs = append(shortURLs, url.RandomPath)
+	}
+	if err := notifyURLEvent(ctx, tx, models.URLEventSaved, shortURLs); err != nil {
+		return nil, err
+	}
+	for _, url := range urls {
+		r.reads.wrote(url.UserID, url.RandomPath)
+	}
+
+	return UUIDs, tx.Commit(ctx)
+}
+
+// insert проверяет дедупликацию и вставляет URL в рамках транзакции.
+// Занятость короткого адреса проверяет уникальный индекс idx_unique_short_url,
+// в области global повтор оригинального URL дополнительно отсекает idx_unique_live_original_url.
+// Проверка выполняется под транзакционной advisory-блокировкой по оригинальному URL,
+// поэтому параллельные вставки одного и того же URL не проходят проверку одновременно.
+func (r DBURLRepository) insert(ctx context.Context, tx pgx.Tx, url models.URLToSave) (uuid.UUID, error) {
+	userID := uuid.NullUUID{UUID: url.UserID, Valid: url.UserID != uuid.Nil}
+	if userID.Valid {
+		if err := ensureUser(ctx, tx, url.UserID); err != nil {
+			return uuid.UUID{}, err
+		}
+	}
+
+	if r.dedupScope != DedupScopeNone {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", url.URLStr); err != nil {
+			return uuid.UUID{}, err
+		}
+		_, exists, err := r.findByOriginalURL(ctx, tx, url.URLStr, url.UserID)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+		if exists {
+			return uuid.UUID{}, &apperrors.OriginalURLAlreadyExists{URL: url.URLStr}
+		}
+	}
+
+	query := "INSERT INTO url_rows (uuid, short_url, original_url, user_id) VALUES ($1, $2, $3, $4)"
+	UUID := uuid.New()
+	if _, err := tx.Exec(ctx, query, UUID, url.RandomPath, url.URLStr, userID); err != nil {
 		var pgErr *pgconn.PgError
-		if ok := errors.As(err, &pgErr); ok {
-			switch pgErr.Code {
-			case pgerrcode.UniqueViolation:
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			switch pgErr.ConstraintName {
+			case "idx_unique_short_url":
+				return uuid.UUID{}, &apperrors.ShortURLAlreadyExists{ShortURL: url.RandomPath}
+			case uniqueOriginalURLIndex:
 				return uuid.UUID{}, &apperrors.OriginalURLAlreadyExists{URL: url.URLStr}
-			default:
-				return uuid.UUID{}, err
 			}
 		}
 		return uuid.UUID{}, err
@@ -90,100 +328,471 @@ func (r DBURLRepository) Save(url models.URLToSave) (uuid.UUID, error) {
 	return UUID, nil
 }
 
-// BatchSave сохраняет несколько URL в базу данных одной транзакцией.
-func (r DBURLRepository) BatchSave(urls []models.URLToSave) ([]uuid.UUID, error) {
-	query := "INSERT INTO url_rows (uuid, short_url, original_url) VALUES ($1, $2, $3)"
-	var UUIDs []uuid.UUID
-	tx, err := r.db.Begin()
+// BatchDelete помечает URL как удаленные для указанного пользователя.
+func (r *DBURLRepository) BatchDelete(ctx context.Context, urls []string, userID uuid.UUID) (err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.BatchDelete")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return UUIDs, err
+		return err
 	}
+	defer tx.Rollback(ctx)
 
-	var errs []error
+	// Уже удаленные URL не трогаются, чтобы повторное удаление не откладывало их окончательное стирание.
+	query := `UPDATE url_rows SET is_deleted = true, deleted_at = now(), updated_at = now()
+		WHERE user_id = $1 AND short_url = ANY($2) AND NOT is_deleted
+		RETURNING short_url`
 
-	for _, url := range urls {
-		UUID := uuid.New()
-		_, err := r.db.Exec(query, UUID, url.RandomPath, url.URLStr)
-		if err != nil {
-			rollbackErr := tx.Rollback()
-			if rollbackErr != nil {
-				errs = append(errs, fmt.Errorf("rollback error: %v; original error: %v", rollbackErr, err))
-				continue
-			}
-			errs = append(errs, err)
-			continue
+	rows, err := tx.Query(ctx, query, userID, urls)
+	if err != nil {
+		return err
+	}
+	deleted, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	if err := notifyURLEvent(ctx, tx, models.URLEventChanged, deleted); err != nil {
+		return err
+	}
+	r.reads.wrote(userID, urls...)
+	return tx.Commit(ctx)
+}
+
+// BatchDeleteByUsers помечает URL как удаленные сразу для нескольких пользователей одним запросом.
+func (r *DBURLRepository) BatchDeleteByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) (err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.BatchDeleteByUsers")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	query := `UPDATE url_rows SET is_deleted = true, deleted_at = now(), updated_at = now()
+		FROM unnest($1::uuid[], $2::text[]) AS d(user_id, short_url)
+		WHERE url_rows.user_id = d.user_id AND url_rows.short_url = d.short_url AND NOT url_rows.is_deleted
+		RETURNING url_rows.short_url`
+
+	var userIDs []uuid.UUID
+	var shortURLs []string
+	for userID, urls := range urlsByUser {
+		for _, shortURL := range urls {
+			userIDs = append(userIDs, userID)
+			shortURLs = append(shortURLs, shortURL)
 		}
-		UUIDs = append(UUIDs, UUID)
 	}
 
-	if len(errs) > 0 {
-		return UUIDs, errors.Join(errs...)
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback(ctx)
 
-	return UUIDs, tx.Commit()
+	rows, err := tx.Query(ctx, query, userIDs, shortURLs)
+	if err != nil {
+		return err
+	}
+	deleted, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	if err := notifyURLEvent(ctx, tx, models.URLEventChanged, deleted); err != nil {
+		return err
+	}
+	for userID, urls := range urlsByUser {
+		r.reads.wrote(userID, urls...)
+	}
+	return tx.Commit(ctx)
 }
 
-// BatchDelete помечает URL как удаленные для указанного пользователя.
-func (r *DBURLRepository) BatchDelete(urls []string, userID uuid.UUID) error {
-	query := `UPDATE url_rows SET is_deleted = true WHERE user_id = $1 AND short_url = ANY($2)`
+// BatchRestoreByUsers снимает пометку об удалении с URL нескольких пользователей в одной транзакции.
+// Оригинальный адрес каждого URL блокируется той же advisory-блокировкой, что и при сохранении,
+// и URL не восстанавливается, если этот адрес уже сокращен заново в области дедупликации.
+func (r *DBURLRepository) BatchRestoreByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) (err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.BatchRestoreByUsers")
+	defer func() { tracing.RecordError(span, err); span.End() }()
 
-	result, err := r.db.Exec(query, userID, urls)
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	_, err = result.RowsAffected()
+	var shortURLs []string
+	for userID, urls := range urlsByUser {
+		for _, shortURL := range urls {
+			if err := r.restore(ctx, tx, userID, shortURL); err != nil {
+				return err
+			}
+		}
+		shortURLs = append(shortURLs, urls...)
+		r.reads.wrote(userID, urls...)
+	}
+	if err := notifyURLEvent(ctx, tx, models.URLEventChanged, shortURLs); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// UpdateOriginalURL меняет исходный URL пользователя в одной транзакции и записывает прежний в url_history.
+// Новый исходный URL блокируется той же advisory-блокировкой, что и при сохранении.
+func (r *DBURLRepository) UpdateOriginalURL(ctx context.Context, shortURL string, userID uuid.UUID, originalURL string) (_ models.URLRow, err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.UpdateOriginalURL")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return models.URLRow{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var urlRow models.URLRow
+	var ownerID uuid.NullUUID
+	row := tx.QueryRow(ctx,
+		"SELECT uuid, short_url, original_url, is_deleted, user_id, created_at, updated_at FROM url_rows WHERE short_url = $1 FOR UPDATE",
+		shortURL)
+	err = row.Scan(&urlRow.UUID, &urlRow.ShortURL, &urlRow.OriginalURL, &urlRow.DeletedFlag, &ownerID, &urlRow.CreatedAt, &urlRow.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.URLRow{}, &apperrors.URLNotFound{ShortURL: shortURL}
+	}
+	if err != nil {
+		return models.URLRow{}, err
+	}
+	urlRow.UserID = ownerID.UUID
+	if !ownerID.Valid || ownerID.UUID != userID {
+		return models.URLRow{}, &apperrors.URLAccessDenied{ShortURL: shortURL}
+	}
+	if urlRow.DeletedFlag {
+		return models.URLRow{}, &apperrors.URLIsDeleted{ShortURL: shortURL}
+	}
+
+	if urlRow.OriginalURL != originalURL {
+		if r.dedupScope != DedupScopeNone {
+			if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", originalURL); err != nil {
+				return models.URLRow{}, err
+			}
+			_, exists, err := r.findByOriginalURL(ctx, tx, originalURL, userID)
+			if err != nil {
+				return models.URLRow{}, err
+			}
+			if exists {
+				return models.URLRow{}, &apperrors.OriginalURLAlreadyExists{URL: originalURL}
+			}
+		}
+
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO url_history (url_uuid, original_url) VALUES ($1, $2)",
+			urlRow.UUID, urlRow.OriginalURL); err != nil {
+			return models.URLRow{}, err
+		}
+		row := tx.QueryRow(ctx,
+			"UPDATE url_rows SET original_url = $1, updated_at = now() WHERE uuid = $2 RETURNING updated_at",
+			originalURL, urlRow.UUID)
+		if err := row.Scan(&urlRow.UpdatedAt); err != nil {
+			return models.URLRow{}, err
+		}
+		urlRow.OriginalURL = originalURL
+		if err := notifyURLEvent(ctx, tx, models.URLEventChanged, []string{shortURL}); err != nil {
+			return models.URLRow{}, err
+		}
+		r.reads.wrote(userID, shortURL)
+	}
+
+	if urlRow.History, err = findHistory(ctx, tx, urlRow.UUID); err != nil {
+		return models.URLRow{}, err
+	}
+	return urlRow, tx.Commit(ctx)
+}
+
+// findHistory возвращает предыдущие исходные URL строки от старых к новым.
+func findHistory(ctx context.Context, tx pgx.Tx, urlUUID uuid.UUID) ([]models.URLHistoryRecord, error) {
+	rows, err := tx.Query(ctx,
+		"SELECT original_url, replaced_at FROM url_history WHERE url_uuid = $1 ORDER BY id", urlUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.URLHistoryRecord
+	for rows.Next() {
+		var record models.URLHistoryRecord
+		if err := rows.Scan(&record.OriginalURL, &record.ReplacedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, record)
+	}
+	return history, rows.Err()
+}
+
+// PurgeDeleted окончательно удаляет URL, удаленные раньше deletedBefore, пачками по batchSize строк,
+// чтобы не держать долгие блокировки. Каждая пачка стирается отдельной транзакцией вместе с публикацией события. Удаление выполняется под сессионной advisory-блокировкой:
+// если ее уже держит другой экземпляр сервиса, метод ничего не удаляет.
+func (r *DBURLRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (purged int, err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.PurgeDeleted")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	conn, err := r.db.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", purgeLockKey).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", purgeLockKey)
+
+	batchSize = purgeBatchSize(batchSize)
+	for {
+		affected, err := purgeBatch(ctx, conn, deletedBefore, batchSize)
+		if err != nil {
+			return purged, err
+		}
+		purged += affected
+		if affected < batchSize {
+			return purged, nil
+		}
+	}
+}
+
+// purgeBatch стирает одну пачку удаленных URL и публикует их короткие адреса.
+func purgeBatch(ctx context.Context, conn *pgxpool.Conn, deletedBefore time.Time, batchSize int) (int, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `DELETE FROM url_rows WHERE uuid IN (
+		SELECT uuid FROM url_rows WHERE is_deleted AND deleted_at < $1 ORDER BY deleted_at LIMIT $2)
+		RETURNING short_url`
+
+	rows, err := tx.Query(ctx, query, deletedBefore, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	var shortURLs []string
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		shortURLs = append(shortURLs, shortURL)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := notifyURLEvent(ctx, tx, models.URLEventChanged, shortURLs); err != nil {
+		return 0, err
+	}
+	return len(shortURLs), tx.Commit(ctx)
+}
+
+// ForEachShortURL передает в fn короткие адреса всех URL в БД, включая удаленные.
+// Строки читаются курсором результата, не загружаясь в память целиком.
+func (r *DBURLRepository) ForEachShortURL(ctx context.Context, fn func(shortURL string)) (err error) {
+	ctx, span := tracing.Start(ctx, "DBURLRepository.ForEachShortURL")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	rows, err := r.db.Query(ctx, "SELECT short_url FROM url_rows")
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
-	return nil
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return err
+		}
+		fn(shortURL)
+	}
+	return rows.Err()
+}
+
+// restore восстанавливает один удаленный URL пользователя, если это не нарушает дедупликацию.
+func (r *DBURLRepository) restore(ctx context.Context, tx pgx.Tx, userID uuid.UUID, shortURL string) error {
+	var originalURL string
+	row := tx.QueryRow(ctx,
+		"SELECT original_url FROM url_rows WHERE user_id = $1 AND short_url = $2 AND is_deleted FOR UPDATE",
+		userID, shortURL)
+	if err := row.Scan(&originalURL); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// URL не удален, принадлежит другому пользователю или уже окончательно удален.
+			return nil
+		}
+		return err
+	}
+
+	if r.dedupScope != DedupScopeNone {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", originalURL); err != nil {
+			return err
+		}
+		_, exists, err := r.findByOriginalURL(ctx, tx, originalURL, userID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+
+	_, err := tx.Exec(ctx,
+		"UPDATE url_rows SET is_deleted = false, deleted_at = NULL, updated_at = now() WHERE user_id = $1 AND short_url = $2 AND is_deleted",
+		userID, shortURL)
+	return err
+}
+
+// querier общий интерфейс подключения и транзакции для выполнения запросов.
+type querier interface {
+	QueryRow(ctx context.Context, query string, args ...any) pgx.Row
+}
+
+// ensureUser создает пользователя, если его еще нет в таблице users.
+func ensureUser(ctx context.Context, tx pgx.Tx, userID uuid.UUID) error {
+	_, err := tx.Exec(ctx, "INSERT INTO users (uuid) VALUES ($1) ON CONFLICT (uuid) DO NOTHING", userID)
+	return err
 }
 
 // UpdateUser обновляет пользователя для указанного URL.
-func (r DBUserRepository) UpdateUser(savedURLUUID uuid.UUID, userID uuid.UUID) error {
-	query := "UPDATE url_rows SET user_id = $1 WHERE uuid = $2"
-	result, err := r.db.Exec(query, userID, savedURLUUID)
+func (r DBUserRepository) UpdateUser(ctx context.Context, savedURLUUID uuid.UUID, userID uuid.UUID) (err error) {
+	ctx, span := tracing.Start(ctx, "DBUserRepository.UpdateUser")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
+	defer tx.Rollback(ctx)
+
+	if err := ensureUser(ctx, tx, userID); err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
+	query := "UPDATE url_rows SET user_id = $1, updated_at = now() WHERE uuid = $2 RETURNING short_url"
+	var shortURL string
+	err = tx.QueryRow(ctx, query, userID, savedURLUUID).Scan(&shortURL)
+	if errors.Is(err, pgx.ErrNoRows) {
 		return errors.New("no rows were updated")
 	}
-	return nil
+	if err != nil {
+		return err
+	}
+	if err := notifyURLEvent(ctx, tx, models.URLEventChanged, []string{shortURL}); err != nil {
+		return err
+	}
+	r.reads.wrote(userID, shortURL)
+	return tx.Commit(ctx)
 }
 
 // UpdateBatchUser обновляет пользователя для нескольких URL.
-func (r *DBUserRepository) UpdateBatchUser(savedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
-	query := `UPDATE url_rows SET user_id = $1 WHERE uuid = ANY($2)`
+func (r *DBUserRepository) UpdateBatchUser(ctx context.Context, savedURLUUIDs []uuid.UUID, userID uuid.UUID) (err error) {
+	ctx, span := tracing.Start(ctx, "DBUserRepository.UpdateBatchUser")
+	defer func() { tracing.RecordError(span, err); span.End() }()
 
-	result, err := r.db.Exec(query, userID, savedURLUUIDs)
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
+
+	if err := ensureUser(ctx, tx, userID); err != nil {
+		return err
+	}
+	query := `UPDATE url_rows SET user_id = $1, updated_at = now() WHERE uuid = ANY($2) RETURNING short_url`
 
-	rowsAffected, err := result.RowsAffected()
+	rows, err := tx.Query(ctx, query, userID, savedURLUUIDs)
 	if err != nil {
 		return err
 	}
+	var shortURLs []string
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			rows.Close()
+			return err
+		}
+		shortURLs = append(shortURLs, shortURL)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	if int(rowsAffected) != len(savedURLUUIDs) {
-		return fmt.Errorf("expected to update %d rows, but %d rows were updated", len(savedURLUUIDs), rowsAffected)
+	if len(shortURLs) != len(savedURLUUIDs) {
+		return fmt.Errorf("expected to update %d rows, but %d rows were updated", len(savedURLUUIDs), len(shortURLs))
+	}
+	if err := notifyURLEvent(ctx, tx, models.URLEventChanged, shortURLs); err != nil {
+		return err
 	}
+	r.reads.wrote(userID, shortURLs...)
+
+	return tx.Commit(ctx)
+}
+
+// uniqueOriginalURLIndex уникальный индекс неудаленных оригинальных URL, нужный только области дедупликации global.
+const uniqueOriginalURLIndex = "idx_unique_live_original_url"
 
+// PrepareDedupIndex приводит уникальный индекс оригинальных URL в соответствие с областью дедупликации:
+// в области global он должен быть, в областях user и none - нет. При align индекс создается или удаляется,
+// иначе при несоответствии возвращается ошибка, чтобы сервер не запустился с чужой схемой.
+func (r *DBURLRepository) PrepareDedupIndex(ctx context.Context, align bool) error {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE tablename = 'url_rows' AND indexname = $1)"
+	if err := r.db.QueryRow(ctx, query, uniqueOriginalURLIndex).Scan(&exists); err != nil {
+		return err
+	}
+	want := r.dedupScope == DedupScopeGlobal
+	if exists == want {
+		return nil
+	}
+	if !align {
+		if want {
+			return fmt.Errorf("dedup scope %s requires unique index %s", r.dedupScope, uniqueOriginalURLIndex)
+		}
+		return fmt.Errorf("dedup scope %s conflicts with unique index %s, drop it before switching the scope", r.dedupScope, uniqueOriginalURLIndex)
+	}
+	if want {
+		// Не пройдет, если в другой области один URL уже был сокращен несколько раз.
+		query = "CREATE UNIQUE INDEX IF NOT EXISTS " + uniqueOriginalURLIndex + " ON url_rows (original_url) WHERE NOT is_deleted"
+	} else {
+		query = "DROP INDEX IF EXISTS " + uniqueOriginalURLIndex
+	}
+	if _, err := r.db.Exec(ctx, query); err != nil {
+		return fmt.Errorf("prepare index %s for dedup scope %s: %w", uniqueOriginalURLIndex, r.dedupScope, err)
+	}
 	return nil
 }
 
 // NewDBURLRepository создает новый экземпляр репозитория URL.
-func NewDBURLRepository(db *sql.DB) (*DBURLRepository, error) {
-	return &DBURLRepository{db: db}, nil
+func NewDBURLRepository(db *pgxpool.Pool, dedupScope string) (*DBURLRepository, error) {
+	scope, err := parseDedupScope(dedupScope)
+	if err != nil {
+		return nil, err
+	}
+	return &DBURLRepository{db: db, reads: primaryOnly(db), dedupScope: scope}, nil
 }
 
 // NewDBUserRepository создает новый экземпляр репозитория пользователей.
-func NewDBUserRepository(db *sql.DB) (*DBUserRepository, error) {
-	return &DBUserRepository{db: db}, nil
+func NewDBUserRepository(db *pgxpool.Pool) (*DBUserRepository, error) {
+	return &DBUserRepository{db: db, reads: primaryOnly(db)}, nil
+}
+
+// NewReplicatedDBURLRepository создает репозиторий URL, который пишет в основную БД,
+// а поиск выполняет на репликах, выбранных reads.
+func NewReplicatedDBURLRepository(reads *ReadRouter, dedupScope string) (*DBURLRepository, error) {
+	repo, err := NewDBURLRepository(reads.primary, dedupScope)
+	if err != nil {
+		return nil, err
+	}
+	repo.reads = reads
+	return repo, nil
+}
+
+// NewReplicatedDBUserRepository создает репозиторий пользователей, который отмечает записи в reads,
+// чтобы URL пользователя сразу после них читались с основной БД.
+func NewReplicatedDBUserRepository(reads *ReadRouter) (*DBUserRepository, error) {
+	return &DBUserRepository{db: reads.primary, reads: reads}, nil
 }
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// Области дедупликации оригинальных URL.
+const (
+	DedupScopeGlobal = "global" // Оригинальный URL уникален среди всех неудаленных ссылок.
+	DedupScopeUser   = "user"   // Оригинальный URL уникален среди неудаленных ссылок пользователя.
+	DedupScopeNone   = "none"   // Один и тот же URL можно сокращать сколько угодно раз.
+)
+
+// parseDedupScope проверяет область дедупликации; пустое значение означает global.
+func parseDedupScope(scope string) (string, error) {
+	switch scope {
+	case "":
+		return DedupScopeGlobal, nil
+	case DedupScopeGlobal, DedupScopeUser, DedupScopeNone:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown dedup scope: %s", scope)
+	}
+}
+
+// dedupMatches проверяет, что неудаленная строка с тем же оригинальным URL
+// видна пользователю userID в указанной области дедупликации.
+// Пустая область считается глобальной.
+func dedupMatches(scope string, urlRow models.URLRow, originalURL string, userID uuid.UUID) bool {
+	if urlRow.DeletedFlag || urlRow.OriginalURL != originalURL {
+		return false
+	}
+	if scope == DedupScopeUser || scope == DedupScopeNone {
+		return urlRow.UserID == userID
+	}
+	return true
+}
+
+// dedupConflicts проверяет, что сохранение url конфликтует с существующей строкой.
+func dedupConflicts(scope string, urlRow models.URLRow, url models.URLToSave) bool {
+	return scope != DedupScopeNone && dedupMatches(scope, urlRow, url.URLStr, url.UserID)
+}
+
+// checkConflict проверяет, что url можно сохранить рядом с существующей строкой:
+// короткий адрес должен быть свободен, а оригинальный URL - не сокращен в области дедупликации.
+func checkConflict(scope string, urlRow models.URLRow, url models.URLToSave) error {
+	if urlRow.ShortURL == url.RandomPath {
+		return &apperrors.ShortURLAlreadyExists{ShortURL: url.RandomPath}
+	}
+	if dedupConflicts(scope, urlRow, url) {
+		return &apperrors.OriginalURLAlreadyExists{URL: url.URLStr}
+	}
+	return nil
+}
+
+// checkBatchConflicts проверяет, что список url можно сохранить в хранилище со строками urlRows:
+// каждый url не должен конфликтовать ни с существующими строками, ни с предыдущими url списка.
+func checkBatchConflicts(scope string, urlRows []models.URLRow, urls []models.URLToSave) error {
+	for i, url := range urls {
+		for _, urlRow := range urlRows {
+			if err := checkConflict(scope, urlRow, url); err != nil {
+				return err
+			}
+		}
+		for _, previous := range urls[:i] {
+			pending := models.URLRow{ShortURL: previous.RandomPath, OriginalURL: previous.URLStr, UserID: previous.UserID}
+			if err := checkConflict(scope, pending, url); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreURLRows снимает пометку об удалении с URL пользователей в списке строк хранилища.
+// URL не восстанавливается, если его оригинальный адрес уже сокращен заново в области дедупликации.
+// Строки, которых больше нет в хранилище, пропускаются.
+func restoreURLRows(scope string, urlRows []models.URLRow, urlsByUser map[uuid.UUID][]string, at time.Time) {
+	toRestore := make(map[uuid.UUID]map[string]bool, len(urlsByUser))
+	for userID, urls := range urlsByUser {
+		toRestore[userID] = make(map[string]bool, len(urls))
+		for _, shortURL := range urls {
+			toRestore[userID][shortURL] = true
+		}
+	}
+
+	for i, urlRow := range urlRows {
+		if !urlRow.DeletedFlag || !toRestore[urlRow.UserID][urlRow.ShortURL] {
+			continue
+		}
+		if scope != DedupScopeNone && hasLiveDuplicate(scope, urlRows, urlRow) {
+			continue
+		}
+		urlRows[i].Restore(at)
+	}
+}
+
+// hasLiveDuplicate проверяет, что оригинальный адрес строки уже занят неудаленным URL в области дедупликации.
+func hasLiveDuplicate(scope string, urlRows []models.URLRow, urlRow models.URLRow) bool {
+	for _, other := range urlRows {
+		if dedupMatches(scope, other, urlRow.OriginalURL, urlRow.UserID) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,26 +2,99 @@ package repository
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
 )
 
+// fileStores хранит состояние файлов хранилища, чтобы репозитории URL и пользователей,
+// работающие с одним файлом, не перезаписывали его одновременно и видели один индекс.
+var fileStores sync.Map
+
+// fileStore общее для всех репозиториев состояние файла хранилища.
+// Индекс читается и меняется только под блокировкой mu на запись.
+type fileStore struct {
+	mu    sync.RWMutex // Мьютекс файла хранилища.
+	index *fileIndex   // Индекс для проверки конфликтов; nil, пока не загружен из файла.
+}
+
+// storeFor возвращает общее для всех репозиториев состояние файла хранилища.
+func storeFor(filePath string) *fileStore {
+	store, _ := fileStores.LoadOrStore(filePath, &fileStore{})
+	return store.(*fileStore)
+}
+
+// fileIndex индекс коротких и оригинальных URL файла хранилища, чтобы сохранение
+// не перечитывало файл целиком ради проверки конфликтов.
+type fileIndex struct {
+	shortURLs map[string]bool            // Короткие адреса всех строк, включая удаленные.
+	live      map[string][]models.URLRow // Неудаленные строки по оригинальному URL.
+}
+
+// newFileIndex строит индекс по строкам файла.
+func newFileIndex(urlRows []models.URLRow) *fileIndex {
+	idx := &fileIndex{shortURLs: make(map[string]bool, len(urlRows)), live: make(map[string][]models.URLRow)}
+	for _, urlRow := range urlRows {
+		idx.add(urlRow)
+	}
+	return idx
+}
+
+// add добавляет в индекс строку, дописанную в файл.
+func (idx *fileIndex) add(urlRow models.URLRow) {
+	idx.shortURLs[urlRow.ShortURL] = true
+	if !urlRow.DeletedFlag {
+		idx.live[urlRow.OriginalURL] = append(idx.live[urlRow.OriginalURL], urlRow)
+	}
+}
+
+// checkConflicts проверяет список url по тем же правилам, что и checkBatchConflicts,
+// сравнивая каждый url только со строками с тем же коротким или оригинальным адресом.
+func (idx *fileIndex) checkConflicts(scope string, urls []models.URLToSave) error {
+	for i, url := range urls {
+		if idx.shortURLs[url.RandomPath] {
+			return &apperrors.ShortURLAlreadyExists{ShortURL: url.RandomPath}
+		}
+		if err := checkBatchConflicts(scope, idx.live[url.URLStr], urls[i:i+1]); err != nil {
+			return err
+		}
+	}
+	return checkBatchConflicts(scope, nil, urls)
+}
+
+// fileContents содержимое файла хранилища. Строки, которые не удалось разобрать, пропускаются
+// при чтении, но сохраняются при перезаписи файла, чтобы не терять данные.
+type fileContents struct {
+	urlRows   []models.URLRow // Разобранные строки URL.
+	malformed []string        // Строки, которые не удалось разобрать.
+}
+
 // FileURLRepository представляет репозиторий URL, хранящийся в файле.
 type FileURLRepository struct {
-	filePath string         // Путь к файлу для хранения данных.
-	Logger   *logger.Logger // Логгер для регистрации событий.
+	filePath   string         // Путь к файлу для хранения данных.
+	store      *fileStore     // Общее состояние файла хранилища.
+	dedupScope string         // Область дедупликации оригинальных URL.
+	Logger     *logger.Logger // Логгер для регистрации событий.
 }
 
 // FileUserRepository представляет репозиторий пользователей, хранящийся в файле.
 type FileUserRepository struct {
 	filePath string         // Путь к файлу для хранения данных.
+	store    *fileStore     // Общее состояние файла хранилища.
 	Logger   *logger.Logger // Логгер для регистрации событий.
 }
 
@@ -34,21 +107,10 @@ func (r *FileURLRepository) newScanner() (*bufio.Scanner, *os.File, error) {
 	return bufio.NewScanner(file), file, nil
 }
 
-// newWriter создает новый writer для записи данных в файл.
-func (r *FileURLRepository) newWriter(truncateModes ...bool) (*bufio.Writer, *os.File, error) {
-	truncateMode := false
-	if len(truncateModes) > 0 {
-		truncateMode = truncateModes[0]
-	}
-
-	flags := os.O_WRONLY | os.O_CREATE
-	if truncateMode {
-		flags |= os.O_TRUNC
-	} else {
-		flags |= os.O_APPEND
-	}
-
-	file, err := os.OpenFile(r.filePath, flags, 0666)
+// newWriter создает новый writer для дописывания данных в конец файла.
+// Файл целиком перезаписывается только через rewrite.
+func (r *FileURLRepository) newWriter() (*bufio.Writer, *os.File, error) {
+	file, err := os.OpenFile(r.filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -56,45 +118,58 @@ func (r *FileURLRepository) newWriter(truncateModes ...bool) (*bufio.Writer, *os
 }
 
 // Find ищет URL по сокращенному адресу в файле.
-func (r FileURLRepository) Find(shortURL string) (models.URLRow, bool) {
+func (r FileURLRepository) Find(ctx context.Context, shortURL string) (models.URLRow, bool, error) {
+	_, span := tracing.Start(ctx, "FileURLRepository.Find")
+	defer span.End()
+
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
 	scanner, file, err := r.newScanner()
+	if errors.Is(err, os.ErrNotExist) {
+		return models.URLRow{}, false, nil
+	}
 	if err != nil {
-		r.Logger.Errorf("Error creating scanner: %v", err)
-		return models.URLRow{}, false
+		r.Logger.With(ctx).Errorf("Error creating scanner: %v", err)
+		return models.URLRow{}, false, err
 	}
 	defer file.Close()
 	for scanner.Scan() {
-		var urlRow models.URLRow
-		line := scanner.Text()
-		err := json.Unmarshal([]byte(line), &urlRow)
-		if err != nil {
-			r.Logger.Debugf("cannot decode request JSON body: %s", err)
-			return models.URLRow{}, false
+		urlRow, ok := decodeURLRow(ctx, scanner.Bytes(), r.Logger)
+		if !ok {
+			continue
 		}
 		if urlRow.ShortURL == shortURL {
-			return urlRow, true
+			return urlRow, true, nil
 		}
 	}
-	return models.URLRow{}, false
+	return models.URLRow{}, false, scanner.Err()
 }
 
-// FindByOriginalURL ищет сокращенный URL по оригинальному адресу в файле.
-func (r FileURLRepository) FindByOriginalURL(originalURL string) (string, bool) {
+// FindByOriginalURL ищет сокращенный URL по оригинальному адресу среди неудаленных URL в файле.
+// В области дедупликации user и none поиск ведется только среди URL пользователя.
+func (r FileURLRepository) FindByOriginalURL(ctx context.Context, originalURL string, userID uuid.UUID) (string, bool) {
+	_, span := tracing.Start(ctx, "FileURLRepository.FindByOriginalURL")
+	defer span.End()
+
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
 	scanner, file, err := r.newScanner()
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false
+	}
 	if err != nil {
-		r.Logger.Errorf("Error creating scanner: %v", err)
+		r.Logger.With(ctx).Errorf("Error creating scanner: %v", err)
 		return "", false
 	}
 	defer file.Close()
 	for scanner.Scan() {
-		var urlRow models.URLRow
-		line := scanner.Text()
-		err := json.Unmarshal([]byte(line), &urlRow)
-		if err != nil {
-			r.Logger.Debugf("cannot decode request JSON body: %s", err)
-			return "", false
+		urlRow, ok := decodeURLRow(ctx, scanner.Bytes(), r.Logger)
+		if !ok {
+			continue
 		}
-		if urlRow.OriginalURL == originalURL {
+		if dedupMatches(r.dedupScope, urlRow, originalURL, userID) {
 			return urlRow.ShortURL, true
 		}
 	}
@@ -102,21 +177,27 @@ func (r FileURLRepository) FindByOriginalURL(originalURL string) (string, bool)
 }
 
 // FindByUserID ищет все URL, принадлежащие пользователю, в файле.
-func (r *FileURLRepository) FindByUserID(userID uuid.UUID) ([]models.URLRow, bool) {
+func (r *FileURLRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.URLRow, error) {
+	_, span := tracing.Start(ctx, "FileURLRepository.FindByUserID")
+	defer span.End()
+
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
 	var urlRows []models.URLRow
 	scanner, file, err := r.newScanner()
+	if errors.Is(err, os.ErrNotExist) {
+		return []models.URLRow{}, nil
+	}
 	if err != nil {
-		r.Logger.Errorf("Error creating scanner: %v", err)
-		return []models.URLRow{}, false
+		r.Logger.With(ctx).Errorf("Error creating scanner: %v", err)
+		return nil, err
 	}
 	defer file.Close()
 
 	for scanner.Scan() {
-		var urlRow models.URLRow
-		line := scanner.Text()
-		err := json.Unmarshal([]byte(line), &urlRow)
-		if err != nil {
-			r.Logger.Debugf("cannot decode line JSON: %s", err)
+		urlRow, ok := decodeURLRow(ctx, scanner.Bytes(), r.Logger)
+		if !ok {
 			continue
 		}
 		if urlRow.UserID == userID {
@@ -124,308 +205,508 @@ func (r *FileURLRepository) FindByUserID(userID uuid.UUID) ([]models.URLRow, boo
 		}
 	}
 
-	return urlRows, true
+	return urlRows, scanner.Err()
+}
+
+// FindByUserIDWithOptions выбирает страницу URL пользователя из файла с сортировкой и фильтрами.
+// В памяти держатся только URL пользователя, прошедшие фильтры.
+func (r *FileURLRepository) FindByUserIDWithOptions(ctx context.Context, userID uuid.UUID, opts models.URLQueryOptions) ([]models.URLRow, string, error) {
+	_, span := tracing.Start(ctx, "FileURLRepository.FindByUserIDWithOptions")
+	defer span.End()
+
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	scanner, file, err := r.newScanner()
+	if errors.Is(err, os.ErrNotExist) {
+		return queryURLRows(nil, opts)
+	}
+	if err != nil {
+		r.Logger.With(ctx).Errorf("Error creating scanner: %v", err)
+		return nil, "", err
+	}
+	defer file.Close()
+
+	var urlRows []models.URLRow
+	for scanner.Scan() {
+		urlRow, ok := decodeURLRow(ctx, scanner.Bytes(), r.Logger)
+		if !ok {
+			continue
+		}
+		if urlRow.UserID == userID && matchesQueryOptions(opts, urlRow) {
+			urlRows = append(urlRows, urlRow)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	return queryURLRows(urlRows, opts)
 }
 
 // Save сохраняет новый URL в файл.
-func (r FileURLRepository) Save(url models.URLToSave) (uuid.UUID, error) {
+func (r FileURLRepository) Save(ctx context.Context, url models.URLToSave) (uuid.UUID, error) {
+	_, span := tracing.Start(ctx, "FileURLRepository.Save")
+	defer span.End()
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	idx, err := r.index(ctx)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	if err := idx.checkConflicts(r.dedupScope, []models.URLToSave{url}); err != nil {
+		return uuid.UUID{}, err
+	}
+
 	writer, file, err := r.newWriter()
 	if err != nil {
-		r.Logger.Errorf("Error creating writer: %v", err)
+		r.Logger.With(ctx).Errorf("Error creating writer: %v", err)
 		return uuid.UUID{}, err
 	}
 	defer file.Close()
 
 	UUID := uuid.New()
-	URLRowObject := models.URLRow{UUID: UUID, ShortURL: url.RandomPath, OriginalURL: url.URLStr, DeletedFlag: false}
+	now := time.Now().UTC()
+	URLRowObject := models.URLRow{UUID: UUID, ShortURL: url.RandomPath, OriginalURL: url.URLStr, DeletedFlag: false, UserID: url.UserID, CreatedAt: now, UpdatedAt: now}
 	data, err := json.Marshal(URLRowObject)
 	if err != nil {
-		r.Logger.Debugf("Cannot encode json: %s", err)
+		r.Logger.With(ctx).Debugf("Cannot encode json: %s", err)
 	}
 	_, err = writer.WriteString(string(data) + "\n")
 	if err != nil {
-		r.Logger.Debugf("Cannot write data: %s", err)
+		r.Logger.With(ctx).Debugf("Cannot write data: %s", err)
 		return UUID, err
 	}
 	if err := writer.Flush(); err != nil {
-		r.Logger.Errorf("Error flushing writer: %v", err)
+		r.Logger.With(ctx).Errorf("Error flushing writer: %v", err)
+		// Строка могла записаться частично, индекс перечитывается из файла при следующем сохранении.
+		r.store.index = nil
 		return uuid.UUID{}, err
 	}
+	idx.add(URLRowObject)
 	return UUID, nil
 }
 
 // BatchSave сохраняет несколько URL в файл одной транзакцией.
-func (r FileURLRepository) BatchSave(urls []models.URLToSave) ([]uuid.UUID, error) {
+func (r FileURLRepository) BatchSave(ctx context.Context, urls []models.URLToSave) ([]uuid.UUID, error) {
+	_, span := tracing.Start(ctx, "FileURLRepository.BatchSave")
+	defer span.End()
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	// Конфликт хотя бы одного URL с существующей ссылкой отменяет сохранение всего списка.
+	idx, err := r.index(ctx)
+	if err != nil {
+		return []uuid.UUID{}, err
+	}
+	if err := idx.checkConflicts(r.dedupScope, urls); err != nil {
+		return []uuid.UUID{}, err
+	}
+
 	writer, file, err := r.newWriter()
 	if err != nil {
-		r.Logger.Errorf("Error creating writer: %v", err)
+		r.Logger.With(ctx).Errorf("Error creating writer: %v", err)
 		return []uuid.UUID{}, err
 	}
 	defer file.Close()
 
 	var UUIDs []uuid.UUID
+	var URLRows []models.URLRow
 	var errs []error
 
+	now := time.Now().UTC()
 	for _, url := range urls {
 		UUID := uuid.New()
-		URLRowObject := models.URLRow{UUID: UUID, ShortURL: url.RandomPath, OriginalURL: url.URLStr}
+		URLRowObject := models.URLRow{UUID: UUID, ShortURL: url.RandomPath, OriginalURL: url.URLStr, UserID: url.UserID, CreatedAt: now, UpdatedAt: now}
 		UUIDs = append(UUIDs, URLRowObject.UUID)
+		URLRows = append(URLRows, URLRowObject)
 		data, err := json.Marshal(URLRowObject)
 		if err != nil {
-			r.Logger.Debugf("Cannot encode json: %s", err)
+			r.Logger.With(ctx).Debugf("Cannot encode json: %s", err)
 			errs = append(errs, err)
 			continue
 		}
 		_, err = writer.WriteString(string(data) + "\n")
 		if err != nil {
-			r.Logger.Debugf("Cannot write data: %s", err)
+			r.Logger.With(ctx).Debugf("Cannot write data: %s", err)
 			errs = append(errs, err)
 			continue
 		}
 		if err := writer.Flush(); err != nil {
-			r.Logger.Errorf("Error flushing writer: %v", err)
+			r.Logger.With(ctx).Errorf("Error flushing writer: %v", err)
 			errs = append(errs, err)
 			continue
 		}
 	}
 
 	if len(errs) > 0 {
+		// Часть строк могла не записаться, индекс перечитывается из файла при следующем сохранении.
+		r.store.index = nil
 		return UUIDs, errors.Join(errs...)
 	}
+	for _, urlRow := range URLRows {
+		idx.add(urlRow)
+	}
 
 	return UUIDs, nil
 }
 
 // BatchDelete помечает URL как удаленные для указанного пользователя в файле.
-func (r *FileURLRepository) BatchDelete(urls []string, userID uuid.UUID) error {
-	uuidMap := make(map[string]bool)
-	for _, shortURL := range urls {
-		uuidMap[shortURL] = true
-	}
+// Чужие URL не удаляются.
+func (r *FileURLRepository) BatchDelete(ctx context.Context, urls []string, userID uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "FileURLRepository.BatchDelete")
+	defer span.End()
 
-	var urlRows []models.URLRow
-	scanner, file, err := r.newScanner()
-	if err != nil {
-		r.Logger.Errorf("Error creating scanner: %v", err)
-		return err
-	}
-	defer file.Close()
-	for scanner.Scan() {
-		var urlRow models.URLRow
-		line := scanner.Text()
-		if err := json.Unmarshal([]byte(line), &urlRow); err != nil {
-			r.Logger.Debugf("Cannot decode line JSON: %s", err)
-			continue
-		}
+	return r.BatchDeleteByUsers(ctx, map[uuid.UUID][]string{userID: urls})
+}
 
-		if _, exists := uuidMap[urlRow.ShortURL]; exists {
-			urlRow.DeletedFlag = true
-		}
+// BatchDeleteByUsers помечает URL как удаленные сразу для нескольких пользователей
+// за одну перезапись файла.
+func (r *FileURLRepository) BatchDeleteByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	_, span := tracing.Start(ctx, "FileURLRepository.BatchDeleteByUsers")
+	defer span.End()
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
 
-		urlRows = append(urlRows, urlRow)
+	toDelete := make(map[uuid.UUID]map[string]bool, len(urlsByUser))
+	for userID, urls := range urlsByUser {
+		toDelete[userID] = make(map[string]bool, len(urls))
+		for _, shortURL := range urls {
+			toDelete[userID][shortURL] = true
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+	contents, err := r.readAll(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
 	}
-	writer, file, err := r.newWriter(true)
 	if err != nil {
-		r.Logger.Errorf("Error creating writer: %v", err)
 		return err
 	}
-	defer file.Close()
-
-	var errs []error
-
-	for _, urlRow := range urlRows {
-		data, err := json.Marshal(urlRow)
-		if err != nil {
-			r.Logger.Debugf("Cannot encode URLRow to JSON: %s", err)
-			errs = append(errs, err)
-			continue
-		}
-		if _, err := writer.WriteString(string(data) + "\n"); err != nil {
-			r.Logger.Debugf("Cannot write URLRow to file: %s", err)
-			errs = append(errs, err)
-			continue
+	now := time.Now().UTC()
+	for i, urlRow := range contents.urlRows {
+		if toDelete[urlRow.UserID][urlRow.ShortURL] {
+			contents.urlRows[i].MarkDeleted(now)
 		}
 	}
+	return r.rewrite(ctx, contents)
+}
 
-	if len(errs) > 0 {
-		return errors.Join(errs...)
+// index возвращает индекс файла, при первом обращении загружая его из файла.
+// Вызывается под блокировкой файла на запись. Отсутствующий файл считается пустым хранилищем.
+func (r *FileURLRepository) index(ctx context.Context) (*fileIndex, error) {
+	if r.store.index != nil {
+		return r.store.index, nil
+	}
+	contents, err := r.readAll(ctx)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
+	r.store.index = newFileIndex(contents.urlRows)
+	return r.store.index, nil
+}
 
-	if err := writer.Flush(); err != nil {
-		r.Logger.Debugf("Error flushing writer: %s", err)
+// BatchRestoreByUsers снимает пометку об удалении с URL нескольких пользователей
+// за одну перезапись файла.
+func (r *FileURLRepository) BatchRestoreByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	_, span := tracing.Start(ctx, "FileURLRepository.BatchRestoreByUsers")
+	defer span.End()
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	contents, err := r.readAll(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-
-	return nil
+	restoreURLRows(r.dedupScope, contents.urlRows, urlsByUser, time.Now().UTC())
+	return r.rewrite(ctx, contents)
 }
 
-func (r *FileUserRepository) newScanner() (*bufio.Scanner, *os.File, error) {
-	file, err := os.Open(r.filePath)
+// UpdateOriginalURL меняет исходный URL пользователя в файле, сохраняя прежний в истории строки.
+func (r *FileURLRepository) UpdateOriginalURL(ctx context.Context, shortURL string, userID uuid.UUID, originalURL string) (models.URLRow, error) {
+	_, span := tracing.Start(ctx, "FileURLRepository.UpdateOriginalURL")
+	defer span.End()
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	contents, err := r.readAll(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return models.URLRow{}, &apperrors.URLNotFound{ShortURL: shortURL}
+	}
 	if err != nil {
-		return nil, nil, err
+		return models.URLRow{}, err
 	}
-	return bufio.NewScanner(file), file, nil
+	urlRow, err := updateURLRows(r.dedupScope, contents.urlRows, shortURL, userID, originalURL, time.Now().UTC())
+	if err != nil {
+		return models.URLRow{}, err
+	}
+	if err := r.rewrite(ctx, contents); err != nil {
+		return models.URLRow{}, err
+	}
+	return urlRow, nil
 }
 
-func (r *FileUserRepository) newWriter(truncateModes ...bool) (*bufio.Writer, *os.File, error) {
-	truncateMode := false
-	if len(truncateModes) > 0 {
-		truncateMode = truncateModes[0]
-	}
+// PurgeDeleted окончательно стирает URL, удаленные раньше deletedBefore, проходом компактизации:
+// оставшиеся строки записываются во временный файл, который затем атомарно заменяет хранилище.
+// Файл перезаписывается целиком, поэтому все URL стираются за один проход независимо от batchSize.
+func (r *FileURLRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (int, error) {
+	_, span := tracing.Start(ctx, "FileURLRepository.PurgeDeleted")
+	defer span.End()
 
-	flags := os.O_WRONLY | os.O_CREATE
-	if truncateMode {
-		flags |= os.O_TRUNC
-	} else {
-		flags |= os.O_APPEND
-	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
 
-	file, err := os.OpenFile(r.filePath, flags, 0666)
+	contents, err := r.readAll(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
 	if err != nil {
-		return nil, nil, err
+		return 0, err
 	}
-	return bufio.NewWriter(file), file, nil
+	var purged int
+	contents.urlRows, purged = purgeURLRows(contents.urlRows, deletedBefore, math.MaxInt)
+	if purged == 0 {
+		return 0, nil
+	}
+	if err := r.rewrite(ctx, contents); err != nil {
+		return 0, err
+	}
+	return purged, nil
 }
 
-// UpdateUser обновляет пользователя для указанного URL.
-func (r *FileUserRepository) UpdateUser(savedURLUUID uuid.UUID, userID uuid.UUID) error {
-	var urlRows []models.URLRow
+// ForEachShortURL передает в fn короткие адреса всех URL в файле, включая удаленные.
+// Файл читается построчно, не загружаясь в память целиком.
+func (r *FileURLRepository) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	_, span := tracing.Start(ctx, "FileURLRepository.ForEachShortURL")
+	defer span.End()
+
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
 	scanner, file, err := r.newScanner()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		r.Logger.Errorf("Error creating scanner: %v", err)
 		return err
 	}
 	defer file.Close()
 	for scanner.Scan() {
-		var urlRow models.URLRow
-		line := scanner.Text()
-		if err := json.Unmarshal([]byte(line), &urlRow); err != nil {
-			r.Logger.Debugf("Cannot decode line JSON: %s", err)
+		urlRow, ok := decodeURLRow(ctx, scanner.Bytes(), r.Logger)
+		if !ok {
 			continue
 		}
+		fn(urlRow.ShortURL)
+	}
+	return scanner.Err()
+}
 
-		if urlRow.UUID == savedURLUUID {
-			urlRow.UUID = userID
+// WriteURLRowsFile атомарно заменяет файл строками URL в формате файлового хранилища.
+func WriteURLRowsFile(filePath string, urlRows []models.URLRow) error {
+	return writeFileAtomically(filePath, func(w io.Writer) error {
+		return writeURLRows(w, urlRows)
+	})
+}
+
+// writeFileContents атомарно заменяет файл содержимым хранилища; нераспознанные строки дописываются в конец.
+func writeFileContents(filePath string, contents fileContents) error {
+	return writeFileAtomically(filePath, func(w io.Writer) error {
+		if err := writeURLRows(w, contents.urlRows); err != nil {
+			return err
+		}
+		for _, line := range contents.malformed {
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+}
 
-		urlRows = append(urlRows, urlRow)
+// writeFileAtomically записывает файл через write во временный файл рядом с ним,
+// который затем переименовывается поверх, чтобы при сбое посреди записи файл остался прежним.
+func writeFileAtomically(filePath string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	if err := scanner.Err(); err != nil {
+	writer := bufio.NewWriter(tmp)
+	if err := write(writer); err != nil {
 		return err
 	}
-	writer, file, err := r.newWriter(true)
-	if err != nil {
-		r.Logger.Errorf("Error creating writer: %v", err)
+	if err := writer.Flush(); err != nil {
 		return err
 	}
-	defer file.Close()
-
-	var errs []error
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
 
+// writeURLRows записывает строки URL в формате файлового хранилища: по одной строке json на URL.
+func writeURLRows(w io.Writer, urlRows []models.URLRow) error {
 	for _, urlRow := range urlRows {
 		data, err := json.Marshal(urlRow)
 		if err != nil {
-			r.Logger.Debugf("Cannot encode URLRow to JSON: %s", err)
-			errs = append(errs, err)
-			continue
+			return err
 		}
-		if _, err := writer.WriteString(string(data) + "\n"); err != nil {
-			r.Logger.Debugf("Cannot write URLRow to file: %s", err)
-			errs = append(errs, err)
-			continue
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if len(errs) > 0 {
-		return errors.Join(errs...)
-	}
-
-	if err := writer.Flush(); err != nil {
-		r.Logger.Debugf("Error flushing writer: %s", err)
-		return err
-	}
+// readAll читает все строки из файла.
+func (r *FileURLRepository) readAll(ctx context.Context) (fileContents, error) {
+	return readURLRowsFile(ctx, r.filePath, r.Logger)
+}
 
-	return nil
+// rewrite атомарно перезаписывает файл переданным содержимым.
+func (r *FileURLRepository) rewrite(ctx context.Context, contents fileContents) error {
+	return rewriteURLRowsFile(ctx, r.store, r.filePath, contents, r.Logger)
 }
 
-// UpdateBatchUser обновляет пользователя для нескольких URL.
-func (r *FileUserRepository) UpdateBatchUser(savedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
-	uuidMap := make(map[uuid.UUID]bool)
-	for _, id := range savedURLUUIDs {
-		uuidMap[id] = true
+// decodeURLRow разбирает строку файла хранилища. Нераспознанная строка пропускается всеми операциями
+// чтения с предупреждением в логе и сохраняется при перезаписи файла.
+func decodeURLRow(ctx context.Context, line []byte, sugar *logger.Logger) (models.URLRow, bool) {
+	var urlRow models.URLRow
+	if err := json.Unmarshal(line, &urlRow); err != nil {
+		sugar.With(ctx).Warnf("Skipping malformed storage line: %s", err)
+		return models.URLRow{}, false
 	}
+	return urlRow, true
+}
 
-	var urlRows []models.URLRow
-	scanner, file, err := r.newScanner()
+// readURLRowsFile читает все строки URL из файла хранилища. Отсутствие файла возвращается
+// ошибкой os.ErrNotExist без записи в лог: вызывающий считает такое хранилище пустым.
+func readURLRowsFile(ctx context.Context, filePath string, sugar *logger.Logger) (fileContents, error) {
+	var contents fileContents
+	file, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return contents, err
+	}
 	if err != nil {
-		r.Logger.Errorf("Error creating scanner: %v", err)
-		return err
+		sugar.With(ctx).Errorf("Error creating scanner: %v", err)
+		return contents, err
 	}
 	defer file.Close()
+	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		var urlRow models.URLRow
-		line := scanner.Text()
-		if err := json.Unmarshal([]byte(line), &urlRow); err != nil {
-			r.Logger.Debugf("Cannot decode line JSON: %s", err)
+		urlRow, ok := decodeURLRow(ctx, scanner.Bytes(), sugar)
+		if !ok {
+			contents.malformed = append(contents.malformed, scanner.Text())
 			continue
 		}
-
-		if _, exists := uuidMap[urlRow.UUID]; exists {
-			urlRow.UserID = userID
-		}
-
-		urlRows = append(urlRows, urlRow)
+		contents.urlRows = append(contents.urlRows, urlRow)
 	}
+	return contents, scanner.Err()
+}
 
-	if err := scanner.Err(); err != nil {
+// rewriteURLRowsFile атомарно перезаписывает файл хранилища, чтобы сбой посреди записи не уничтожил его,
+// и перестраивает индекс по новому содержимому.
+func rewriteURLRowsFile(ctx context.Context, store *fileStore, filePath string, contents fileContents, sugar *logger.Logger) error {
+	if err := writeFileContents(filePath, contents); err != nil {
+		store.index = nil
+		sugar.With(ctx).Errorf("Error rewriting storage file: %v", err)
 		return err
 	}
-	writer, file, err := r.newWriter(true)
+	store.index = newFileIndex(contents.urlRows)
+	return nil
+}
+
+// UpdateUser обновляет пользователя для указанного URL.
+func (r *FileUserRepository) UpdateUser(ctx context.Context, savedURLUUID uuid.UUID, userID uuid.UUID) error {
+	_, span := tracing.Start(ctx, "FileUserRepository.UpdateUser")
+	defer span.End()
+
+	updated, err := r.updateUser(ctx, map[uuid.UUID]bool{savedURLUUID: true}, userID)
 	if err != nil {
-		r.Logger.Errorf("Error creating writer: %v", err)
 		return err
 	}
-	defer file.Close()
-
-	var errs []error
-
-	for _, urlRow := range urlRows {
-		data, err := json.Marshal(urlRow)
-		if err != nil {
-			r.Logger.Debugf("Cannot encode URLRow to JSON: %s", err)
-			errs = append(errs, err)
-			continue
-		}
-		if _, err := writer.WriteString(string(data) + "\n"); err != nil {
-			r.Logger.Debugf("Cannot write URLRow to file: %s", err)
-			errs = append(errs, err)
-			continue
-		}
+	if !updated {
+		return errors.New("URL не найден")
 	}
+	return nil
+}
 
-	if len(errs) > 0 {
-		return errors.Join(errs...)
-	}
+// UpdateBatchUser обновляет пользователя для нескольких URL.
+func (r *FileUserRepository) UpdateBatchUser(ctx context.Context, savedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
+	_, span := tracing.Start(ctx, "FileUserRepository.UpdateBatchUser")
+	defer span.End()
 
-	if err := writer.Flush(); err != nil {
-		r.Logger.Debugf("Error flushing writer: %s", err)
+	uuidMap := make(map[uuid.UUID]bool)
+	for _, id := range savedURLUUIDs {
+		uuidMap[id] = true
+	}
+	updated, err := r.updateUser(ctx, uuidMap, userID)
+	if err != nil {
 		return err
 	}
-
+	if !updated {
+		return errors.New("URL для обновления не найдены")
+	}
 	return nil
 }
 
+// updateUser привязывает URL с UUID из uuidMap к пользователю и атомарно перезаписывает файл.
+// Возвращает false, если ни один URL не найден; файл в этом случае не меняется.
+func (r *FileUserRepository) updateUser(ctx context.Context, uuidMap map[uuid.UUID]bool, userID uuid.UUID) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	contents, err := readURLRowsFile(ctx, r.filePath, r.Logger)
+	if err != nil {
+		return false, err
+	}
+	updated := false
+	now := time.Now().UTC()
+	for i := range contents.urlRows {
+		if uuidMap[contents.urlRows[i].UUID] {
+			contents.urlRows[i].UserID = userID
+			contents.urlRows[i].UpdatedAt = now
+			updated = true
+		}
+	}
+	if !updated {
+		return false, nil
+	}
+	return true, rewriteURLRowsFile(ctx, r.store, r.filePath, contents, r.Logger)
+}
+
 // NewFileURLRepository создает новый экземпляр репозитория URL, хранящегося в файле.
 func NewFileURLRepository(serverConfig config.Config, sugar *logger.Logger) (*FileURLRepository, error) {
-	return &FileURLRepository{filePath: serverConfig.FileStoragePath, Logger: sugar}, nil
+	dedupScope, err := parseDedupScope(serverConfig.DedupScope)
+	if err != nil {
+		return nil, err
+	}
+	return &FileURLRepository{
+		filePath:   serverConfig.FileStoragePath,
+		store:      storeFor(serverConfig.FileStoragePath),
+		dedupScope: dedupScope,
+		Logger:     sugar,
+	}, nil
 }
 
 // NewFileUserRepository создает новый экземпляр репозитория пользователей, хранящегося в файле.
 func NewFileUserRepository(serverConfig config.Config, sugar *logger.Logger) (*FileUserRepository, error) {
-	return &FileUserRepository{filePath: serverConfig.FileStoragePath, Logger: sugar}, nil
+	return &FileUserRepository{
+		filePath: serverConfig.FileStoragePath,
+		store:    storeFor(serverConfig.FileStoragePath),
+		Logger:   sugar,
+	}, nil
 }
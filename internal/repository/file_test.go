@@ -0,0 +1,130 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+)
+
+// TestFileRepository_RewritesAtomically проверяет, что изменения существующих строк не перезаписывают
+// файл на месте, а атомарно заменяют его новым, не оставляя временных файлов.
+func TestFileRepository_RewritesAtomically(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cfg := config.Config{FileStoragePath: filepath.Join(dir, "storage.json")}
+	urls, err := repository.NewFileURLRepository(cfg, logger.GetLogger())
+	require.NoError(t, err)
+	users, err := repository.NewFileUserRepository(cfg, logger.GetLogger())
+	require.NoError(t, err)
+
+	userID := uuid.New()
+	UUIDs, err := urls.BatchSave(ctx, []models.URLToSave{
+		{RandomPath: "aaaaaaaa", URLStr: "https://ya.ru"},
+		{RandomPath: "bbbbbbbb", URLStr: "https://practicum.yandex.ru"},
+	})
+	require.NoError(t, err)
+
+	rewrites := []struct {
+		name string
+		run  func() error
+	}{
+		{name: "update user", run: func() error { return users.UpdateUser(ctx, UUIDs[0], userID) }},
+		{name: "update batch user", run: func() error { return users.UpdateBatchUser(ctx, UUIDs, userID) }},
+		{name: "update original url", run: func() error {
+			_, err := urls.UpdateOriginalURL(ctx, "aaaaaaaa", userID, "https://yandex.ru")
+			return err
+		}},
+		{name: "batch delete", run: func() error { return urls.BatchDelete(ctx, []string{"bbbbbbbb"}, userID) }},
+	}
+	for _, rewrite := range rewrites {
+		before, err := os.Stat(cfg.FileStoragePath)
+		require.NoError(t, err)
+		require.NoError(t, rewrite.run(), rewrite.name)
+		after, err := os.Stat(cfg.FileStoragePath)
+		require.NoError(t, err)
+		assert.False(t, os.SameFile(before, after), "%s: файл перезаписан на месте", rewrite.name)
+	}
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "Временные файлы должны удаляться")
+
+	row, found, err := urls.Find(ctx, "aaaaaaaa")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, userID, row.UserID)
+	assert.Equal(t, "https://yandex.ru", row.OriginalURL)
+	row, _, err = urls.Find(ctx, "bbbbbbbb")
+	require.NoError(t, err)
+	assert.True(t, row.DeletedFlag)
+}
+
+// TestFileRepository_KeepsMalformedLines проверяет, что нераспознанные строки файла пропускаются
+// всеми операциями чтения и не теряются при перезаписи файла.
+func TestFileRepository_KeepsMalformedLines(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{FileStoragePath: filepath.Join(t.TempDir(), "storage.json")}
+	userID := uuid.New()
+	require.NoError(t, repository.WriteURLRowsFile(cfg.FileStoragePath, []models.URLRow{
+		{UUID: uuid.New(), ShortURL: "aaaaaaaa", OriginalURL: "https://ya.ru", UserID: userID},
+	}))
+	file, err := os.OpenFile(cfg.FileStoragePath, os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = file.WriteString("{\"uuid\": \"broken\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	urls, err := repository.NewFileURLRepository(cfg, logger.GetLogger())
+	require.NoError(t, err)
+
+	_, found, err := urls.Find(ctx, "bbbbbbbb")
+	require.NoError(t, err)
+	assert.False(t, found)
+	_, err = urls.Save(ctx, models.URLToSave{RandomPath: "bbbbbbbb", URLStr: "https://practicum.yandex.ru", UserID: userID})
+	require.NoError(t, err)
+	_, found, err = urls.Find(ctx, "bbbbbbbb")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	require.NoError(t, urls.BatchDelete(ctx, []string{"aaaaaaaa"}, userID))
+	data, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "{\"uuid\": \"broken\n", "Нераспознанная строка не должна теряться при перезаписи")
+}
+
+// TestFileRepository_IndexFollowsRewrites проверяет, что проверка конфликтов видит изменения,
+// сделанные перезаписью файла другим репозиторием.
+func TestFileRepository_IndexFollowsRewrites(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{FileStoragePath: filepath.Join(t.TempDir(), "storage.json"), DedupScope: repository.DedupScopeUser}
+	urls, err := repository.NewFileURLRepository(cfg, logger.GetLogger())
+	require.NoError(t, err)
+	users, err := repository.NewFileUserRepository(cfg, logger.GetLogger())
+	require.NoError(t, err)
+
+	savedUUID, err := urls.Save(ctx, models.URLToSave{RandomPath: "aaaaaaaa", URLStr: "https://ya.ru"})
+	require.NoError(t, err)
+	userID := uuid.New()
+	_, err = urls.Save(ctx, models.URLToSave{RandomPath: "bbbbbbbb", URLStr: "https://ya.ru", UserID: userID})
+	require.NoError(t, err)
+	require.NoError(t, urls.BatchDelete(ctx, []string{"bbbbbbbb"}, userID))
+
+	// После привязки анонимного URL к пользователю его повторное сокращение этим пользователем - конфликт.
+	require.NoError(t, users.UpdateUser(ctx, savedUUID, userID))
+	_, err = urls.Save(ctx, models.URLToSave{RandomPath: "cccccccc", URLStr: "https://ya.ru", UserID: userID})
+	var conflictErr *apperrors.OriginalURLAlreadyExists
+	assert.ErrorAs(t, err, &conflictErr)
+	_, err = urls.Save(ctx, models.URLToSave{RandomPath: "aaaaaaaa", URLStr: "https://practicum.yandex.ru", UserID: userID})
+	var shortErr *apperrors.ShortURLAlreadyExists
+	assert.ErrorAs(t, err, &shortErr)
+}
@@ -1,16 +1,20 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
 )
 
 // MemoryURLRepository представляет репозиторий URL, хранящийся в памяти.
 type MemoryURLRepository struct {
 	SharedURLRows *models.SharedURLRows // Общий ресурс для хранения URL.
+	DedupScope    string                // Область дедупликации оригинальных URL.
 }
 
 // MemoryUserRepository представляет репозиторий пользователей, хранящийся в памяти.
@@ -19,62 +23,93 @@ type MemoryUserRepository struct {
 }
 
 // Save сохраняет новый URL в памяти.
-func (r *MemoryURLRepository) Save(url models.URLToSave) (uuid.UUID, error) {
+func (r *MemoryURLRepository) Save(ctx context.Context, url models.URLToSave) (uuid.UUID, error) {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.Save")
+	defer span.End()
+
 	UUID := uuid.New()
+	now := time.Now().UTC()
 	newURLRow := models.URLRow{
 		UUID:        UUID,
 		ShortURL:    url.RandomPath,
 		OriginalURL: url.URLStr,
 		DeletedFlag: false,
+		UserID:      url.UserID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
 	}
 
 	r.SharedURLRows.Mu.Lock()
+	defer r.SharedURLRows.Mu.Unlock()
+
+	if err := checkBatchConflicts(r.DedupScope, r.SharedURLRows.URLRows, []models.URLToSave{url}); err != nil {
+		return uuid.UUID{}, err
+	}
 	r.SharedURLRows.URLRows = append(r.SharedURLRows.URLRows, newURLRow)
-	r.SharedURLRows.Mu.Unlock()
 
 	return UUID, nil
 }
 
 // BatchSave сохраняет несколько URL в памяти.
-func (r *MemoryURLRepository) BatchSave(urls []models.URLToSave) ([]uuid.UUID, error) {
+func (r *MemoryURLRepository) BatchSave(ctx context.Context, urls []models.URLToSave) ([]uuid.UUID, error) {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.BatchSave")
+	defer span.End()
+
 	var UUIDs []uuid.UUID
 
+	now := time.Now().UTC()
 	r.SharedURLRows.Mu.Lock()
+	defer r.SharedURLRows.Mu.Unlock()
+
+	// Конфликт хотя бы одного URL с существующей ссылкой или другим URL списка отменяет сохранение всего списка.
+	if err := checkBatchConflicts(r.DedupScope, r.SharedURLRows.URLRows, urls); err != nil {
+		return nil, err
+	}
+
 	for _, url := range urls {
 		UUID := uuid.New()
 		newURLRow := models.URLRow{
 			UUID:        UUID,
 			ShortURL:    url.RandomPath,
 			OriginalURL: url.URLStr,
+			UserID:      url.UserID,
+			CreatedAt:   now,
+			UpdatedAt:   now,
 		}
 		UUIDs = append(UUIDs, UUID)
 		r.SharedURLRows.URLRows = append(r.SharedURLRows.URLRows, newURLRow)
 	}
-	r.SharedURLRows.Mu.Unlock()
 
 	return UUIDs, nil
 }
 
 // Find ищет URL по сокращенному адресу в памяти.
-func (r *MemoryURLRepository) Find(shortURL string) (models.URLRow, bool) {
+func (r *MemoryURLRepository) Find(ctx context.Context, shortURL string) (models.URLRow, bool, error) {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.Find")
+	defer span.End()
+
 	r.SharedURLRows.Mu.Lock()
 	defer r.SharedURLRows.Mu.Unlock()
 
 	for _, urlRow := range r.SharedURLRows.URLRows {
 		if urlRow.ShortURL == shortURL {
-			return urlRow, true
+			return urlRow, true, nil
 		}
 	}
-	return models.URLRow{}, false
+	return models.URLRow{}, false, nil
 }
 
-// FindByOriginalURL ищет сокращенный URL по оригинальному адресу в памяти.
-func (r *MemoryURLRepository) FindByOriginalURL(originalURL string) (string, bool) {
+// FindByOriginalURL ищет сокращенный URL по оригинальному адресу среди неудаленных URL в памяти.
+// В области дедупликации user и none поиск ведется только среди URL пользователя.
+func (r *MemoryURLRepository) FindByOriginalURL(ctx context.Context, originalURL string, userID uuid.UUID) (string, bool) {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.FindByOriginalURL")
+	defer span.End()
+
 	r.SharedURLRows.Mu.Lock()
 	defer r.SharedURLRows.Mu.Unlock()
 
 	for _, urlRow := range r.SharedURLRows.URLRows {
-		if urlRow.OriginalURL == originalURL {
+		if dedupMatches(r.DedupScope, urlRow, originalURL, userID) {
 			return urlRow.ShortURL, true
 		}
 	}
@@ -82,7 +117,11 @@ func (r *MemoryURLRepository) FindByOriginalURL(originalURL string) (string, boo
 }
 
 // FindByUserID ищет все URL, принадлежащие пользователю, в памяти.
-func (r *MemoryURLRepository) FindByUserID(userID uuid.UUID) ([]models.URLRow, bool) {
+// Пустой список - успешный результат.
+func (r *MemoryURLRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.URLRow, error) {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.FindByUserID")
+	defer span.End()
+
 	r.SharedURLRows.Mu.Lock()
 	defer r.SharedURLRows.Mu.Unlock()
 
@@ -92,11 +131,31 @@ func (r *MemoryURLRepository) FindByUserID(userID uuid.UUID) ([]models.URLRow, b
 			matchedURLs = append(matchedURLs, urlRow)
 		}
 	}
-	return matchedURLs, len(matchedURLs) > 0
+	return matchedURLs, nil
+}
+
+// FindByUserIDWithOptions выбирает страницу URL пользователя в памяти с сортировкой и фильтрами.
+func (r *MemoryURLRepository) FindByUserIDWithOptions(ctx context.Context, userID uuid.UUID, opts models.URLQueryOptions) ([]models.URLRow, string, error) {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.FindByUserIDWithOptions")
+	defer span.End()
+
+	r.SharedURLRows.Mu.Lock()
+	defer r.SharedURLRows.Mu.Unlock()
+
+	var userURLs []models.URLRow
+	for _, urlRow := range r.SharedURLRows.URLRows {
+		if urlRow.UserID == userID {
+			userURLs = append(userURLs, urlRow)
+		}
+	}
+	return queryURLRows(userURLs, opts)
 }
 
 // BatchDelete помечает URL как удаленные для указанного пользователя в памяти.
-func (r *MemoryURLRepository) BatchDelete(urls []string, userID uuid.UUID) error {
+func (r *MemoryURLRepository) BatchDelete(ctx context.Context, urls []string, userID uuid.UUID) error {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.BatchDelete")
+	defer span.End()
+
 	r.SharedURLRows.Mu.Lock()
 	defer r.SharedURLRows.Mu.Unlock()
 
@@ -105,23 +164,115 @@ func (r *MemoryURLRepository) BatchDelete(urls []string, userID uuid.UUID) error
 		uuidMap[shortURL] = true
 	}
 
+	now := time.Now().UTC()
 	for i, urlRow := range r.SharedURLRows.URLRows {
 		if _, exists := uuidMap[urlRow.ShortURL]; exists && urlRow.UserID == userID {
-			r.SharedURLRows.URLRows[i].DeletedFlag = true
+			r.SharedURLRows.URLRows[i].MarkDeleted(now)
 		}
 	}
 
 	return nil
 }
 
+// BatchDeleteByUsers помечает URL как удаленные сразу для нескольких пользователей в памяти.
+func (r *MemoryURLRepository) BatchDeleteByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.BatchDeleteByUsers")
+	defer span.End()
+
+	r.SharedURLRows.Mu.Lock()
+	defer r.SharedURLRows.Mu.Unlock()
+
+	toDelete := make(map[uuid.UUID]map[string]bool, len(urlsByUser))
+	for userID, urls := range urlsByUser {
+		toDelete[userID] = make(map[string]bool, len(urls))
+		for _, shortURL := range urls {
+			toDelete[userID][shortURL] = true
+		}
+	}
+
+	now := time.Now().UTC()
+	for i, urlRow := range r.SharedURLRows.URLRows {
+		if toDelete[urlRow.UserID][urlRow.ShortURL] {
+			r.SharedURLRows.URLRows[i].MarkDeleted(now)
+		}
+	}
+
+	return nil
+}
+
+// BatchRestoreByUsers снимает пометку об удалении с URL нескольких пользователей в памяти.
+func (r *MemoryURLRepository) BatchRestoreByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.BatchRestoreByUsers")
+	defer span.End()
+
+	r.SharedURLRows.Mu.Lock()
+	defer r.SharedURLRows.Mu.Unlock()
+
+	restoreURLRows(r.DedupScope, r.SharedURLRows.URLRows, urlsByUser, time.Now().UTC())
+	return nil
+}
+
+// UpdateOriginalURL меняет исходный URL пользователя в памяти, сохраняя прежний в истории.
+func (r *MemoryURLRepository) UpdateOriginalURL(ctx context.Context, shortURL string, userID uuid.UUID, originalURL string) (models.URLRow, error) {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.UpdateOriginalURL")
+	defer span.End()
+
+	r.SharedURLRows.Mu.Lock()
+	defer r.SharedURLRows.Mu.Unlock()
+
+	return updateURLRows(r.DedupScope, r.SharedURLRows.URLRows, shortURL, userID, originalURL, time.Now().UTC())
+}
+
+// PurgeDeleted окончательно стирает из памяти URL, удаленные раньше deletedBefore.
+// Между пачками по batchSize строк блокировка хранилища отпускается, чтобы не задерживать запросы.
+func (r *MemoryURLRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (int, error) {
+	ctx, span := tracing.Start(ctx, "MemoryURLRepository.PurgeDeleted")
+	defer span.End()
+
+	batchSize = purgeBatchSize(batchSize)
+	total := 0
+	for {
+		r.SharedURLRows.Mu.Lock()
+		var purged int
+		r.SharedURLRows.URLRows, purged = purgeURLRows(r.SharedURLRows.URLRows, deletedBefore, batchSize)
+		r.SharedURLRows.Mu.Unlock()
+
+		total += purged
+		if purged < batchSize {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// ForEachShortURL передает в fn короткие адреса всех URL в памяти, включая удаленные.
+func (r *MemoryURLRepository) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	_, span := tracing.Start(ctx, "MemoryURLRepository.ForEachShortURL")
+	defer span.End()
+
+	r.SharedURLRows.Mu.Lock()
+	defer r.SharedURLRows.Mu.Unlock()
+
+	for _, urlRow := range r.SharedURLRows.URLRows {
+		fn(urlRow.ShortURL)
+	}
+	return nil
+}
+
 // UpdateUser обновляет пользователя для указанного URL в памяти.
-func (r *MemoryUserRepository) UpdateUser(SavedURLUUID uuid.UUID, userID uuid.UUID) error {
+func (r *MemoryUserRepository) UpdateUser(ctx context.Context, SavedURLUUID uuid.UUID, userID uuid.UUID) error {
+	_, span := tracing.Start(ctx, "MemoryUserRepository.UpdateUser")
+	defer span.End()
+
 	r.SharedURLRows.Mu.Lock()
 	defer r.SharedURLRows.Mu.Unlock()
 
 	for i, urlRow := range r.SharedURLRows.URLRows {
 		if urlRow.UUID == SavedURLUUID {
 			r.SharedURLRows.URLRows[i].UserID = userID
+			r.SharedURLRows.URLRows[i].UpdatedAt = time.Now().UTC()
 			return nil
 		}
 	}
@@ -129,7 +280,10 @@ func (r *MemoryUserRepository) UpdateUser(SavedURLUUID uuid.UUID, userID uuid.UU
 }
 
 // UpdateBatchUser обновляет пользователя для нескольких URL в памяти.
-func (r *MemoryUserRepository) UpdateBatchUser(SavedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
+func (r *MemoryUserRepository) UpdateBatchUser(ctx context.Context, SavedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
+	_, span := tracing.Start(ctx, "MemoryUserRepository.UpdateBatchUser")
+	defer span.End()
+
 	r.SharedURLRows.Mu.Lock()
 	defer r.SharedURLRows.Mu.Unlock()
 
@@ -139,9 +293,11 @@ func (r *MemoryUserRepository) UpdateBatchUser(SavedURLUUIDs []uuid.UUID, userID
 	}
 
 	updated := false
+	now := time.Now().UTC()
 	for i, urlRow := range r.SharedURLRows.URLRows {
 		if _, exists := uuidMap[urlRow.UUID]; exists {
 			r.SharedURLRows.URLRows[i].UserID = userID
+			r.SharedURLRows.URLRows[i].UpdatedAt = now
 			updated = true
 		}
 	}
@@ -153,8 +309,12 @@ func (r *MemoryUserRepository) UpdateBatchUser(SavedURLUUIDs []uuid.UUID, userID
 }
 
 // NewMemoryURLRepository создает новый экземпляр репозитория URL, хранящегося в памяти.
-func NewMemoryURLRepository(sharedURLRows *models.SharedURLRows) (*MemoryURLRepository, error) {
-	return &MemoryURLRepository{SharedURLRows: sharedURLRows}, nil
+func NewMemoryURLRepository(sharedURLRows *models.SharedURLRows, dedupScope string) (*MemoryURLRepository, error) {
+	scope, err := parseDedupScope(dedupScope)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryURLRepository{SharedURLRows: sharedURLRows, DedupScope: scope}, nil
 }
 
 // NewMemoryUserRepository создает новый экземпляр репозитория пользователей, хранящегося в памяти.
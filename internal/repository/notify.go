@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// URLEventsChannel канал NOTIFY, в который DBURLRepository публикует изменения URL.
+const URLEventsChannel = "shortener_url_events"
+
+// maxNotifyPayload максимальный размер события в байтах: Postgres не принимает сообщения NOTIFY
+// длиннее 8000 байт, поэтому длинные списки адресов делятся на несколько событий.
+const maxNotifyPayload = 7000
+
+// execer выполняет запрос без результата; его реализуют пул, соединение и транзакция pgx.
+type execer interface {
+	Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error)
+}
+
+// notifyURLEvent публикует событие об изменении URL с адресами shortURLs.
+// Внутри транзакции событие доставляется подписчикам только после ее фиксации.
+func notifyURLEvent(ctx context.Context, e execer, op string, shortURLs []string) error {
+	for _, event := range splitURLEvent(models.URLEvent{Op: op, ShortURLs: shortURLs}) {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := e.Exec(ctx, "SELECT pg_notify($1, $2)", URLEventsChannel, string(payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitURLEvent делит событие на части, каждая из которых помещается в сообщение NOTIFY.
+// Событие без адресов не публикуется.
+func splitURLEvent(event models.URLEvent) []models.URLEvent {
+	var events []models.URLEvent
+	var size int
+	for _, shortURL := range event.ShortURLs {
+		// Адрес занимает в json свою длину, две кавычки и запятую.
+		if len(events) == 0 || size+len(shortURL)+3 > maxNotifyPayload {
+			events = append(events, models.URLEvent{Op: event.Op})
+			size = 0
+		}
+		last := &events[len(events)-1]
+		last.ShortURLs = append(last.ShortURLs, shortURL)
+		size += len(shortURL) + 3
+	}
+	return events
+}
+
+// ListenURLEvents подключается к БД отдельным соединением, подписывается на канал URLEventsChannel,
+// вызывает onListen и затем передает события в fn, пока соединение не оборвется или контекст не будет отменен.
+// Событие, которое не удалось разобрать, передается как URLEventFlush.
+func ListenURLEvents(ctx context.Context, dsn string, onListen func(), fn func(event models.URLEvent)) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, "LISTEN "+URLEventsChannel); err != nil {
+		return err
+	}
+	onListen()
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var event models.URLEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			event = models.URLEvent{Op: models.URLEventFlush}
+		}
+		fn(event)
+	}
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/db"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+func TestSplitURLEvent(t *testing.T) {
+	assert.Empty(t, splitURLEvent(models.URLEvent{Op: models.URLEventChanged}))
+
+	var shortURLs []string
+	for i := 0; i < 2000; i++ {
+		shortURLs = append(shortURLs, fmt.Sprintf("%08d", i))
+	}
+	events := splitURLEvent(models.URLEvent{Op: models.URLEventChanged, ShortURLs: shortURLs})
+	require.Greater(t, len(events), 1)
+
+	var joined []string
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		require.NoError(t, err)
+		assert.Less(t, len(payload), 8000, "Событие не должно превышать ограничение NOTIFY")
+		assert.Equal(t, models.URLEventChanged, event.Op)
+		joined = append(joined, event.ShortURLs...)
+	}
+	assert.Equal(t, shortURLs, joined)
+}
+
+// TestDBURLRepository_PublishesURLEvents проверяет доставку событий другим подключениям.
+// Выполняется, только если задан DATABASE_DSN.
+func TestDBURLRepository_PublishesURLEvents(t *testing.T) {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("DATABASE_DSN is not set")
+	}
+	pool, err := db.InitPool(context.Background(), dsn, db.MigrationsModeAuto, db.PoolOptions{}, logger.GetLogger())
+	require.NoError(t, err)
+	defer pool.Close()
+	repo, err := NewDBURLRepository(pool, DedupScopeNone)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listening := make(chan struct{})
+	events := make(chan models.URLEvent, 10)
+	go ListenURLEvents(ctx, dsn, func() { close(listening) }, func(event models.URLEvent) { events <- event })
+	<-listening
+
+	userID := uuid.New()
+	shortURL := uuid.NewString()[:8]
+	_, err = repo.Save(ctx, models.URLToSave{RandomPath: shortURL, URLStr: "http://practicum.yandex.ru/", UserID: userID})
+	require.NoError(t, err)
+	require.NoError(t, repo.BatchDelete(ctx, []string{shortURL}, userID))
+
+	assert.Equal(t, models.URLEvent{Op: models.URLEventSaved, ShortURLs: []string{shortURL}}, <-events)
+	assert.Equal(t, models.URLEvent{Op: models.URLEventChanged, ShortURLs: []string{shortURL}}, <-events)
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"math"
+	"time"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// purgeLockKey ключ advisory-блокировки Postgres, под которой выполняется окончательное удаление,
+// чтобы при нескольких экземплярах сервиса его выполнял только один.
+const purgeLockKey int64 = 0x73686f7274656e72 // "shortenr"
+
+// isPurgeable проверяет, что URL удален раньше deletedBefore и его можно стереть окончательно.
+// URL без времени удаления (сохраненные до его появления) не стираются.
+func isPurgeable(urlRow models.URLRow, deletedBefore time.Time) bool {
+	return urlRow.DeletedFlag && urlRow.DeletedAt != nil && urlRow.DeletedAt.Before(deletedBefore)
+}
+
+// purgeBatchSize возвращает размер пачки окончательного удаления; batchSize <= 0 - все URL одной пачкой.
+func purgeBatchSize(batchSize int) int {
+	if batchSize <= 0 {
+		return math.MaxInt
+	}
+	return batchSize
+}
+
+// purgeURLRows возвращает строки без окончательно удаляемых URL, но не больше limit из них,
+// и количество удаленных. Исходный срез переиспользуется.
+func purgeURLRows(urlRows []models.URLRow, deletedBefore time.Time, limit int) ([]models.URLRow, int) {
+	kept := urlRows[:0]
+	purged := 0
+	for _, urlRow := range urlRows {
+		if purged < limit && isPurgeable(urlRow, deletedBefore) {
+			purged++
+			continue
+		}
+		kept = append(kept, urlRow)
+	}
+	return kept, purged
+}
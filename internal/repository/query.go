@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// pageCursor положение последнего URL страницы в выбранном порядке сортировки.
+// Вместе с ключом хранится сам порядок, чтобы курсор нельзя было применить к другой сортировке.
+type pageCursor struct {
+	SortBy     string    `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Key        string    `json:"k"`
+	UUID       uuid.UUID `json:"u"`
+}
+
+// normalizeQueryOptions проверяет параметры выборки, подставляет сортировку по умолчанию
+// и раскодирует курсор, если он передан.
+func normalizeQueryOptions(opts models.URLQueryOptions) (models.URLQueryOptions, *pageCursor, error) {
+	switch opts.SortBy {
+	case "":
+		opts.SortBy = models.SortByCreatedAt
+	case models.SortByCreatedAt, models.SortByOriginalURL:
+	default:
+		return opts, nil, &apperrors.InvalidQueryOptions{Reason: "unknown sort field " + opts.SortBy}
+	}
+	if opts.Limit < 0 {
+		return opts, nil, &apperrors.InvalidQueryOptions{Reason: "limit must not be negative"}
+	}
+	if opts.Cursor == "" {
+		return opts, nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return opts, nil, &apperrors.InvalidQueryOptions{Reason: "malformed cursor"}
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return opts, nil, &apperrors.InvalidQueryOptions{Reason: "malformed cursor"}
+	}
+	if cursor.SortBy != opts.SortBy || cursor.Descending != opts.Descending {
+		return opts, nil, &apperrors.InvalidQueryOptions{Reason: "cursor belongs to another sort order"}
+	}
+	if cursor.SortBy == models.SortByCreatedAt {
+		if _, err := time.Parse(time.RFC3339Nano, cursor.Key); err != nil {
+			return opts, nil, &apperrors.InvalidQueryOptions{Reason: "malformed cursor"}
+		}
+	}
+	return opts, &cursor, nil
+}
+
+// encodeCursor возвращает курсор, указывающий на строку urlRow в порядке сортировки opts.
+func encodeCursor(opts models.URLQueryOptions, urlRow models.URLRow) string {
+	cursor := pageCursor{SortBy: opts.SortBy, Descending: opts.Descending, UUID: urlRow.UUID}
+	if opts.SortBy == models.SortByOriginalURL {
+		cursor.Key = urlRow.OriginalURL
+	} else {
+		cursor.Key = urlRow.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// row возвращает строку с ключом сортировки курсора для сравнения с другими строками.
+func (c pageCursor) row() models.URLRow {
+	urlRow := models.URLRow{UUID: c.UUID}
+	if c.SortBy == models.SortByOriginalURL {
+		urlRow.OriginalURL = c.Key
+	} else {
+		urlRow.CreatedAt, _ = time.Parse(time.RFC3339Nano, c.Key)
+	}
+	return urlRow
+}
+
+// compareURLRows сравнивает строки по полю сортировки, а при равенстве - по UUID,
+// так же как Postgres сравнивает их при keyset-пагинации.
+func compareURLRows(sortBy string, a, b models.URLRow) int {
+	var c int
+	if sortBy == models.SortByOriginalURL {
+		c = strings.Compare(a.OriginalURL, b.OriginalURL)
+	} else {
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = bytes.Compare(a.UUID[:], b.UUID[:])
+	}
+	return c
+}
+
+// matchesQueryOptions проверяет, что строка проходит фильтры выборки.
+func matchesQueryOptions(opts models.URLQueryOptions, urlRow models.URLRow) bool {
+	if opts.Search != "" && !strings.Contains(urlRow.OriginalURL, opts.Search) {
+		return false
+	}
+	if opts.Deleted != nil && urlRow.DeletedFlag != *opts.Deleted {
+		return false
+	}
+	if opts.CreatedAfter != nil && urlRow.CreatedAt.Before(*opts.CreatedAfter) {
+		return false
+	}
+	if opts.CreatedBefore != nil && !urlRow.CreatedAt.Before(*opts.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// queryURLRows выбирает из строк пользователя страницу по параметрам выборки
+// и возвращает ее вместе с курсором следующей страницы (пустым, если страница последняя).
+func queryURLRows(urlRows []models.URLRow, opts models.URLQueryOptions) ([]models.URLRow, string, error) {
+	opts, cursor, err := normalizeQueryOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	direction := 1
+	if opts.Descending {
+		direction = -1
+	}
+	var page []models.URLRow
+	for _, urlRow := range urlRows {
+		if !matchesQueryOptions(opts, urlRow) {
+			continue
+		}
+		if cursor != nil && compareURLRows(opts.SortBy, urlRow, cursor.row())*direction <= 0 {
+			continue
+		}
+		page = append(page, urlRow)
+	}
+	sort.Slice(page, func(i, j int) bool {
+		return compareURLRows(opts.SortBy, page[i], page[j])*direction < 0
+	})
+
+	if opts.Limit == 0 || len(page) <= opts.Limit {
+		return page, "", nil
+	}
+	page = page[:opts.Limit]
+	return page, encodeCursor(opts, page[len(page)-1]), nil
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/db"
+)
+
+// ReadRouter выбирает пул для чтения: исправную реплику или основную БД.
+// Сразу после записи пользователь и измененные короткие адреса читаются с основной БД,
+// пока реплики могут еще не получить изменения.
+type ReadRouter struct {
+	primary        *pgxpool.Pool // Основная БД, на которую идут все записи.
+	replicas       *db.Replicas  // Реплики для чтения; nil - реплик нет.
+	readAfterWrite time.Duration // Сколько после записи читать с основной БД (0 - не отслеживать записи).
+
+	mu        sync.Mutex
+	users     map[uuid.UUID]time.Time // Время, до которого пользователь читает с основной БД.
+	shortURLs map[string]time.Time    // Время, до которого короткий адрес читается с основной БД.
+	nextSweep time.Time               // Время следующей очистки устаревших отметок.
+	now       func() time.Time
+}
+
+// primaryOnly возвращает ReadRouter без реплик: все чтение идет с основной БД.
+func primaryOnly(primary *pgxpool.Pool) *ReadRouter {
+	return NewReadRouter(primary, nil, 0)
+}
+
+// forUser возвращает пул для чтения данных пользователя.
+func (r *ReadRouter) forUser(userID uuid.UUID) *pgxpool.Pool {
+	if r.replicas == nil || r.recentlyWrote(func() time.Time { return r.users[userID] }) {
+		return r.primary
+	}
+	return r.replica()
+}
+
+// forShortURL возвращает пул для чтения URL по короткому адресу.
+func (r *ReadRouter) forShortURL(shortURL string) *pgxpool.Pool {
+	if r.replicas == nil || r.recentlyWrote(func() time.Time { return r.shortURLs[shortURL] }) {
+		return r.primary
+	}
+	return r.replica()
+}
+
+// replica возвращает исправную реплику или основную БД, если исправных реплик нет.
+func (r *ReadRouter) replica() *pgxpool.Pool {
+	if pool := r.replicas.Pool(); pool != nil {
+		return pool
+	}
+	return r.primary
+}
+
+// recentlyWrote сообщает, что отметка о записи, которую под блокировкой читает until, еще не устарела.
+func (r *ReadRouter) recentlyWrote(until func() time.Time) bool {
+	if r.readAfterWrite <= 0 {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return until().After(r.now())
+}
+
+// wrote отмечает, что пользователь userID изменил URL с адресами shortURLs.
+// uuid.Nil вместо пользователя означает, что отмечать пользователя не нужно.
+func (r *ReadRouter) wrote(userID uuid.UUID, shortURLs ...string) {
+	if r.replicas == nil || r.readAfterWrite <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	until := now.Add(r.readAfterWrite)
+	if userID != uuid.Nil {
+		r.users[userID] = until
+	}
+	for _, shortURL := range shortURLs {
+		r.shortURLs[shortURL] = until
+	}
+	if now.After(r.nextSweep) {
+		r.sweep(now)
+	}
+}
+
+// sweep удаляет устаревшие отметки о записи. Вызывается под блокировкой.
+func (r *ReadRouter) sweep(now time.Time) {
+	for userID, until := range r.users {
+		if !until.After(now) {
+			delete(r.users, userID)
+		}
+	}
+	for shortURL, until := range r.shortURLs {
+		if !until.After(now) {
+			delete(r.shortURLs, shortURL)
+		}
+	}
+	r.nextSweep = now.Add(r.readAfterWrite)
+}
+
+// NewReadRouter создает ReadRouter, читающий с реплик replicas, а в течение readAfterWrite
+// после записи пользователя - с основной БД primary.
+func NewReadRouter(primary *pgxpool.Pool, replicas *db.Replicas, readAfterWrite time.Duration) *ReadRouter {
+	return &ReadRouter{
+		primary:        primary,
+		replicas:       replicas,
+		readAfterWrite: readAfterWrite,
+		users:          make(map[uuid.UUID]time.Time),
+		shortURLs:      make(map[string]time.Time),
+		now:            time.Now,
+	}
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// Снимок хранилища в памяти - строки URL в формате файлового хранилища, сжатые gzip.
+
+// gzipMagic первые байты сжатого gzip файла.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// WriteSnapshot атомарно сохраняет снимок строк URL в файл.
+func WriteSnapshot(filePath string, urlRows []models.URLRow) error {
+	return writeFileAtomically(filePath, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if err := writeURLRows(zw, urlRows); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// ReadSnapshot читает строки URL из снимка. Отсутствующий снимок считается пустым.
+// В отличие от файлового хранилища, нераскодируемая строка - ошибка: снимок пишется атомарно
+// и испорченным быть не должен, а загрузка его части потеряла бы данные при следующем сохранении.
+func ReadSnapshot(filePath string) ([]models.URLRow, error) {
+	reader, _, err := OpenURLRowsFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var urlRows []models.URLRow
+	dec := json.NewDecoder(reader)
+	for {
+		var urlRow models.URLRow
+		err := dec.Decode(&urlRow)
+		if errors.Is(err, io.EOF) {
+			return urlRows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s is corrupted after %d rows: %w", filePath, len(urlRows), err)
+		}
+		urlRows = append(urlRows, urlRow)
+	}
+}
+
+// OpenURLRowsFile открывает файл со строками URL в формате файлового хранилища.
+// Сжатый файл (снимок) распаковывается при чтении, о чем сообщает compressed.
+func OpenURLRowsFile(filePath string) (reader io.ReadCloser, compressed bool, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	buffered := bufio.NewReader(file)
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		file.Close()
+		return nil, false, err
+	}
+	if len(magic) < len(gzipMagic) || magic[0] != gzipMagic[0] || magic[1] != gzipMagic[1] {
+		return readCloser{Reader: buffered, Closer: file}, false, nil
+	}
+	zr, err := gzip.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	return readCloser{Reader: zr, Closer: file}, true, nil
+}
+
+// readCloser читает из распаковщика или буфера, а закрывает исходный файл.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// SaveMemorySnapshot сохраняет снимок хранилища в памяти и возвращает количество сохраненных строк.
+// Блокировка удерживается только на время копирования строк, а не записи файла.
+func SaveMemorySnapshot(sharedURLRows *models.SharedURLRows, filePath string) (int, error) {
+	sharedURLRows.Mu.Lock()
+	urlRows := make([]models.URLRow, len(sharedURLRows.URLRows))
+	for i, urlRow := range sharedURLRows.URLRows {
+		urlRows[i] = cloneURLRow(urlRow)
+	}
+	sharedURLRows.Mu.Unlock()
+
+	if err := WriteSnapshot(filePath, urlRows); err != nil {
+		return 0, err
+	}
+	return len(urlRows), nil
+}
+
+// LoadMemorySnapshot заменяет строки хранилища в памяти строками из снимка
+// и возвращает их количество. Отсутствующий снимок оставляет хранилище пустым.
+func LoadMemorySnapshot(sharedURLRows *models.SharedURLRows, filePath string) (int, error) {
+	urlRows, err := ReadSnapshot(filePath)
+	if err != nil {
+		return 0, err
+	}
+	if urlRows == nil {
+		urlRows = make([]models.URLRow, 0)
+	}
+
+	sharedURLRows.Mu.Lock()
+	defer sharedURLRows.Mu.Unlock()
+	sharedURLRows.URLRows = urlRows
+	return len(urlRows), nil
+}
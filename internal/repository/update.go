@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// updateURLRows меняет исходный URL пользователя в списке строк хранилища по тем же правилам
+// дедупликации, что и при сохранении, и возвращает измененную строку.
+// Если исходный URL не меняется, строка возвращается как есть и история не пополняется.
+func updateURLRows(scope string, urlRows []models.URLRow, shortURL string, userID uuid.UUID, originalURL string, at time.Time) (models.URLRow, error) {
+	index := -1
+	for i, urlRow := range urlRows {
+		if urlRow.ShortURL == shortURL {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return models.URLRow{}, &apperrors.URLNotFound{ShortURL: shortURL}
+	}
+	urlRow := &urlRows[index]
+	if urlRow.UserID != userID {
+		return models.URLRow{}, &apperrors.URLAccessDenied{ShortURL: shortURL}
+	}
+	if urlRow.DeletedFlag {
+		return models.URLRow{}, &apperrors.URLIsDeleted{ShortURL: shortURL}
+	}
+	if urlRow.OriginalURL == originalURL {
+		return cloneURLRow(*urlRow), nil
+	}
+
+	url := models.URLToSave{URLStr: originalURL, UserID: userID}
+	for _, other := range urlRows {
+		if dedupConflicts(scope, other, url) {
+			return models.URLRow{}, &apperrors.OriginalURLAlreadyExists{URL: originalURL}
+		}
+	}
+
+	urlRow.ChangeOriginalURL(originalURL, at)
+	return cloneURLRow(*urlRow), nil
+}
+
+// cloneURLRow копирует строку вместе с историей, чтобы вызывающий код не делил ее с хранилищем.
+func cloneURLRow(urlRow models.URLRow) models.URLRow {
+	urlRow.History = append([]models.URLHistoryRecord(nil), urlRow.History...)
+	return urlRow
+}
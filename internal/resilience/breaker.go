@@ -0,0 +1,134 @@
+// Package resilience содержит повторы запросов и автоматический выключатель для репозиториев БД.
+package resilience
+
+import (
+	"sync"
+	"time"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
+)
+
+// State состояние автоматического выключателя.
+type State int
+
+// Состояния автоматического выключателя; значения совпадают со значениями метрики состояния.
+const (
+	StateClosed   State = iota // Запросы к БД выполняются.
+	StateOpen                  // БД недоступна, запросы отклоняются без обращения к ней.
+	StateHalfOpen              // Пробный запрос проверяет, восстановилась ли БД.
+)
+
+// String возвращает название состояния для логов.
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker автоматический выключатель: после failureThreshold сбоев подряд на openTimeout
+// перестает пропускать запросы к БД, затем пропускает один пробный запрос.
+type Breaker struct {
+	failureThreshold int           // Количество сбоев подряд, после которого выключатель размыкается; 0 - выключатель отключен.
+	openTimeout      time.Duration // Время, в течение которого запросы отклоняются.
+
+	mu       sync.Mutex
+	state    State
+	failures int       // Количество сбоев подряд в замкнутом состоянии.
+	openedAt time.Time // Время размыкания.
+	probeAt  time.Time // Время начала пробного запроса.
+	now      func() time.Time
+}
+
+// Allow сообщает, можно ли выполнить запрос к БД. Если нельзя, возвращает *apperrors.StorageUnavailable
+// со временем, через которое стоит повторить запрос.
+func (b *Breaker) Allow() error {
+	if b.failureThreshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case StateOpen:
+		if elapsed := now.Sub(b.openedAt); elapsed < b.openTimeout {
+			return &apperrors.StorageUnavailable{RetryAfter: b.openTimeout - elapsed}
+		}
+		b.setState(StateHalfOpen)
+		b.probeAt = now
+	case StateHalfOpen:
+		// Пробный запрос мог быть отменен, не сообщив результат; тогда через openTimeout пропускается новый.
+		if elapsed := now.Sub(b.probeAt); elapsed < b.openTimeout {
+			return &apperrors.StorageUnavailable{RetryAfter: b.openTimeout - elapsed}
+		}
+		b.probeAt = now
+	}
+	return nil
+}
+
+// Record учитывает результат запроса к БД: failed - БД оказалась недоступна.
+func (b *Breaker) Record(failed bool) {
+	if b.failureThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		b.setState(StateClosed)
+		return
+	}
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = b.now()
+		b.setState(StateOpen)
+	}
+}
+
+// RetryAfter возвращает, через сколько выключатель пропустит следующий запрос; 0 - пропускает сейчас.
+func (b *Breaker) RetryAfter() time.Duration {
+	if b.failureThreshold <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != StateOpen {
+		return 0
+	}
+	return max(b.openTimeout-b.now().Sub(b.openedAt), 0)
+}
+
+// State возвращает текущее состояние выключателя.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState меняет состояние и обновляет метрику. Вызывается под блокировкой.
+func (b *Breaker) setState(state State) {
+	if state == StateClosed {
+		b.failures = 0
+	}
+	b.state = state
+	metrics.DBBreakerState.Set(float64(state))
+}
+
+// NewBreaker создает замкнутый выключатель. failureThreshold <= 0 отключает выключатель.
+func NewBreaker(failureThreshold int, openTimeout time.Duration) *Breaker {
+	return &Breaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		now:              time.Now,
+	}
+}
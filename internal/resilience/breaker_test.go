@@ -0,0 +1,73 @@
+package resilience
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(2, 10*time.Second)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Record(true)
+	require.NoError(t, breaker.Allow())
+	breaker.Record(false)
+	breaker.Record(true)
+	assert.Equal(t, StateClosed, breaker.State(), "Успешный запрос должен сбрасывать счетчик сбоев")
+
+	breaker.Record(true)
+	assert.Equal(t, StateOpen, breaker.State())
+	err := breaker.Allow()
+	var unavailableErr *apperrors.StorageUnavailable
+	require.True(t, errors.As(err, &unavailableErr))
+	assert.Equal(t, 10*time.Second, unavailableErr.RetryAfter)
+
+	now = now.Add(4 * time.Second)
+	assert.Equal(t, 6*time.Second, breaker.RetryAfter())
+
+	// По истечении openTimeout пропускается только один пробный запрос.
+	now = now.Add(6 * time.Second)
+	require.NoError(t, breaker.Allow())
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	assert.Error(t, breaker.Allow())
+
+	breaker.Record(true)
+	assert.Equal(t, StateOpen, breaker.State(), "Неудачный пробный запрос должен снова размыкать выключатель")
+
+	now = now.Add(10 * time.Second)
+	require.NoError(t, breaker.Allow())
+	breaker.Record(false)
+	assert.Equal(t, StateClosed, breaker.State())
+	assert.NoError(t, breaker.Allow())
+}
+
+func TestBreakerLostProbe(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(1, time.Second)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Record(true)
+	now = now.Add(time.Second)
+	require.NoError(t, breaker.Allow())
+	assert.Error(t, breaker.Allow())
+
+	// Результат пробного запроса не пришел: через openTimeout пропускается новый.
+	now = now.Add(time.Second)
+	assert.NoError(t, breaker.Allow())
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	breaker := NewBreaker(0, time.Second)
+	for i := 0; i < 10; i++ {
+		breaker.Record(true)
+	}
+	assert.NoError(t, breaker.Allow())
+	assert.Equal(t, StateClosed, breaker.State())
+}
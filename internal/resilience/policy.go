@@ -0,0 +1,151 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
+)
+
+// errorKind вид ошибки запроса к БД с точки зрения повторов.
+type errorKind int
+
+const (
+	errorPermanent   errorKind = iota // Ошибка запроса, повтор не поможет.
+	errorConflict                     // Конфликт транзакций; транзакция откатилась, и ее можно повторить.
+	errorUnavailable                  // БД недоступна или соединение оборвалось.
+	errorCanceled                     // Запрос отменен клиентом; о доступности БД ничего не говорит.
+)
+
+// Коды ошибок PostgreSQL, которые означают недоступность БД.
+var unavailableCodes = map[string]bool{
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"53300": true, // too_many_connections
+}
+
+// classify определяет вид ошибки запроса к БД.
+func classify(err error) errorKind {
+	if errors.Is(err, context.Canceled) {
+		return errorCanceled
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "40001" || pgErr.Code == "40P01": // serialization_failure, deadlock_detected
+			return errorConflict
+		case strings.HasPrefix(pgErr.Code, "08") || unavailableCodes[pgErr.Code]: // connection_exception
+			return errorUnavailable
+		}
+		return errorPermanent
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), pgconn.Timeout(err), errors.As(err, &connectErr), errors.As(err, &netErr),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return errorUnavailable
+	}
+	return errorPermanent
+}
+
+// Policy повторяет запросы к БД после временных ошибок с экспоненциальной задержкой со случайным разбросом
+// и не обращается к БД, пока разомкнут автоматический выключатель.
+type Policy struct {
+	maxAttempts int           // Максимальное количество попыток выполнения запроса.
+	baseDelay   time.Duration // Задержка перед первым повтором.
+	maxDelay    time.Duration // Максимальная задержка перед повтором.
+	breaker     *Breaker      // Автоматический выключатель.
+	sleep       func(ctx context.Context, d time.Duration) error
+}
+
+// Allow сообщает, можно ли сейчас обращаться к БД, для запросов, ошибки которых не видны политике.
+func (p *Policy) Allow(operation string) bool {
+	if err := p.breaker.Allow(); err != nil {
+		metrics.DBBreakerRejectionsTotal.WithLabelValues(operation).Inc()
+		return false
+	}
+	return true
+}
+
+// do выполняет запрос fn с повторами. Недоступность БД повторяется только для идемпотентных запросов
+// или если запрос заведомо не дошел до БД; конфликты транзакций повторяются всегда.
+// Если БД так и осталась недоступна, возвращается *apperrors.StorageUnavailable.
+func (p *Policy) do(ctx context.Context, operation string, idempotent bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := p.breaker.Allow(); err != nil {
+			metrics.DBBreakerRejectionsTotal.WithLabelValues(operation).Inc()
+			return err
+		}
+		err := fn()
+		if err == nil {
+			p.breaker.Record(false)
+			return nil
+		}
+
+		kind := classify(err)
+		if kind != errorCanceled {
+			p.breaker.Record(kind == errorUnavailable)
+		}
+		retry := kind == errorConflict || (kind == errorUnavailable && (idempotent || pgconn.SafeToRetry(err)))
+		if retry && attempt < p.maxAttempts && ctx.Err() == nil {
+			metrics.DBRetriesTotal.WithLabelValues(operation).Inc()
+			if p.sleep(ctx, p.backoff(attempt)) == nil {
+				continue
+			}
+		}
+		if kind == errorUnavailable {
+			return &apperrors.StorageUnavailable{RetryAfter: max(p.breaker.RetryAfter(), p.maxDelay), Err: err}
+		}
+		return err
+	}
+}
+
+// backoff возвращает задержку перед повтором после попытки attempt: случайное значение
+// от нуля до baseDelay*2^(attempt-1), но не больше maxDelay.
+func (p *Policy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.maxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// sleep ждет d или отмены контекста.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// NewPolicy создает политику, выполняющую запрос не больше maxAttempts раз
+// с задержками от baseDelay до maxDelay между попытками.
+func NewPolicy(maxAttempts int, baseDelay, maxDelay time.Duration, breaker *Breaker) *Policy {
+	return &Policy{
+		maxAttempts: max(maxAttempts, 1),
+		baseDelay:   baseDelay,
+		maxDelay:    max(maxDelay, baseDelay),
+		breaker:     breaker,
+		sleep:       sleep,
+	}
+}
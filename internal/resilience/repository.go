@@ -0,0 +1,162 @@
+package resilience
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+)
+
+// URLRepository повторяет запросы репозитория URL после временных ошибок БД
+// и отклоняет их, пока БД недоступна.
+type URLRepository struct {
+	repo   service.URLRepository // Исходный репозиторий.
+	policy *Policy               // Политика повторов и выключатель.
+}
+
+// UserRepository повторяет запросы репозитория пользователей после временных ошибок БД
+// и отклоняет их, пока БД недоступна.
+type UserRepository struct {
+	repo   service.UserRepository // Исходный репозиторий.
+	policy *Policy                // Политика повторов и выключатель.
+}
+
+// Save сохраняет URL. После обрыва соединения запрос повторяется, только если он не дошел до БД.
+func (r *URLRepository) Save(ctx context.Context, url models.URLToSave) (uuid.UUID, error) {
+	var UUID uuid.UUID
+	err := r.policy.do(ctx, "save", false, func() (err error) {
+		UUID, err = r.repo.Save(ctx, url)
+		return err
+	})
+	return UUID, err
+}
+
+// BatchSave сохраняет список URL. После обрыва соединения запрос повторяется, только если он не дошел до БД.
+func (r *URLRepository) BatchSave(ctx context.Context, urls []models.URLToSave) ([]uuid.UUID, error) {
+	var UUIDs []uuid.UUID
+	err := r.policy.do(ctx, "batch_save", false, func() (err error) {
+		UUIDs, err = r.repo.BatchSave(ctx, urls)
+		return err
+	})
+	return UUIDs, err
+}
+
+// BatchDelete удаляет список URL.
+func (r *URLRepository) BatchDelete(ctx context.Context, urls []string, userID uuid.UUID) error {
+	return r.policy.do(ctx, "batch_delete", true, func() error {
+		return r.repo.BatchDelete(ctx, urls, userID)
+	})
+}
+
+// BatchDeleteByUsers удаляет URL нескольких пользователей разом.
+func (r *URLRepository) BatchDeleteByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	return r.policy.do(ctx, "batch_delete_by_users", true, func() error {
+		return r.repo.BatchDeleteByUsers(ctx, urlsByUser)
+	})
+}
+
+// BatchRestoreByUsers восстанавливает удаленные URL нескольких пользователей разом.
+func (r *URLRepository) BatchRestoreByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	return r.policy.do(ctx, "batch_restore_by_users", true, func() error {
+		return r.repo.BatchRestoreByUsers(ctx, urlsByUser)
+	})
+}
+
+// Find выполняет поиск URL по короткому адресу.
+func (r *URLRepository) Find(ctx context.Context, shortURL string) (models.URLRow, bool, error) {
+	var row models.URLRow
+	var found bool
+	err := r.policy.do(ctx, "find", true, func() (err error) {
+		row, found, err = r.repo.Find(ctx, shortURL)
+		return err
+	})
+	return row, found, err
+}
+
+// FindByUserID ищет все URL пользователя.
+func (r *URLRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.URLRow, error) {
+	var rows []models.URLRow
+	err := r.policy.do(ctx, "find_by_user_id", true, func() (err error) {
+		rows, err = r.repo.FindByUserID(ctx, userID)
+		return err
+	})
+	return rows, err
+}
+
+// FindByUserIDWithOptions выбирает страницу URL пользователя.
+func (r *URLRepository) FindByUserIDWithOptions(ctx context.Context, userID uuid.UUID, opts models.URLQueryOptions) ([]models.URLRow, string, error) {
+	var rows []models.URLRow
+	var nextCursor string
+	err := r.policy.do(ctx, "find_by_user_id_with_options", true, func() (err error) {
+		rows, nextCursor, err = r.repo.FindByUserIDWithOptions(ctx, userID, opts)
+		return err
+	})
+	return rows, nextCursor, err
+}
+
+// FindByOriginalURL ищет URL по оригинальному адресу. Ошибки хранилища этот запрос не возвращает,
+// поэтому он не повторяется, а только отклоняется, пока БД недоступна.
+func (r *URLRepository) FindByOriginalURL(ctx context.Context, originalURL string, userID uuid.UUID) (string, bool) {
+	if !r.policy.Allow("find_by_original_url") {
+		return "", false
+	}
+	return r.repo.FindByOriginalURL(ctx, originalURL, userID)
+}
+
+// UpdateOriginalURL меняет исходный URL пользователя. После обрыва соединения запрос повторяется,
+// только если он не дошел до БД.
+func (r *URLRepository) UpdateOriginalURL(ctx context.Context, shortURL string, userID uuid.UUID, originalURL string) (models.URLRow, error) {
+	var row models.URLRow
+	err := r.policy.do(ctx, "update_original_url", false, func() (err error) {
+		row, err = r.repo.UpdateOriginalURL(ctx, shortURL, userID, originalURL)
+		return err
+	})
+	return row, err
+}
+
+// PurgeDeleted окончательно стирает URL, удаленные раньше deletedBefore.
+// Возвращает количество URL, стертых всеми попытками.
+func (r *URLRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (int, error) {
+	var purged int
+	err := r.policy.do(ctx, "purge_deleted", true, func() error {
+		n, err := r.repo.PurgeDeleted(ctx, deletedBefore, batchSize)
+		purged += n
+		return err
+	})
+	return purged, err
+}
+
+// ForEachShortURL перебирает короткие адреса всех URL, включая удаленные.
+// При повторе fn может получить уже переданные адреса еще раз.
+func (r *URLRepository) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	return r.policy.do(ctx, "for_each_short_url", true, func() error {
+		return r.repo.ForEachShortURL(ctx, fn)
+	})
+}
+
+// UpdateUser привязывает URL к пользователю.
+func (r *UserRepository) UpdateUser(ctx context.Context, savedURLUUID uuid.UUID, userID uuid.UUID) error {
+	return r.policy.do(ctx, "update_user", true, func() error {
+		return r.repo.UpdateUser(ctx, savedURLUUID, userID)
+	})
+}
+
+// UpdateBatchUser привязывает список URL к пользователю.
+func (r *UserRepository) UpdateBatchUser(ctx context.Context, savedURLUUIDs []uuid.UUID, userID uuid.UUID) error {
+	return r.policy.do(ctx, "update_batch_user", true, func() error {
+		return r.repo.UpdateBatchUser(ctx, savedURLUUIDs, userID)
+	})
+}
+
+// NewURLRepository оборачивает репозиторий URL повторами и выключателем policy.
+func NewURLRepository(repo service.URLRepository, policy *Policy) *URLRepository {
+	return &URLRepository{repo: repo, policy: policy}
+}
+
+// NewUserRepository оборачивает репозиторий пользователей повторами и выключателем policy.
+func NewUserRepository(repo service.UserRepository, policy *Policy) *UserRepository {
+	return &UserRepository{repo: repo, policy: policy}
+}
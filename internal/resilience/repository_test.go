@@ -0,0 +1,152 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+)
+
+// failingURLRepository возвращает заданные ошибки из Find и Save по очереди, затем успех.
+type failingURLRepository struct {
+	service.URLRepository
+	errs  []error
+	calls int
+}
+
+func (r *failingURLRepository) next() error {
+	r.calls++
+	if len(r.errs) == 0 {
+		return nil
+	}
+	err := r.errs[0]
+	r.errs = r.errs[1:]
+	return err
+}
+
+func (r *failingURLRepository) Find(_ context.Context, shortURL string) (models.URLRow, bool, error) {
+	if err := r.next(); err != nil {
+		return models.URLRow{}, false, err
+	}
+	return models.URLRow{ShortURL: shortURL}, true, nil
+}
+
+func (r *failingURLRepository) Save(_ context.Context, _ models.URLToSave) (uuid.UUID, error) {
+	return uuid.New(), r.next()
+}
+
+func (r *failingURLRepository) FindByOriginalURL(_ context.Context, _ string, _ uuid.UUID) (string, bool) {
+	r.calls++
+	return "abcdefgh", true
+}
+
+// connReset ошибка обрыва соединения после отправки запроса.
+var connReset = fmt.Errorf("read: %w", syscall.ECONNRESET)
+
+func newTestRepository(failureThreshold int, errs ...error) (*URLRepository, *failingURLRepository) {
+	fake := &failingURLRepository{errs: errs}
+	policy := NewPolicy(3, time.Millisecond, time.Millisecond, NewBreaker(failureThreshold, time.Minute))
+	policy.sleep = func(context.Context, time.Duration) error { return nil }
+	return NewURLRepository(fake, policy), fake
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorKind
+	}{
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: errorConflict},
+		{name: "deadlock", err: fmt.Errorf("tx: %w", &pgconn.PgError{Code: "40P01"}), want: errorConflict},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, want: errorUnavailable},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: errorUnavailable},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}, want: errorPermanent},
+		{name: "connection reset", err: connReset, want: errorUnavailable},
+		{name: "deadline", err: context.DeadlineExceeded, want: errorUnavailable},
+		{name: "canceled", err: context.Canceled, want: errorCanceled},
+		{name: "not found", err: &apperrors.URLNotFound{ShortURL: "abcdefgh"}, want: errorPermanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, classify(tt.err))
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("idempotent query is retried after connection reset", func(t *testing.T) {
+		repo, fake := newTestRepository(5, connReset, connReset)
+		row, found, err := repo.Find(ctx, "abcdefgh")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "abcdefgh", row.ShortURL)
+		assert.Equal(t, 3, fake.calls)
+	})
+
+	t.Run("save is not retried after connection reset", func(t *testing.T) {
+		repo, fake := newTestRepository(5, connReset)
+		_, err := repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh"})
+		var unavailableErr *apperrors.StorageUnavailable
+		require.True(t, errors.As(err, &unavailableErr))
+		assert.ErrorIs(t, err, syscall.ECONNRESET)
+		assert.Equal(t, 1, fake.calls)
+	})
+
+	t.Run("save is retried after serialization failure", func(t *testing.T) {
+		repo, fake := newTestRepository(5, &pgconn.PgError{Code: "40001"})
+		_, err := repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh"})
+		require.NoError(t, err)
+		assert.Equal(t, 2, fake.calls)
+	})
+
+	t.Run("permanent error is returned as is", func(t *testing.T) {
+		duplicate := &apperrors.ShortURLAlreadyExists{ShortURL: "abcdefgh"}
+		repo, fake := newTestRepository(5, duplicate)
+		_, err := repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh"})
+		assert.Same(t, duplicate, err)
+		assert.Equal(t, 1, fake.calls)
+	})
+
+	t.Run("attempts are limited", func(t *testing.T) {
+		repo, fake := newTestRepository(5, connReset, connReset, connReset, connReset)
+		_, _, err := repo.Find(ctx, "abcdefgh")
+		var unavailableErr *apperrors.StorageUnavailable
+		require.True(t, errors.As(err, &unavailableErr))
+		assert.Equal(t, 3, fake.calls)
+	})
+}
+
+func TestBreakerFailsFast(t *testing.T) {
+	ctx := context.Background()
+	repo, fake := newTestRepository(2, connReset, connReset)
+
+	_, _, err := repo.Find(ctx, "abcdefgh")
+	require.Error(t, err)
+	assert.Equal(t, 2, fake.calls, "Повторы должны прекращаться, как только выключатель разомкнется")
+	assert.Equal(t, StateOpen, repo.policy.breaker.State())
+
+	_, _, err = repo.Find(ctx, "abcdefgh")
+	var unavailableErr *apperrors.StorageUnavailable
+	require.True(t, errors.As(err, &unavailableErr))
+	assert.Positive(t, unavailableErr.RetryAfter)
+	_, err = repo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh"})
+	assert.True(t, errors.As(err, &unavailableErr))
+	_, err = repo.FindByUserID(ctx, uuid.Nil)
+	assert.True(t, errors.As(err, &unavailableErr), "Список URL пользователя должен отклоняться с ошибкой недоступности")
+	_, found := repo.FindByOriginalURL(ctx, "https://ya.ru", uuid.Nil)
+	assert.False(t, found)
+	assert.Equal(t, 2, fake.calls, "Разомкнутый выключатель не должен пропускать запросы к БД")
+}
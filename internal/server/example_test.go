@@ -25,7 +25,7 @@ func Example() {
 
 	sugar := logger.GetLogger() // Получаем логгер
 
-	DB, err := db.InitDB(serverConfig.DatabaseDSN, sugar) // Инициализация базы данных
+	DB, err := db.InitDB(serverConfig.DatabaseDSN, serverConfig.MigrationsMode, sugar) // Инициализация базы данных
 	if err != nil {
 		log.Fatalf("Failed to initialize DB: %v", err)
 	}
@@ -41,13 +41,13 @@ func Example() {
 	shortenerService := service.NewURLShortenerService(serverConfig, &shortenerrepo, &userrepo)
 
 	// Инициализируем рабочего для удаления URL
-	worker := workers.InitURLDeletionWorker(shortenerService)
+	worker := workers.InitURLDeletionWorker(shortenerService, serverConfig, sugar)
 
 	// Инициализируем контроллер URL
 	URLCtrl := controller.NewURLShortenerController(shortenerService, sugar, worker)
 
 	// Инициализируем контроллер проверки состояния здоровья
-	HealthCtrl := controller.NewHealthCheckController(DB)
+	HealthCtrl := controller.NewHealthCheckController(InitHealthChecker(serverConfig, DB, worker))
 
 	// Инициализируем маршрутизатор
 	router := Router(URLCtrl, HealthCtrl, sugar)
@@ -4,23 +4,34 @@ package server
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	bolt "go.etcd.io/bbolt"
 
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/cache"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/controller"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/db"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/health"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/middlewares"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/resilience"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/workers"
 )
 
@@ -31,7 +42,10 @@ func Router(
 	sugar *logger.Logger,
 ) chi.Router {
 	r := chi.NewRouter()
-	r.Use(middlewares.RequestLoggerMiddleware(sugar))
+	r.Use(middlewares.TracingMiddleware)
+	r.Use(middlewares.RequestIDMiddleware)
+	// Лог запросов сэмплируется, чтобы не захлебнуться под нагрузкой.
+	r.Use(middlewares.RequestLoggerMiddleware(sugar.Sampled()))
 	r.Use(middlewares.GzipMiddleware)
 	r.Use(middlewares.JWTMiddleware)
 	r.Post("/", URLShortenerController.SaveURL)
@@ -40,66 +54,368 @@ func Router(
 	r.Post("/api/shorten", URLShortenerController.ShortenURL)
 	r.Get("/api/user/urls", URLShortenerController.GetURLByUser)
 	r.Delete("/api/user/urls", URLShortenerController.DeleteBatchURL)
+	r.Post("/api/user/urls/restore", URLShortenerController.RestoreBatchURL)
+	r.Get("/api/user/urls/export", URLShortenerController.ExportURLs)
+	r.Post("/api/user/urls/import", URLShortenerController.ImportURLs)
+	r.Patch("/api/user/urls/{shortURL}", URLShortenerController.UpdateURL)
 	r.Get("/ping", HealthCheckController.Ping)
+	r.Get("/healthz", HealthCheckController.Healthz)
+	r.Get("/readyz", HealthCheckController.Readyz)
 	return r
 }
 
 // InitURLRepository инициализирует репозиторий URL в зависимости от конфигурации.
-func InitURLRepository(serverConfig config.Config, db *sql.DB, sharedURLRows *models.SharedURLRows, sugar *logger.Logger) (service.URLRepository, error) {
+// Время выполнения операций репозитория замеряется в метриках с меткой выбранного хранилища.
+// Репозиторий БД оборачивается повторами и выключателем policy, если она задана.
+// Для БД уникальный индекс оригинальных URL приводится к области дедупликации, а при ручных миграциях
+// несоответствие индекса и области останавливает запуск.
+func InitURLRepository(serverConfig config.Config, reads *repository.ReadRouter, policy *resilience.Policy, boltDB *bolt.DB, sharedURLRows *models.SharedURLRows, sugar *logger.Logger) (service.URLRepository, error) {
+	var repo service.URLRepository
+	var err error
 	if serverConfig.DatabaseDSN != "" {
-		return repository.NewDBURLRepository(db)
+		var dbRepo *repository.DBURLRepository
+		dbRepo, err = repository.NewReplicatedDBURLRepository(reads, serverConfig.DedupScope)
+		if err == nil {
+			err = dbRepo.PrepareDedupIndex(context.Background(), serverConfig.MigrationsMode != db.MigrationsModeManual)
+		}
+		repo = dbRepo
+		if err == nil && policy != nil {
+			repo = resilience.NewURLRepository(repo, policy)
+		}
+	} else if serverConfig.BoltStoragePath != "" {
+		repo, err = repository.NewBoltURLRepository(boltDB, serverConfig.DedupScope)
 	} else if serverConfig.FileStoragePath != "" {
-		return repository.NewFileURLRepository(serverConfig, sugar)
+		repo, err = repository.NewFileURLRepository(serverConfig, sugar)
 	} else {
-		return repository.NewMemoryURLRepository(sharedURLRows)
+		repo, err = repository.NewMemoryURLRepository(sharedURLRows, serverConfig.DedupScope)
 	}
-
+	if err != nil {
+		return nil, err
+	}
+	return metrics.InstrumentURLRepository(repo, storageBackend(serverConfig)), nil
 }
 
 // InitURLRepository инициализирует репозиторий пользователя в зависимости от конфигурации.
-func initUserRepository(serverConfig config.Config, db *sql.DB, sharedURLRows *models.SharedURLRows, sugar *logger.Logger) (service.UserRepository, error) {
+func initUserRepository(serverConfig config.Config, reads *repository.ReadRouter, policy *resilience.Policy, boltDB *bolt.DB, sharedURLRows *models.SharedURLRows, sugar *logger.Logger) (service.UserRepository, error) {
+	var repo service.UserRepository
+	var err error
 	if serverConfig.DatabaseDSN != "" {
-		return repository.NewDBUserRepository(db)
+		repo, err = repository.NewReplicatedDBUserRepository(reads)
+		if err == nil && policy != nil {
+			repo = resilience.NewUserRepository(repo, policy)
+		}
+	} else if serverConfig.BoltStoragePath != "" {
+		repo, err = repository.NewBoltUserRepository(boltDB)
 	} else if serverConfig.FileStoragePath != "" {
-		return repository.NewFileUserRepository(serverConfig, sugar)
+		repo, err = repository.NewFileUserRepository(serverConfig, sugar)
 	} else {
-		return repository.NewMemoryUserRepository(sharedURLRows)
+		repo, err = repository.NewMemoryUserRepository(sharedURLRows)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metrics.InstrumentUserRepository(repo, storageBackend(serverConfig)), nil
+}
+
+// initURLCache оборачивает репозитории кэшем поиска URL по короткому адресу, если размер кэша задан.
+// Кэш стоит перед замером метрик, поэтому в метрики хранилища попадают только промахи.
+// Возвращает nil вместо кэша, если он отключен.
+func initURLCache(serverConfig config.Config, urlRepo service.URLRepository, userRepo service.UserRepository) (*cache.URLCache, service.URLRepository, service.UserRepository) {
+	if serverConfig.CacheSize <= 0 {
+		return nil, urlRepo, userRepo
+	}
+	urlCache := cache.NewURLCache(serverConfig.CacheSize, serverConfig.CacheTTL, serverConfig.CacheNegativeTTL)
+	return urlCache, cache.NewCachedURLRepository(urlRepo, urlCache), cache.NewCachedUserRepository(userRepo, urlCache)
+}
+
+// Пороги проверок готовности.
+const (
+	readinessCheckTimeout  = 2 * time.Second   // Время на выполнение одной проверки.
+	readinessMinFreeDisk   = 100 * 1024 * 1024 // Минимум свободного места на диске с файлом хранилища.
+	readinessMaxQueueRatio = 0.9               // Максимальная заполненность очереди удаления.
+)
+
+// InitHealthChecker собирает проверки готовности для выбранного хранилища.
+func InitHealthChecker(serverConfig config.Config, DB *sql.DB, queue health.DeletionQueue) *health.Checker {
+	checker := health.NewChecker(readinessCheckTimeout, health.DeletionQueueCheck(queue, readinessMaxQueueRatio))
+	if serverConfig.DatabaseDSN != "" {
+		checker.Register(health.DatabaseCheck(DB))
+		checker.Register(health.MigrationsCheck(DB))
+	} else if serverConfig.BoltStoragePath != "" {
+		checker.Register(health.FileStorageCheck(serverConfig.BoltStoragePath, readinessMinFreeDisk))
+	} else if serverConfig.FileStoragePath != "" {
+		checker.Register(health.FileStorageCheck(serverConfig.FileStoragePath, readinessMinFreeDisk))
+	}
+	return checker
+}
+
+// storageBackend возвращает название хранилища, выбранного в конфигурации.
+func storageBackend(serverConfig config.Config) string {
+	if serverConfig.DatabaseDSN != "" {
+		return "postgres"
+	} else if serverConfig.BoltStoragePath != "" {
+		return "bolt"
+	} else if serverConfig.FileStoragePath != "" {
+		return "file"
+	}
+	return "memory"
+}
+
+// initDB открывает пул подключений к БД, если она выбрана хранилищем, и регистрирует метрики пула.
+// Для проверок здоровья и миграций возвращает также *sql.DB поверх пула; без БД пул равен nil.
+func initDB(serverConfig config.Config, sugar *logger.Logger) (*pgxpool.Pool, *sql.DB, error) {
+	if serverConfig.DatabaseDSN == "" {
+		DB, err := db.InitDB(serverConfig.DatabaseDSN, serverConfig.MigrationsMode, sugar)
+		return nil, DB, err
+	}
+	pool, err := db.InitPool(context.Background(), serverConfig.DatabaseDSN, serverConfig.MigrationsMode, dbPoolOptions(serverConfig), sugar)
+	if err != nil {
+		return nil, nil, err
+	}
+	metrics.Registry.MustRegister(metrics.NewDBPoolCollector(pool))
+	return pool, stdlib.OpenDBFromPool(pool), nil
+}
+
+// initReplicas создает пулы подключений к репликам БД, если они заданы. Иначе возвращает nil.
+func initReplicas(serverConfig config.Config) (*db.Replicas, error) {
+	if serverConfig.DatabaseDSN == "" || len(serverConfig.DatabaseReplicaDSN) == 0 {
+		return nil, nil
+	}
+	return db.InitReplicas(context.Background(), serverConfig.DatabaseReplicaDSN, serverConfig.DBReplicaMaxLag, dbPoolOptions(serverConfig))
+}
+
+// dbPoolOptions возвращает настройки пулов подключений к БД и репликам из конфигурации.
+func dbPoolOptions(serverConfig config.Config) db.PoolOptions {
+	return db.PoolOptions{
+		MaxConns:         int32(serverConfig.DBMaxConns),
+		MinConns:         int32(serverConfig.DBMinConns),
+		MaxConnLifetime:  serverConfig.DBMaxConnLifetime,
+		MaxConnIdleTime:  serverConfig.DBMaxConnIdleTime,
+		StatementTimeout: serverConfig.DBStatementTimeout,
+		QueryExecMode:    serverConfig.DBQueryExecMode,
+	}
+}
+
+// initDBPolicy создает общую для репозиториев БД политику повторов запросов и выключатель.
+// Возвращает nil, если хранилище не БД.
+func initDBPolicy(serverConfig config.Config) *resilience.Policy {
+	if serverConfig.DatabaseDSN == "" {
+		return nil
+	}
+	breaker := resilience.NewBreaker(serverConfig.DBBreakerFailures, serverConfig.DBBreakerOpenTimeout)
+	return resilience.NewPolicy(serverConfig.DBRetryAttempts, serverConfig.DBRetryBaseDelay, serverConfig.DBRetryMaxDelay, breaker)
+}
+
+// initBoltDB открывает встроенную базу, если она выбрана хранилищем. Иначе возвращает nil.
+func initBoltDB(serverConfig config.Config) (*bolt.DB, error) {
+	if storageBackend(serverConfig) != "bolt" {
+		return nil, nil
+	}
+	return repository.OpenBoltDB(serverConfig.BoltStoragePath)
+}
+
+// initSnapshotWorker загружает последний снимок хранилища в памяти и создает процесс сохранения снимков,
+// если хранилище в памяти выбрано и задан файл снимка. Иначе возвращает nil.
+func initSnapshotWorker(serverConfig config.Config, sharedURLRows *models.SharedURLRows, sugar *logger.Logger) (*workers.MemorySnapshotWorker, error) {
+	if storageBackend(serverConfig) != "memory" || serverConfig.SnapshotPath == "" {
+		return nil, nil
+	}
+	rows, err := repository.LoadMemorySnapshot(sharedURLRows, serverConfig.SnapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	sugar.Infof("Loaded %d URLs from snapshot %s", rows, serverConfig.SnapshotPath)
+	return workers.InitMemorySnapshotWorker(sharedURLRows, serverConfig, sugar), nil
+}
+
+// triggerSnapshotOnSignal запрашивает внеплановый снимок по сигналам snapshotSignals до отмены контекста.
+func triggerSnapshotOnSignal(ctx context.Context, snapshotWorker *workers.MemorySnapshotWorker) {
+	if len(snapshotSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, snapshotSignals...)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-signals:
+			snapshotWorker.Trigger()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// startMetricsServer запускает отдельный служебный HTTP-сервер с метриками,
+// ручкой смены уровня логирования и, если включены снимки хранилища в памяти,
+// ручкой внепланового снимка, если задан его адрес. Ручки сервера не требуют авторизации,
+// поэтому он запускается только явно. Если адрес занят, возвращается ошибка.
+func startMetricsServer(serverConfig config.Config, sugar *logger.Logger, snapshotWorker *workers.MemorySnapshotWorker) (*http.Server, error) {
+	if serverConfig.MetricsAddress == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", serverConfig.MetricsAddress)
+	if err != nil {
+		return nil, fmt.Errorf("metrics server: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/admin/log/level", sugar.LevelHandler())
+	if snapshotWorker != nil {
+		mux.Handle("/admin/snapshot", snapshotWorker.Handler())
 	}
+	metricsServer := &http.Server{
+		Addr:    serverConfig.MetricsAddress,
+		Handler: mux,
+	}
+	go func() {
+		if err := metricsServer.Serve(listener); err != http.ErrServerClosed {
+			sugar.Errorf("Metrics server error: %v", err)
+		}
+	}()
+	return metricsServer, nil
+}
+
+// initLogger создает логгер по настройкам из конфигурации.
+func initLogger(serverConfig config.Config) (*logger.Logger, error) {
+	return logger.NewLogger(logger.Options{
+		Level:    serverConfig.LogLevel,
+		Encoding: serverConfig.LogEncoding,
+		Outputs:  serverConfig.LogOutputs,
+		Rotation: logger.Rotation{
+			MaxSizeMB:  serverConfig.LogMaxSizeMB,
+			MaxAgeDays: serverConfig.LogMaxAgeDays,
+			MaxBackups: serverConfig.LogMaxBackups,
+		},
+		Sampling: logger.Sampling{
+			Initial:    serverConfig.LogSamplingInitial,
+			Thereafter: serverConfig.LogSamplingThereafter,
+		},
+	})
+}
 
+// Migrate выполняет команду управления миграциями БД из конфигурации.
+func Migrate(command string) error {
+	serverConfig := config.GetConfig(logger.GetLogger())
+	if serverConfig.DatabaseDSN == "" {
+		return errors.New("database DSN is not set")
+	}
+	DB, err := sql.Open("pgx", serverConfig.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer DB.Close()
+	return db.Migrate(context.Background(), DB, command)
 }
 
 // Run запускает web-приложение.
 func Run() error {
-	sugar := logger.GetLogger()
-	serverConfig := config.GetConfig(sugar)
+	serverConfig := config.GetConfig(logger.GetLogger())
+	sugar, err := initLogger(serverConfig)
+	if err != nil {
+		log.Printf("Server error: %v", err)
+		return err
+	}
+	defer sugar.Sync()
+
+	shutdownTracing, err := tracing.Init(serverConfig)
+	if err != nil {
+		sugar.Errorf("Server error: %v", err)
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			sugar.Errorf("Tracing shutdown failed: %v", err)
+		}
+	}()
 
-	DB, err := db.InitDB(serverConfig.DatabaseDSN, sugar)
+	pool, DB, err := initDB(serverConfig, sugar)
 	if err != nil {
 		sugar.Errorf("Server error: %v", err)
 		return err
 	}
 	defer DB.Close()
+	if pool != nil {
+		defer pool.Close()
+	}
+	replicas, err := initReplicas(serverConfig)
+	if err != nil {
+		sugar.Errorf("Server error: %v", err)
+		return err
+	}
+	if replicas != nil {
+		defer replicas.Close()
+	}
+	reads := repository.NewReadRouter(pool, replicas, serverConfig.DBReadAfterWrite)
+	policy := initDBPolicy(serverConfig)
+	boltDB, err := initBoltDB(serverConfig)
+	if err != nil {
+		sugar.Errorf("Server error: %v", err)
+		return err
+	}
+	if boltDB != nil {
+		defer boltDB.Close()
+	}
 	sharedURLRows := models.NewSharedURLRows()
+	snapshotWorker, err := initSnapshotWorker(serverConfig, sharedURLRows, sugar)
+	if err != nil {
+		sugar.Errorf("Server error: %v", err)
+		return err
+	}
 
-	shortenerrepo, err := InitURLRepository(serverConfig, DB, sharedURLRows, sugar)
+	shortenerrepo, err := InitURLRepository(serverConfig, reads, policy, boltDB, sharedURLRows, sugar)
 	if err != nil {
 		sugar.Errorf("Server error: %v", err)
 		return err
 	}
-	userrepo, err := initUserRepository(serverConfig, DB, sharedURLRows, sugar)
+	userrepo, err := initUserRepository(serverConfig, reads, policy, boltDB, sharedURLRows, sugar)
 	if err != nil {
 		sugar.Errorf("Server error: %v", err)
 		return err
 	}
+	urlCache, shortenerrepo, userrepo := initURLCache(serverConfig, shortenerrepo, userrepo)
 	shortenerService := service.NewURLShortenerService(serverConfig, shortenerrepo, userrepo)
-	worker := workers.InitURLDeletionWorker(shortenerService)
+	worker := workers.InitURLDeletionWorker(shortenerService, serverConfig, sugar)
 	URLCtrl := controller.NewURLShortenerController(shortenerService, sugar, worker)
-	HealthCtrl := controller.NewHealthCheckController(DB)
+	healthChecker := InitHealthChecker(serverConfig, DB, worker)
+	if replicas != nil {
+		for _, replica := range replicas.All() {
+			healthChecker.Register(health.ReplicaCheck(replica))
+		}
+	}
+	HealthCtrl := controller.NewHealthCheckController(healthChecker)
 	router := Router(URLCtrl, HealthCtrl, sugar)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go worker.StartDeletionWorker(ctx)
+	metricsServer, err := startMetricsServer(serverConfig, sugar, snapshotWorker)
+	if err != nil {
+		sugar.Errorf("Server error: %v", err)
+		return err
+	}
+	ctx, cancelWorkers := context.WithCancel(context.Background())
+	defer cancelWorkers()
+	deletionDone := make(chan struct{})
+	go func() {
+		defer close(deletionDone)
+		worker.StartDeletionWorker(ctx)
+	}()
 	go worker.StartErrorListener(ctx)
+	if serverConfig.PurgeRetention > 0 {
+		go workers.InitURLPurgeWorker(shortenerService, serverConfig, sugar).StartPurgeWorker(ctx)
+	}
+	if serverConfig.DatabaseDSN != "" {
+		// Другие экземпляры сервиса с той же БД сообщают об изменениях URL, которые надо сбросить из локальных кэшей.
+		handler := urlEventHandler{urlCache: urlCache, shortener: shortenerService, logger: sugar}
+		go workers.InitURLEventListener(serverConfig.DatabaseDSN, handler, sugar).StartURLEventListener(ctx)
+	}
+	if replicas != nil {
+		go workers.InitReplicaMonitor(replicas, serverConfig, sugar).StartReplicaMonitor(ctx)
+	}
+	if serverConfig.BloomFalsePositiveRate > 0 {
+		go workers.InitShortURLFilterWorker(shortenerService, serverConfig, sugar).StartShortURLFilterWorker(ctx)
+	}
+	if snapshotWorker != nil {
+		go snapshotWorker.StartSnapshotWorker(ctx)
+		go triggerSnapshotOnSignal(ctx, snapshotWorker)
+	}
 	server := &http.Server{
 		Addr:    serverConfig.ServerAddress,
 		Handler: router,
@@ -130,6 +446,22 @@ func Run() error {
 		log.Fatalf("Server Shutdown Failed:%+v", err)
 		return err
 	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			sugar.Errorf("Metrics server shutdown failed: %v", err)
+		}
+	}
+	// Новых запросов на удаление больше нет: фоновые процессы останавливаются, а принятые
+	// удаления и восстановления выполняются до закрытия хранилища.
+	cancelWorkers()
+	<-deletionDone
+	// Последний снимок сохраняется после остановки процесса удаления, чтобы в него попали
+	// все принятые удаления и восстановления.
+	if snapshotWorker != nil {
+		if _, err := snapshotWorker.Snapshot(context.Background()); err != nil {
+			sugar.Errorf("Final snapshot failed: %v", err)
+		}
+	}
 	log.Println("Server exited properly")
 	return nil
 }
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package server
+
+import "os"
+
+// snapshotSignals на платформах без SIGUSR1 снимок по сигналу не сохраняется.
+var snapshotSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// snapshotSignals сигналы, по которым сохраняется внеплановый снимок хранилища в памяти.
+var snapshotSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/cache"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+)
+
+// urlEventHandler применяет события других экземпляров сервиса к кэшу URL и фильтру коротких адресов.
+type urlEventHandler struct {
+	urlCache  *cache.URLCache              // Кэш поиска URL; nil - кэш отключен.
+	shortener *service.URLShortenerService // Сервис с фильтром коротких адресов.
+	logger    *logger.Logger               // Логгер для регистрации событий.
+}
+
+// HandleURLEvent сбрасывает записи кэша затронутых URL; новые адреса добавляются в фильтр.
+// Событие неизвестного вида сбрасывает все кэши.
+func (h urlEventHandler) HandleURLEvent(ctx context.Context, event models.URLEvent) {
+	switch event.Op {
+	case models.URLEventSaved:
+		h.shortener.RememberShortURLs(event.ShortURLs...)
+		h.invalidate(event.ShortURLs)
+	case models.URLEventChanged:
+		h.invalidate(event.ShortURLs)
+	default:
+		h.Flush(ctx)
+	}
+}
+
+// Flush сбрасывает кэш URL и перестраивает фильтр коротких адресов в фоне:
+// адреса, сохраненные во время перестроения, в фильтре не теряются. После перестроения
+// фильтр снова отсекает адреса.
+func (h urlEventHandler) Flush(ctx context.Context) {
+	if h.urlCache != nil {
+		h.urlCache.Purge()
+	}
+	h.shortener.ResumeShortURLFilter()
+	go func() {
+		if _, err := h.shortener.RebuildShortURLFilter(ctx); err != nil {
+			h.logger.Errorf("Error rebuilding short URL filter after URL events gap: %v", err)
+		}
+	}()
+}
+
+// Disconnected отключает фильтр коротких адресов: пока подписки нет, адреса, сохраненные
+// другими экземплярами, в него не попадают.
+func (h urlEventHandler) Disconnected(ctx context.Context) {
+	h.shortener.SuspendShortURLFilter()
+}
+
+// invalidate удаляет из кэша записи по коротким адресам.
+func (h urlEventHandler) invalidate(shortURLs []string) {
+	if h.urlCache != nil {
+		h.urlCache.Invalidate(shortURLs...)
+	}
+}
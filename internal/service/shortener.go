@@ -2,55 +2,87 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/bloom"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
 	"github.com/romanyakovlev/go-yandex-url-shortener/pkg/utils"
 )
 
+const (
+	exportPageSize  = 500 // Количество URL, читаемых из хранилища за раз при выгрузке.
+	importBatchSize = 100 // Количество URL, сохраняемых за одно обращение к хранилищу при загрузке.
+)
+
+// shortURLPattern формат короткого адреса, который принимает маршрутизатор.
+var shortURLPattern = regexp.MustCompile(`^[A-Za-z]{8}$`)
+
 // URLRepository определяет интерфейс для работы с хранилищем URL.
 type URLRepository interface {
-	Save(models.URLToSave) (uuid.UUID, error)              // Save сохраняет URL.
-	BatchSave([]models.URLToSave) ([]uuid.UUID, error)     // BatchSave сохраняет список URL.
-	BatchDelete(urls []string, userID uuid.UUID) error     // BatchDelete удаляет список URL.
-	Find(shortURL string) (models.URLRow, bool)            // Find выполняет поиск URL по короткому адресу.
-	FindByUserID(userID uuid.UUID) ([]models.URLRow, bool) // FindByUserID ищет все URL, принадлежащие пользователю.
-	FindByOriginalURL(originalURL string) (string, bool)   // FindByOriginalURL ищет URL по оригинальному адресу.
+	Save(ctx context.Context, url models.URLToSave) (uuid.UUID, error)                                                           // Save сохраняет URL.
+	BatchSave(ctx context.Context, urls []models.URLToSave) ([]uuid.UUID, error)                                                 // BatchSave сохраняет список URL.
+	BatchDelete(ctx context.Context, urls []string, userID uuid.UUID) error                                                      // BatchDelete удаляет список URL.
+	BatchDeleteByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error                                             // BatchDeleteByUsers удаляет URL нескольких пользователей разом.
+	BatchRestoreByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error                                            // BatchRestoreByUsers восстанавливает удаленные URL нескольких пользователей разом.
+	Find(ctx context.Context, shortURL string) (models.URLRow, bool, error)                                                      // Find выполняет поиск URL по короткому адресу; ошибка означает сбой хранилища, а не отсутствие URL.
+	FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.URLRow, error)                                                 // FindByUserID ищет все URL пользователя.
+	FindByUserIDWithOptions(ctx context.Context, userID uuid.UUID, opts models.URLQueryOptions) ([]models.URLRow, string, error) // FindByUserIDWithOptions выбирает страницу URL пользователя.
+	FindByOriginalURL(ctx context.Context, originalURL string, userID uuid.UUID) (string, bool)                                  // FindByOriginalURL ищет URL по оригинальному адресу.
+	UpdateOriginalURL(ctx context.Context, shortURL string, userID uuid.UUID, originalURL string) (models.URLRow, error)         // UpdateOriginalURL меняет исходный URL пользователя.
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time, batchSize int) (int, error)                                       // PurgeDeleted окончательно стирает URL, удаленные раньше deletedBefore.
+	ForEachShortURL(ctx context.Context, fn func(shortURL string)) error                                                         // ForEachShortURL перебирает короткие адреса всех URL, включая удаленные.
 }
 
 // UserRepository определяет интерфейс для работы с хранилищем пользователей.
 type UserRepository interface {
-	UpdateUser(SavedURLUUID uuid.UUID, userID uuid.UUID) error         // UpdateUser привязывает URL к пользователю.
-	UpdateBatchUser(SavedURLUUIDs []uuid.UUID, userID uuid.UUID) error // UpdateBatchUser привязывает список URL к пользователю.
+	UpdateUser(ctx context.Context, SavedURLUUID uuid.UUID, userID uuid.UUID) error         // UpdateUser привязывает URL к пользователю.
+	UpdateBatchUser(ctx context.Context, SavedURLUUIDs []uuid.UUID, userID uuid.UUID) error // UpdateBatchUser привязывает список URL к пользователю.
 }
 
 // URLShortenerService предоставляет методы для работы с сокращением URL.
 type URLShortenerService struct {
-	config   config.Config  // Конфигурация сервиса.
-	urlRepo  URLRepository  // Репозиторий для работы с URL.
-	userRepo UserRepository // Репозиторий для работы с пользователями.
+	config    config.Config  // Конфигурация сервиса.
+	urlRepo   URLRepository  // Репозиторий для работы с URL.
+	userRepo  UserRepository // Репозиторий для работы с пользователями.
+	shortURLs *bloom.Index   // Фильтр существующих коротких адресов; nil - фильтр отключен.
 }
 
-// AddURL сокращает одиночный URL.
-func (s URLShortenerService) AddURL(urlStr string) (models.SavedURL, error) {
+// AddURL сокращает одиночный URL от имени пользователя.
+func (s URLShortenerService) AddURL(ctx context.Context, urlStr string, user models.User) (models.SavedURL, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.AddURL")
+	defer span.End()
+
 	randomPath := utils.RandStringBytes(8)
-	UUID, err := s.urlRepo.Save(models.URLToSave{RandomPath: randomPath, URLStr: urlStr})
+	s.RememberShortURLs(randomPath)
+	UUID, err := s.urlRepo.Save(ctx, models.URLToSave{RandomPath: randomPath, URLStr: urlStr, UserID: user.UUID})
 	if err != nil {
 		return models.SavedURL{}, err
 	}
 	return models.SavedURL{UUID: UUID, ShortURL: s.config.BaseURL + "/" + randomPath}, nil
 }
 
-// AddBatchURL сокращает список URL.
-func (s URLShortenerService) AddBatchURL(batchArray []models.ShortenBatchURLRequestElement) ([]models.CorrelationSavedURL, error) {
+// AddBatchURL сокращает список URL от имени пользователя.
+func (s URLShortenerService) AddBatchURL(ctx context.Context, batchArray []models.ShortenBatchURLRequestElement, user models.User) ([]models.CorrelationSavedURL, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.AddBatchURL")
+	defer span.End()
+
 	var batchToSave []models.URLToSave
 	for _, elem := range batchArray {
 		randomPath := utils.RandStringBytes(8)
-		batchToSave = append(batchToSave, models.URLToSave{RandomPath: randomPath, URLStr: elem.OriginalURL})
+		s.RememberShortURLs(randomPath)
+		batchToSave = append(batchToSave, models.URLToSave{RandomPath: randomPath, URLStr: elem.OriginalURL, UserID: user.UUID})
 	}
 
-	UUIDs, err := s.urlRepo.BatchSave(batchToSave)
+	UUIDs, err := s.urlRepo.BatchSave(ctx, batchToSave)
 	if err != nil {
 		return nil, err
 	}
@@ -71,8 +103,11 @@ func (s URLShortenerService) AddBatchURL(batchArray []models.ShortenBatchURLRequ
 }
 
 // AddUserToURL привязывает URL к пользователю.
-func (s URLShortenerService) AddUserToURL(SavedURL models.SavedURL, user models.User) error {
-	err := s.userRepo.UpdateUser(SavedURL.UUID, user.UUID)
+func (s URLShortenerService) AddUserToURL(ctx context.Context, SavedURL models.SavedURL, user models.User) error {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.AddUserToURL")
+	defer span.End()
+
+	err := s.userRepo.UpdateUser(ctx, SavedURL.UUID, user.UUID)
 	if err != nil {
 		return err
 	}
@@ -80,50 +115,327 @@ func (s URLShortenerService) AddUserToURL(SavedURL models.SavedURL, user models.
 }
 
 // AddBatchUserToURL привязывает список URL к пользователю.
-func (s URLShortenerService) AddBatchUserToURL(SavedURLs []models.SavedURL, user models.User) error {
+func (s URLShortenerService) AddBatchUserToURL(ctx context.Context, SavedURLs []models.SavedURL, user models.User) error {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.AddBatchUserToURL")
+	defer span.End()
+
 	var UUIDs []uuid.UUID
 	for _, savedURL := range SavedURLs {
 		UUIDs = append(UUIDs, savedURL.UUID)
 	}
 
-	err := s.userRepo.UpdateBatchUser(UUIDs, user.UUID)
+	err := s.userRepo.UpdateBatchUser(ctx, UUIDs, user.UUID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// GetURL возвращает оригинальный URL по сокращенному адресу.
-func (s URLShortenerService) GetURL(shortURL string) (models.URLRow, bool) {
-	row, ok := s.urlRepo.Find(shortURL)
-	return row, ok
+// GetURL возвращает оригинальный URL по сокращенному адресу. Адреса, которых точно нет
+// в фильтре, не ищутся в хранилище; найденные в хранилище адреса добавляются в фильтр,
+// если он о них еще не знал.
+func (s URLShortenerService) GetURL(ctx context.Context, shortURL string) (models.URLRow, bool, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.GetURL")
+	defer span.End()
+
+	if s.shortURLs != nil && !s.shortURLs.MayContain(shortURL) {
+		return models.URLRow{}, false, nil
+	}
+	urlRow, found, err := s.urlRepo.Find(ctx, shortURL)
+	if found && s.shortURLs != nil {
+		s.shortURLs.Remember(shortURL)
+	}
+	return urlRow, found, err
 }
 
 // GetURLByUser возвращает список URL, принадлежащих пользователю.
-func (s URLShortenerService) GetURLByUser(user models.User) ([]models.URLByUserResponseElement, bool) {
+func (s URLShortenerService) GetURLByUser(ctx context.Context, user models.User) ([]models.URLByUserResponseElement, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.GetURLByUser")
+	defer span.End()
+
+	URLRows, err := s.urlRepo.FindByUserID(ctx, user.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return s.convertURLRowsToResponse(URLRows), nil
+}
+
+// GetURLByUserPage возвращает страницу URL пользователя с сортировкой и фильтрами
+// и курсор следующей страницы; пустой курсор означает, что страница последняя.
+func (s URLShortenerService) GetURLByUserPage(ctx context.Context, user models.User, opts models.URLQueryOptions) ([]models.URLByUserResponseElement, string, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.GetURLByUserPage")
+	defer span.End()
+
+	URLRows, nextCursor, err := s.urlRepo.FindByUserIDWithOptions(ctx, user.UUID, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.convertURLRowsToResponse(URLRows), nextCursor, nil
+}
+
+// convertURLRowsToResponse конвертирует строки URL в элементы ответа со списком URL пользователя.
+func (s URLShortenerService) convertURLRowsToResponse(URLRows []models.URLRow) []models.URLByUserResponseElement {
 	respElements := []models.URLByUserResponseElement{}
-	URLRows, ok := s.urlRepo.FindByUserID(user.UUID)
 	for _, URLRow := range URLRows {
 		respElements = append(respElements, models.URLByUserResponseElement{
 			ShortURL:    s.config.BaseURL + "/" + URLRow.ShortURL,
 			OriginalURL: URLRow.OriginalURL,
+			CreatedAt:   URLRow.CreatedAt,
+			UpdatedAt:   URLRow.UpdatedAt,
+			DeletedAt:   URLRow.DeletedAt,
 		})
 	}
-	return respElements, ok
+	return respElements
 }
 
-// GetURLByOriginalURL возвращает сокращенный URL по оригинальному адресу.
-func (s URLShortenerService) GetURLByOriginalURL(originalURL string) (string, bool) {
-	randomPath, ok := s.urlRepo.FindByOriginalURL(originalURL)
+// GetURLByOriginalURL возвращает сокращенный URL по оригинальному адресу, видимый пользователю.
+func (s URLShortenerService) GetURLByOriginalURL(ctx context.Context, originalURL string, user models.User) (string, bool) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.GetURLByOriginalURL")
+	defer span.End()
+
+	randomPath, ok := s.urlRepo.FindByOriginalURL(ctx, originalURL, user.UUID)
 	return s.config.BaseURL + "/" + randomPath, ok
 }
 
+// UpdateURL меняет исходный URL сокращенной ссылки, принадлежащей пользователю.
+// Прежний исходный URL сохраняется в истории ссылки.
+func (s URLShortenerService) UpdateURL(ctx context.Context, shortURL string, originalURL string, user models.User) (models.UpdateURLResponse, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.UpdateURL")
+	defer span.End()
+
+	urlRow, err := s.urlRepo.UpdateOriginalURL(ctx, shortURL, user.UUID, originalURL)
+	if err != nil {
+		return models.UpdateURLResponse{}, err
+	}
+	history := urlRow.History
+	if history == nil {
+		history = []models.URLHistoryRecord{}
+	}
+	return models.UpdateURLResponse{
+		ShortURL:    s.config.BaseURL + "/" + urlRow.ShortURL,
+		OriginalURL: urlRow.OriginalURL,
+		UpdatedAt:   urlRow.UpdatedAt,
+		History:     history,
+	}, nil
+}
+
 // DeleteBatchURL удаляет список URL, принадлежащих пользователю.
-func (s URLShortenerService) DeleteBatchURL(urls []string, user models.User) error {
-	err := s.urlRepo.BatchDelete(urls, user.UUID)
+func (s URLShortenerService) DeleteBatchURL(ctx context.Context, urls []string, user models.User) error {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.DeleteBatchURL")
+	defer span.End()
+
+	err := s.urlRepo.BatchDelete(ctx, urls, user.UUID)
 	return err
 }
 
+// DeleteBatchURLByUsers удаляет списки URL сразу нескольких пользователей.
+func (s URLShortenerService) DeleteBatchURLByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.DeleteBatchURLByUsers")
+	defer span.End()
+
+	return s.urlRepo.BatchDeleteByUsers(ctx, urlsByUser)
+}
+
+// RestoreBatchURLByUsers восстанавливает удаленные URL сразу нескольких пользователей.
+// URL, окончательно удаленные из хранилища или сокращенные заново, не восстанавливаются.
+func (s URLShortenerService) RestoreBatchURLByUsers(ctx context.Context, urlsByUser map[uuid.UUID][]string) error {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.RestoreBatchURLByUsers")
+	defer span.End()
+
+	return s.urlRepo.BatchRestoreByUsers(ctx, urlsByUser)
+}
+
+// PurgeDeletedURLs окончательно стирает URL, удаленные больше retention назад, пачками по batchSize.
+// Возвращает количество стертых URL.
+func (s URLShortenerService) PurgeDeletedURLs(ctx context.Context, retention time.Duration, batchSize int) (int, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.PurgeDeletedURLs")
+	defer span.End()
+
+	return s.urlRepo.PurgeDeleted(ctx, time.Now().UTC().Add(-retention), batchSize)
+}
+
+// RebuildShortURLFilter заново строит фильтр существующих коротких адресов по хранилищу,
+// чтобы стертые URL перестали в нем числиться. Возвращает количество адресов в фильтре.
+func (s URLShortenerService) RebuildShortURLFilter(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.RebuildShortURLFilter")
+	defer span.End()
+
+	if s.shortURLs == nil {
+		return 0, nil
+	}
+	return s.shortURLs.Rebuild(ctx, s.urlRepo.ForEachShortURL)
+}
+
+// SuspendShortURLFilter перестает отсекать адреса фильтром: адреса, сохраненные другими
+// экземплярами сервиса, могут не дойти до него, пока нет подписки на их события.
+func (s URLShortenerService) SuspendShortURLFilter() {
+	if s.shortURLs != nil {
+		s.shortURLs.Suspend()
+	}
+}
+
+// ResumeShortURLFilter сообщает, что события других экземпляров снова доходят до фильтра.
+// Фильтр начнет отсекать адреса после следующего RebuildShortURLFilter.
+func (s URLShortenerService) ResumeShortURLFilter() {
+	if s.shortURLs != nil {
+		s.shortURLs.Resume()
+	}
+}
+
+// RememberShortURLs добавляет короткие адреса в фильтр. Сервис вызывает его до сохранения,
+// чтобы сохраненный URL ни на мгновение не считался несуществующим; адреса, сохраненные
+// другими экземплярами сервиса, добавляются по их событиям.
+func (s URLShortenerService) RememberShortURLs(shortURLs ...string) {
+	if s.shortURLs == nil {
+		return
+	}
+	for _, shortURL := range shortURLs {
+		s.shortURLs.Add(shortURL)
+	}
+}
+
+// ExportURLs передает в fn все URL пользователя в порядке создания.
+// URL читаются из хранилища страницами, поэтому весь список не держится в памяти.
+func (s URLShortenerService) ExportURLs(ctx context.Context, user models.User, fn func(models.ExportedURL) error) error {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.ExportURLs")
+	defer span.End()
+
+	opts := models.URLQueryOptions{Limit: exportPageSize, SortBy: models.SortByCreatedAt}
+	for {
+		URLRows, nextCursor, err := s.urlRepo.FindByUserIDWithOptions(ctx, user.UUID, opts)
+		if err != nil {
+			return err
+		}
+		for _, URLRow := range URLRows {
+			if err := fn(models.ExportedURL{
+				ShortURL:    s.config.BaseURL + "/" + URLRow.ShortURL,
+				OriginalURL: URLRow.OriginalURL,
+				Deleted:     URLRow.DeletedFlag,
+				CreatedAt:   URLRow.CreatedAt,
+				UpdatedAt:   URLRow.UpdatedAt,
+				DeletedAt:   URLRow.DeletedAt,
+			}); err != nil {
+				return err
+			}
+		}
+		if nextCursor == "" {
+			return nil
+		}
+		opts.Cursor = nextCursor
+	}
+}
+
+// ImportURLs сохраняет URL пользователя из выгрузки через пакетное сохранение и возвращает результат по каждой строке.
+// Короткие адреса сохраняются, если они корректны и свободны, иначе URL получает новый адрес.
+// Уже сокращенные URL не сохраняются повторно, а удаленные пропускаются.
+func (s URLShortenerService) ImportURLs(ctx context.Context, user models.User, records []models.ExportedURL) ([]models.ImportURLResult, error) {
+	ctx, span := tracing.Start(ctx, "URLShortenerService.ImportURLs")
+	defer span.End()
+
+	results := make([]models.ImportURLResult, len(records))
+	seen := make(map[string]bool, len(records))
+	var pending []int
+	for i, record := range records {
+		results[i] = models.ImportURLResult{Row: i + 1, OriginalURL: record.OriginalURL}
+		switch {
+		case record.OriginalURL == "":
+			results[i].Status = models.ImportStatusInvalid
+			results[i].Error = "original_url is empty"
+			continue
+		case record.Deleted:
+			results[i].Status = models.ImportStatusSkipped
+			continue
+		}
+
+		shortURL := record.ShortURL[strings.LastIndex(record.ShortURL, "/")+1:]
+		results[i].Status = models.ImportStatusImported
+		if !shortURLPattern.MatchString(shortURL) || seen[shortURL] {
+			shortURL = utils.RandStringBytes(8)
+			results[i].Status = models.ImportStatusRenamed
+		}
+		seen[shortURL] = true
+		results[i].ShortURL = shortURL
+		pending = append(pending, i)
+	}
+
+	for start := 0; start < len(pending); start += importBatchSize {
+		end := min(start+importBatchSize, len(pending))
+		if err := s.importBatch(ctx, user, results, pending[start:end]); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range results {
+		if results[i].ShortURL != "" {
+			results[i].ShortURL = s.config.BaseURL + "/" + results[i].ShortURL
+		}
+	}
+	return results, nil
+}
+
+// importBatch сохраняет пачку строк загрузки одним обращением к хранилищу.
+// Строки, на которых пакетное сохранение споткнулось, исключаются из пачки или получают
+// новый короткий адрес, после чего сохранение повторяется.
+func (s URLShortenerService) importBatch(ctx context.Context, user models.User, results []models.ImportURLResult, batch []int) error {
+	for len(batch) > 0 {
+		batchToSave := make([]models.URLToSave, len(batch))
+		for j, i := range batch {
+			batchToSave[j] = models.URLToSave{RandomPath: results[i].ShortURL, URLStr: results[i].OriginalURL, UserID: user.UUID}
+			s.RememberShortURLs(results[i].ShortURL)
+		}
+
+		_, err := s.urlRepo.BatchSave(ctx, batchToSave)
+		var originalErr *apperrors.OriginalURLAlreadyExists
+		var shortErr *apperrors.ShortURLAlreadyExists
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &originalErr):
+			// Если URL не найден среди сохраненных, а в пачке встречается несколько раз,
+			// конфликт возник внутри самой загрузки и первая его строка сохраняется.
+			existing, found := s.urlRepo.FindByOriginalURL(ctx, originalErr.URL, user.UUID)
+			keepFirst := !found && countImportRows(results, batch, originalErr.URL) > 1
+			var remaining []int
+			for _, i := range batch {
+				if results[i].OriginalURL != originalErr.URL {
+					remaining = append(remaining, i)
+					continue
+				}
+				if keepFirst {
+					keepFirst, existing = false, results[i].ShortURL
+					remaining = append(remaining, i)
+					continue
+				}
+				results[i].Status = models.ImportStatusConflict
+				results[i].Error = originalErr.Error()
+				results[i].ShortURL = existing
+			}
+			batch = remaining
+		case errors.As(err, &shortErr):
+			for _, i := range batch {
+				if results[i].ShortURL == shortErr.ShortURL {
+					results[i].ShortURL = utils.RandStringBytes(8)
+					results[i].Status = models.ImportStatusRenamed
+				}
+			}
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// countImportRows возвращает количество строк пачки с указанным исходным URL.
+func countImportRows(results []models.ImportURLResult, batch []int, originalURL string) int {
+	count := 0
+	for _, i := range batch {
+		if results[i].OriginalURL == originalURL {
+			count++
+		}
+	}
+	return count
+}
+
 // ConvertCorrelationSavedURLsToResponse конвертирует сохраненные URL с корреляционными идентификаторами в формат ответа.
 func (s URLShortenerService) ConvertCorrelationSavedURLsToResponse(correlationSavedURLs []models.CorrelationSavedURL) []models.ShortenBatchURLResponseElement {
 	var responseElements []models.ShortenBatchURLResponseElement
@@ -151,10 +463,20 @@ func (s URLShortenerService) ConvertCorrelationSavedURLsToSavedURL(correlationSa
 }
 
 // NewURLShortenerService создает новый экземпляр сервиса сокращения URL.
+// Если задана вероятность ложноположительного ответа, создается фильтр коротких адресов;
+// до первого RebuildShortURLFilter он не отсекает ни одного адреса. С БД, общей для нескольких
+// экземпляров, фильтр к тому же отключен до ResumeShortURLFilter.
 func NewURLShortenerService(config config.Config, urlRepo URLRepository, userRepo UserRepository) *URLShortenerService {
-	return &URLShortenerService{
+	s := &URLShortenerService{
 		config:   config,
 		urlRepo:  urlRepo,
 		userRepo: userRepo,
 	}
+	if config.BloomFalsePositiveRate > 0 && config.BloomFalsePositiveRate < 1 {
+		s.shortURLs = bloom.NewIndex(config.BloomFalsePositiveRate)
+		if config.DatabaseDSN != "" {
+			s.shortURLs.Suspend()
+		}
+	}
+	return s
 }
@@ -1,11 +1,15 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
@@ -13,7 +17,7 @@ import (
 
 func setupURLShortenerService() (*URLShortenerService, *models.SharedURLRows) {
 	sharedURLRows := models.NewSharedURLRows() // Assumes NewSharedURLRows initializes a mutex.
-	urlRepo, _ := repository.NewMemoryURLRepository(sharedURLRows)
+	urlRepo, _ := repository.NewMemoryURLRepository(sharedURLRows, repository.DedupScopeGlobal)
 	userRepo, _ := repository.NewMemoryUserRepository(sharedURLRows)
 	service := NewURLShortenerService(config.Config{BaseURL: "http://localhost:8000"}, urlRepo, userRepo)
 	return service, sharedURLRows
@@ -23,7 +27,7 @@ func TestAddURL(t *testing.T) {
 	service, _ := setupURLShortenerService()
 
 	originalURL := "http://practicum.yandex.ru/example"
-	savedURL, err := service.AddURL(originalURL)
+	savedURL, err := service.AddURL(context.Background(), originalURL, models.User{})
 
 	assert.NoError(t, err)
 	assert.Contains(t, savedURL.ShortURL, service.config.BaseURL)
@@ -33,10 +37,11 @@ func TestGetURL(t *testing.T) {
 	service, _ := setupURLShortenerService()
 
 	originalURL := "http://practicum.yandex.ru/example"
-	savedURL, err := service.AddURL(originalURL)
+	savedURL, err := service.AddURL(context.Background(), originalURL, models.User{})
 	assert.NoError(t, err)
 
-	foundURL, found := service.GetURL(savedURL.ShortURL[len(savedURL.ShortURL)-8:])
+	foundURL, found, err := service.GetURL(context.Background(), savedURL.ShortURL[len(savedURL.ShortURL)-8:])
+	require.NoError(t, err)
 	assert.True(t, found)
 	assert.Equal(t, originalURL, foundURL.OriginalURL)
 }
@@ -49,7 +54,7 @@ func TestAddBatchURL(t *testing.T) {
 		{CorrelationID: "2", OriginalURL: "http://practicum.yandex.ru/example2"},
 	}
 
-	batchToReturn, err := service.AddBatchURL(batchArray)
+	batchToReturn, err := service.AddBatchURL(context.Background(), batchArray, models.User{})
 	assert.NoError(t, err)
 	assert.Equal(t, len(batchArray), len(batchToReturn))
 
@@ -63,14 +68,15 @@ func TestAddUserToURL(t *testing.T) {
 	service, _ := setupURLShortenerService()
 
 	originalURL := "http://practicum.yandex.ru/example"
-	savedURL, err := service.AddURL(originalURL)
+	savedURL, err := service.AddURL(context.Background(), originalURL, models.User{})
 	assert.NoError(t, err)
 
 	user := models.User{UUID: uuid.New()}
-	err = service.AddUserToURL(savedURL, user)
+	err = service.AddUserToURL(context.Background(), savedURL, user)
 	assert.NoError(t, err)
 
-	urlRow, found := service.urlRepo.Find(savedURL.ShortURL[len(savedURL.ShortURL)-8:])
+	urlRow, found, err := service.urlRepo.Find(context.Background(), savedURL.ShortURL[len(savedURL.ShortURL)-8:])
+	require.NoError(t, err)
 	assert.True(t, found)
 	assert.Equal(t, user.UUID, urlRow.UserID)
 }
@@ -83,7 +89,7 @@ func TestDeleteBatchURL(t *testing.T) {
 		{CorrelationID: "2", OriginalURL: "http://practicum.yandex.ru/example2"},
 	}
 
-	batchToReturn, err := service.AddBatchURL(batchArray)
+	batchToReturn, err := service.AddBatchURL(context.Background(), batchArray, models.User{})
 	assert.NoError(t, err)
 
 	var shortURLs []string
@@ -92,14 +98,264 @@ func TestDeleteBatchURL(t *testing.T) {
 	}
 
 	user := models.User{UUID: uuid.New()}
-	err = service.DeleteBatchURL(shortURLs, user)
+	err = service.DeleteBatchURL(context.Background(), shortURLs, user)
 	assert.NoError(t, err)
 	/*
 		for _, shortURL := range shortURLs {
-			urlRow, found := service.urlRepo.Find(shortURL)
+			urlRow, found := service.urlRepo.Find(context.Background(), shortURL)
 			assert.True(t, found)
 			assert.True(t, urlRow.DeletedFlag)
 		}
 
 	*/
 }
+
+func TestAddURLDedupScope(t *testing.T) {
+	testCases := []struct {
+		scope             string
+		sameUserConflict  bool
+		otherUserConflict bool
+	}{
+		{scope: repository.DedupScopeGlobal, sameUserConflict: true, otherUserConflict: true},
+		{scope: repository.DedupScopeUser, sameUserConflict: true, otherUserConflict: false},
+		{scope: repository.DedupScopeNone, sameUserConflict: false, otherUserConflict: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.scope, func(t *testing.T) {
+			sharedURLRows := models.NewSharedURLRows()
+			urlRepo, err := repository.NewMemoryURLRepository(sharedURLRows, tc.scope)
+			assert.NoError(t, err)
+			userRepo, _ := repository.NewMemoryUserRepository(sharedURLRows)
+			service := NewURLShortenerService(config.Config{BaseURL: "http://localhost:8000"}, urlRepo, userRepo)
+
+			originalURL := "http://practicum.yandex.ru/example"
+			owner := models.User{UUID: uuid.New()}
+			other := models.User{UUID: uuid.New()}
+
+			_, err = service.AddURL(context.Background(), originalURL, owner)
+			assert.NoError(t, err)
+
+			var conflictErr *apperrors.OriginalURLAlreadyExists
+			_, err = service.AddURL(context.Background(), originalURL, owner)
+			assert.Equal(t, tc.sameUserConflict, errors.As(err, &conflictErr))
+
+			_, err = service.AddURL(context.Background(), originalURL, other)
+			assert.Equal(t, tc.otherUserConflict, errors.As(err, &conflictErr))
+		})
+	}
+}
+
+func TestUpdateURL(t *testing.T) {
+	service, _ := setupURLShortenerService()
+	ctx := context.Background()
+	owner := models.User{UUID: uuid.New()}
+	other := models.User{UUID: uuid.New()}
+
+	savedURL, err := service.AddURL(ctx, "http://practicum.yandex.ru/typo", owner)
+	assert.NoError(t, err)
+	_, err = service.AddURL(ctx, "http://practicum.yandex.ru/taken", other)
+	assert.NoError(t, err)
+	shortURL := savedURL.ShortURL[len(savedURL.ShortURL)-8:]
+
+	resp, err := service.UpdateURL(ctx, shortURL, "http://practicum.yandex.ru/fixed", owner)
+	assert.NoError(t, err)
+	assert.Equal(t, savedURL.ShortURL, resp.ShortURL)
+	assert.Equal(t, "http://practicum.yandex.ru/fixed", resp.OriginalURL)
+	if assert.Len(t, resp.History, 1) {
+		assert.Equal(t, "http://practicum.yandex.ru/typo", resp.History[0].OriginalURL)
+	}
+
+	foundURL, found, err := service.GetURL(ctx, shortURL)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "http://practicum.yandex.ru/fixed", foundURL.OriginalURL)
+
+	var accessErr *apperrors.URLAccessDenied
+	_, err = service.UpdateURL(ctx, shortURL, "http://practicum.yandex.ru/other", other)
+	assert.ErrorAs(t, err, &accessErr)
+
+	var conflictErr *apperrors.OriginalURLAlreadyExists
+	_, err = service.UpdateURL(ctx, shortURL, "http://practicum.yandex.ru/taken", owner)
+	assert.ErrorAs(t, err, &conflictErr)
+
+	var notFoundErr *apperrors.URLNotFound
+	_, err = service.UpdateURL(ctx, "missing0", "http://practicum.yandex.ru/fixed", owner)
+	assert.ErrorAs(t, err, &notFoundErr)
+
+	err = service.DeleteBatchURL(ctx, []string{shortURL}, owner)
+	assert.NoError(t, err)
+	var deletedErr *apperrors.URLIsDeleted
+	_, err = service.UpdateURL(ctx, shortURL, "http://practicum.yandex.ru/again", owner)
+	assert.ErrorAs(t, err, &deletedErr)
+}
+
+func TestGetURLByUserPage(t *testing.T) {
+	service, _ := setupURLShortenerService()
+	ctx := context.Background()
+	user := models.User{UUID: uuid.New()}
+
+	batchArray := []models.ShortenBatchURLRequestElement{
+		{CorrelationID: "1", OriginalURL: "http://practicum.yandex.ru/c"},
+		{CorrelationID: "2", OriginalURL: "http://practicum.yandex.ru/a"},
+		{CorrelationID: "3", OriginalURL: "http://yandex.ru/e"},
+		{CorrelationID: "4", OriginalURL: "http://practicum.yandex.ru/b"},
+		{CorrelationID: "5", OriginalURL: "http://practicum.yandex.ru/d"},
+	}
+	_, err := service.AddBatchURL(ctx, batchArray, user)
+	assert.NoError(t, err)
+	_, err = service.AddURL(ctx, "http://practicum.yandex.ru/other", models.User{UUID: uuid.New()})
+	assert.NoError(t, err)
+
+	opts := models.URLQueryOptions{Limit: 2, SortBy: models.SortByOriginalURL, Descending: true, Search: "practicum"}
+	var originalURLs []string
+	for page := 0; page < 3; page++ {
+		elements, nextCursor, err := service.GetURLByUserPage(ctx, user, opts)
+		assert.NoError(t, err)
+		for _, element := range elements {
+			originalURLs = append(originalURLs, element.OriginalURL)
+		}
+		if nextCursor == "" {
+			break
+		}
+		opts.Cursor = nextCursor
+	}
+	assert.Equal(t, []string{
+		"http://practicum.yandex.ru/d",
+		"http://practicum.yandex.ru/c",
+		"http://practicum.yandex.ru/b",
+		"http://practicum.yandex.ru/a",
+	}, originalURLs)
+
+	var optsErr *apperrors.InvalidQueryOptions
+	_, _, err = service.GetURLByUserPage(ctx, user, models.URLQueryOptions{SortBy: models.SortByCreatedAt, Cursor: opts.Cursor})
+	assert.ErrorAs(t, err, &optsErr)
+	_, _, err = service.GetURLByUserPage(ctx, user, models.URLQueryOptions{SortBy: "short_url"})
+	assert.ErrorAs(t, err, &optsErr)
+
+	deleted := true
+	elements, _, err := service.GetURLByUserPage(ctx, user, models.URLQueryOptions{Deleted: &deleted})
+	assert.NoError(t, err)
+	assert.Empty(t, elements)
+}
+
+func TestImportURLs(t *testing.T) {
+	service, _ := setupURLShortenerService()
+	ctx := context.Background()
+	user := models.User{UUID: uuid.New()}
+
+	existing, err := service.AddURL(ctx, "http://practicum.yandex.ru/existing", models.User{UUID: uuid.New()})
+	assert.NoError(t, err)
+	existingShortURL := existing.ShortURL[len(existing.ShortURL)-8:]
+
+	records := []models.ExportedURL{
+		{ShortURL: "http://old.host/AbcdEfgh", OriginalURL: "http://practicum.yandex.ru/kept"},
+		{ShortURL: existingShortURL, OriginalURL: "http://practicum.yandex.ru/taken-code"},
+		{ShortURL: "bad-code", OriginalURL: "http://practicum.yandex.ru/bad-code"},
+		{ShortURL: "QwertyUi", OriginalURL: "http://practicum.yandex.ru/existing"},
+		{ShortURL: "ZxcvbnMa", OriginalURL: "http://practicum.yandex.ru/deleted", Deleted: true},
+		{ShortURL: "PoiuytRe"},
+	}
+	results, err := service.ImportURLs(ctx, user, records)
+	assert.NoError(t, err)
+
+	var statuses []string
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []string{
+		models.ImportStatusImported,
+		models.ImportStatusRenamed,
+		models.ImportStatusRenamed,
+		models.ImportStatusConflict,
+		models.ImportStatusSkipped,
+		models.ImportStatusInvalid,
+	}, statuses)
+	assert.Equal(t, "http://localhost:8000/AbcdEfgh", results[0].ShortURL)
+	assert.NotEqual(t, existing.ShortURL, results[1].ShortURL)
+	assert.Equal(t, existing.ShortURL, results[3].ShortURL)
+
+	foundURL, found, err := service.GetURL(ctx, "AbcdEfgh")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "http://practicum.yandex.ru/kept", foundURL.OriginalURL)
+	assert.Equal(t, user.UUID, foundURL.UserID)
+
+	var exported []models.ExportedURL
+	err = service.ExportURLs(ctx, user, func(exportedURL models.ExportedURL) error {
+		exported = append(exported, exportedURL)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, exported, 3)
+}
+
+func TestGetURLShortURLFilter(t *testing.T) {
+	ctx := context.Background()
+	sharedURLRows := models.NewSharedURLRows()
+	urlRepo, err := repository.NewMemoryURLRepository(sharedURLRows, repository.DedupScopeGlobal)
+	require.NoError(t, err)
+	userRepo, _ := repository.NewMemoryUserRepository(sharedURLRows)
+	service := NewURLShortenerService(config.Config{BaseURL: "http://localhost:8000", BloomFalsePositiveRate: 0.01}, urlRepo, userRepo)
+
+	// Строка, записанная в хранилище до построения фильтра, попадает в него при перестроении.
+	_, err = urlRepo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/1"})
+	require.NoError(t, err)
+	size, err := service.RebuildShortURLFilter(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, size)
+	_, found, err := service.GetURL(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	// Строка, записанная в хранилище в обход сервиса после построения, отсекается фильтром.
+	_, err = urlRepo.Save(ctx, models.URLToSave{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/2"})
+	require.NoError(t, err)
+	_, found, err = service.GetURL(ctx, "bcdefghi")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// URL, сохраненные через сервис, сразу попадают в фильтр.
+	savedURL, err := service.AddURL(ctx, "http://practicum.yandex.ru/3", models.User{})
+	require.NoError(t, err)
+	_, found, err = service.GetURL(ctx, savedURL.ShortURL[len(savedURL.ShortURL)-8:])
+	require.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestGetURLShortURLFilterSharedStorage(t *testing.T) {
+	ctx := context.Background()
+	sharedURLRows := models.NewSharedURLRows()
+	urlRepo, err := repository.NewMemoryURLRepository(sharedURLRows, repository.DedupScopeGlobal)
+	require.NoError(t, err)
+	userRepo, _ := repository.NewMemoryUserRepository(sharedURLRows)
+	// С общей БД адреса сохраняют и другие экземпляры сервиса.
+	cfg := config.Config{BaseURL: "http://localhost:8000", BloomFalsePositiveRate: 0.01, DatabaseDSN: "postgres://shared"}
+	service := NewURLShortenerService(cfg, urlRepo, userRepo)
+	_, err = service.RebuildShortURLFilter(ctx)
+	require.NoError(t, err)
+
+	// Пока нет подписки на события других экземпляров, адрес, сохраненный мимо фильтра, находится.
+	_, err = urlRepo.Save(ctx, models.URLToSave{RandomPath: "abcdefgh", URLStr: "http://practicum.yandex.ru/1"})
+	require.NoError(t, err)
+	_, found, err := service.GetURL(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	// После подписки и перестроения фильтр отсекает неизвестные адреса, но помнит найденные ранее.
+	service.ResumeShortURLFilter()
+	_, err = service.RebuildShortURLFilter(ctx)
+	require.NoError(t, err)
+	_, found, err = service.GetURL(ctx, "abcdefgh")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.False(t, service.shortURLs.MayContain("zzzzzzzz"))
+
+	// Обрыв подписки снова отключает фильтр.
+	service.SuspendShortURLFilter()
+	_, err = urlRepo.Save(ctx, models.URLToSave{RandomPath: "bcdefghi", URLStr: "http://practicum.yandex.ru/2"})
+	require.NoError(t, err)
+	_, found, err = service.GetURL(ctx, "bcdefghi")
+	require.NoError(t, err)
+	assert.True(t, found)
+}
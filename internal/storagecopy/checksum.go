@@ -0,0 +1,61 @@
+package storagecopy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// checksum контрольная сумма набора строк, не зависящая от их порядка:
+// сумма по модулю 2^64 первых восьми байт SHA-256 каждой строки.
+type checksum struct {
+	count int
+	sum   uint64
+}
+
+// add добавляет строку в контрольную сумму.
+// Время округляется до микросекунд, с которыми его хранит Postgres.
+func (c *checksum) add(urlRow models.URLRow) {
+	deletedAt := "-"
+	if urlRow.DeletedAt != nil {
+		deletedAt = canonicalTime(*urlRow.DeletedAt)
+	}
+	h := sha256.New()
+	for _, field := range []string{
+		urlRow.UUID.String(),
+		urlRow.ShortURL,
+		urlRow.OriginalURL,
+		urlRow.UserID.String(),
+		strconv.FormatBool(urlRow.DeletedFlag),
+		canonicalTime(urlRow.CreatedAt),
+		canonicalTime(urlRow.UpdatedAt),
+		deletedAt,
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	c.count++
+	c.sum += binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// String возвращает контрольную сумму в шестнадцатеричном виде.
+func (c checksum) String() string {
+	return fmt.Sprintf("%016x", c.sum)
+}
+
+// canonicalTime приводит время к виду, одинаковому во всех хранилищах.
+func canonicalTime(t time.Time) string {
+	return strconv.FormatInt(t.Truncate(time.Microsecond).UnixMicro(), 10)
+}
+
+// sameRow проверяет, что строки совпадают с точностью, с которой их хранят все хранилища.
+func sameRow(a, b models.URLRow) bool {
+	var ca, cb checksum
+	ca.add(a)
+	cb.add(b)
+	return ca.sum == cb.sum
+}
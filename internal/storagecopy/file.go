@@ -0,0 +1,158 @@
+package storagecopy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+)
+
+// fileStorage файл в формате файлового хранилища или сжатый снимок хранилища в памяти.
+// Позиция чтения - номер последней прочитанной строки файла.
+type fileStorage struct {
+	path       string            // Путь к файлу.
+	rows       []models.URLRow   // Строки файла, загруженные при первой записи.
+	index      map[uuid.UUID]int // Номер строки в rows по UUID.
+	compressed bool              // Файл - сжатый снимок, который можно только перезаписать целиком.
+}
+
+// openFile открывает файл хранилища. Отсутствующий файл считается пустым и создается при первой записи.
+func openFile(path string) (*fileStorage, error) {
+	return &fileStorage{path: path}, nil
+}
+
+// Read читает файл построчно, не загружая его целиком. Нераскодируемые строки пропускаются,
+// как и при работе файлового хранилища.
+func (s *fileStorage) Read(ctx context.Context, after string, batchSize int, fn func(urlRows []models.URLRow, position string) error) error {
+	skip := 0
+	if after != "" {
+		var err error
+		if skip, err = strconv.Atoi(after); err != nil {
+			return errors.New("malformed file position " + after)
+		}
+	}
+
+	file, compressed, err := repository.OpenURLRowsFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	s.compressed = compressed
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	var batch []models.URLRow
+	for scanner.Scan() {
+		line++
+		if line <= skip {
+			continue
+		}
+		var urlRow models.URLRow
+		if err := json.Unmarshal(scanner.Bytes(), &urlRow); err != nil {
+			continue
+		}
+		batch = append(batch, urlRow)
+		if len(batch) == batchSize {
+			if err := fn(batch, strconv.Itoa(line)); err != nil {
+				return err
+			}
+			batch = nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return fn(batch, strconv.Itoa(line))
+	}
+	return nil
+}
+
+// Write дописывает новые строки в конец файла. Если изменилась хотя бы одна уже записанная строка,
+// файл атомарно перезаписывается целиком. Снимок перезаписывается целиком всегда.
+func (s *fileStorage) Write(ctx context.Context, urlRows []models.URLRow) error {
+	if s.index == nil {
+		if err := s.load(ctx); err != nil {
+			return err
+		}
+	}
+
+	var appended []models.URLRow
+	changed := false
+	for _, urlRow := range urlRows {
+		i, exists := s.index[urlRow.UUID]
+		switch {
+		case !exists:
+			s.index[urlRow.UUID] = len(s.rows)
+			s.rows = append(s.rows, urlRow)
+			appended = append(appended, urlRow)
+		case !sameRow(s.rows[i], urlRow):
+			s.rows[i] = urlRow
+			changed = true
+		}
+	}
+
+	if s.compressed && (changed || len(appended) > 0) {
+		return repository.WriteSnapshot(s.path, s.rows)
+	}
+	if changed {
+		return repository.WriteURLRowsFile(s.path, s.rows)
+	}
+	if len(appended) == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	for _, urlRow := range appended {
+		data, err := json.Marshal(urlRow)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// load загружает строки файла, чтобы повторная запись не создавала дубликатов.
+func (s *fileStorage) load(ctx context.Context) error {
+	s.index = make(map[uuid.UUID]int)
+	return s.Read(ctx, "", defaultBatchSize, func(urlRows []models.URLRow, _ string) error {
+		for _, urlRow := range urlRows {
+			if i, exists := s.index[urlRow.UUID]; exists {
+				s.rows[i] = urlRow
+				continue
+			}
+			s.index[urlRow.UUID] = len(s.rows)
+			s.rows = append(s.rows, urlRow)
+		}
+		return nil
+	})
+}
+
+// Close ничего не делает: файл открывается на время каждой операции.
+func (s *fileStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package storagecopy
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/db"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// postgresStorage таблица url_rows в Postgres. Строки читаются в порядке UUID,
+// позиция чтения - UUID последней прочитанной строки.
+type postgresStorage struct {
+	db *sql.DB // Подключение к базе данных.
+}
+
+// openPostgres подключается к Postgres. Базу, в которую будут писаться строки, мигрирует до последней версии,
+// а у базы, из которой только читают, проверяет, что схема актуальна.
+func openPostgres(dsn string, writable bool, sugar *logger.Logger) (*postgresStorage, error) {
+	mode := db.MigrationsModeManual
+	if writable {
+		mode = db.MigrationsModeAuto
+	}
+	DB, err := db.InitDB(dsn, mode, sugar)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresStorage{db: DB}, nil
+}
+
+// Read читает строки keyset-пагинацией по первичному ключу.
+func (s *postgresStorage) Read(ctx context.Context, after string, batchSize int, fn func(urlRows []models.URLRow, position string) error) error {
+	var position uuid.NullUUID
+	if after != "" {
+		parsed, err := uuid.Parse(after)
+		if err != nil {
+			return err
+		}
+		position = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	query := `SELECT uuid, short_url, original_url, user_id, is_deleted, created_at, updated_at, deleted_at
+		FROM url_rows WHERE $1::uuid IS NULL OR uuid > $1 ORDER BY uuid LIMIT $2`
+	for {
+		urlRows, err := s.readBatch(ctx, query, position, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(urlRows) == 0 {
+			return nil
+		}
+		last := urlRows[len(urlRows)-1].UUID
+		if err := fn(urlRows, last.String()); err != nil {
+			return err
+		}
+		if len(urlRows) < batchSize {
+			return nil
+		}
+		position = uuid.NullUUID{UUID: last, Valid: true}
+	}
+}
+
+// readBatch читает одну пачку строк после позиции.
+func (s *postgresStorage) readBatch(ctx context.Context, query string, position uuid.NullUUID, batchSize int) ([]models.URLRow, error) {
+	rows, err := s.db.QueryContext(ctx, query, position, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urlRows []models.URLRow
+	for rows.Next() {
+		var urlRow models.URLRow
+		var userID uuid.NullUUID
+		if err := rows.Scan(&urlRow.UUID, &urlRow.ShortURL, &urlRow.OriginalURL, &userID, &urlRow.DeletedFlag,
+			&urlRow.CreatedAt, &urlRow.UpdatedAt, &urlRow.DeletedAt); err != nil {
+			return nil, err
+		}
+		urlRow.UserID = userID.UUID
+		urlRows = append(urlRows, urlRow)
+	}
+	return urlRows, rows.Err()
+}
+
+// Write сохраняет пачку строк одной транзакцией, заменяя строки с теми же UUID.
+// Владельцы строк добавляются в таблицу users, если их там еще нет.
+func (s *postgresStorage) Write(ctx context.Context, urlRows []models.URLRow) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO url_rows (uuid, short_url, original_url, user_id, is_deleted, created_at, updated_at, deleted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (uuid) DO UPDATE SET short_url = EXCLUDED.short_url, original_url = EXCLUDED.original_url,
+			user_id = EXCLUDED.user_id, is_deleted = EXCLUDED.is_deleted, created_at = EXCLUDED.created_at,
+			updated_at = EXCLUDED.updated_at, deleted_at = EXCLUDED.deleted_at`
+	for _, urlRow := range urlRows {
+		userID := uuid.NullUUID{UUID: urlRow.UserID, Valid: urlRow.UserID != uuid.Nil}
+		if userID.Valid {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO users (uuid) VALUES ($1) ON CONFLICT (uuid) DO NOTHING", userID.UUID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, query, urlRow.UUID, urlRow.ShortURL, urlRow.OriginalURL, userID,
+			urlRow.DeletedFlag, urlRow.CreatedAt, urlRow.UpdatedAt, urlRow.DeletedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close закрывает подключение к базе данных.
+func (s *postgresStorage) Close() error {
+	return s.db.Close()
+}
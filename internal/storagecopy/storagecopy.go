@@ -0,0 +1,213 @@
+// Package storagecopy копирует строки URL между хранилищами сервиса:
+// файлом (в том же формате хранятся снимки памяти) и Postgres.
+// Копирование идемпотентно, продолжается с последней сохраненной позиции
+// и завершается сверкой количества строк и контрольных сумм.
+package storagecopy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// defaultBatchSize количество строк, копируемых за одну запись, если оно не задано.
+const defaultBatchSize = 500
+
+// ErrVerificationFailed возвращается, если после копирования строки в хранилищах не совпали.
+var ErrVerificationFailed = errors.New("verification failed")
+
+// Storage хранилище, из которого читаются и в которое записываются строки URL.
+type Storage interface {
+	// Read передает строки в fn пачками не больше batchSize в постоянном порядке, начиная после позиции after
+	// (пустая позиция означает начало). Вместе с пачкой передается позиция после ее последней строки.
+	Read(ctx context.Context, after string, batchSize int, fn func(urlRows []models.URLRow, position string) error) error
+	// Write сохраняет строки, заменяя строки с теми же UUID. Повторная запись тех же строк ничего не меняет.
+	Write(ctx context.Context, urlRows []models.URLRow) error
+	// Close освобождает ресурсы хранилища.
+	Close() error
+}
+
+// Options параметры копирования.
+type Options struct {
+	From      string // Адрес исходного хранилища.
+	To        string // Адрес целевого хранилища.
+	BatchSize int    // Количество строк в одной записи.
+	StatePath string // Файл с позицией копирования для продолжения после сбоя; пустой - без продолжения.
+	Restart   bool   // Начать копирование заново, не глядя на сохраненную позицию.
+	DryRun    bool   // Только прочитать хранилища и показать, что будет скопировано.
+}
+
+// Report итог копирования.
+type Report struct {
+	Copied          int    // Скопировано строк в этом запуске.
+	Resumed         bool   // Копирование продолжено с сохраненной позиции.
+	SourceRows      int    // Строк в исходном хранилище.
+	SourceChecksum  string // Контрольная сумма строк исходного хранилища.
+	TargetRows      int    // Строк исходного хранилища, найденных в целевом.
+	TargetChecksum  string // Контрольная сумма этих строк в целевом хранилище.
+	TargetExtraRows int    // Строк целевого хранилища, которых нет в исходном.
+}
+
+// state позиция копирования, сохраняемая после каждой записанной пачки.
+// Вместо адресов хранилищ хранятся их отпечатки, чтобы пароли из DSN не попадали в файл.
+type state struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Position string `json:"position"`
+	Copied   int    `json:"copied"`
+}
+
+// fingerprint возвращает отпечаток адреса хранилища.
+func fingerprint(location string) string {
+	sum := sha256.Sum256([]byte(location))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Open открывает хранилище по адресу: postgres:// или postgresql:// - Postgres,
+// file:путь или просто путь - файл или снимок хранилища в памяти. Postgres, в который будут писаться строки, мигрируется до последней версии.
+func Open(location string, writable bool, sugar *logger.Logger) (Storage, error) {
+	switch {
+	case strings.HasPrefix(location, "postgres://"), strings.HasPrefix(location, "postgresql://"):
+		return openPostgres(location, writable, sugar)
+	case location == "":
+		return nil, errors.New("storage location is empty")
+	default:
+		return openFile(strings.TrimPrefix(location, "file:"))
+	}
+}
+
+// Copy копирует все строки из from в to и сверяет хранилища.
+// Позиция копирования сохраняется в opts.StatePath после каждой пачки и удаляется после успешной сверки.
+// В режиме DryRun ничего не записывается: отчет показывает состояние хранилищ до копирования.
+func Copy(ctx context.Context, from, to Storage, opts Options, sugar *logger.Logger) (Report, error) {
+	var report Report
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+
+	if !opts.DryRun {
+		current, err := loadState(opts)
+		if err != nil {
+			return report, err
+		}
+		report.Resumed = current.Position != ""
+		if report.Resumed {
+			sugar.Infof("Resuming copy after position %s, %d rows copied before", current.Position, current.Copied)
+		}
+
+		err = from.Read(ctx, current.Position, opts.BatchSize, func(urlRows []models.URLRow, position string) error {
+			if err := to.Write(ctx, urlRows); err != nil {
+				return err
+			}
+			report.Copied += len(urlRows)
+			current.Position = position
+			current.Copied += len(urlRows)
+			sugar.Debugf("Copied %d rows", current.Copied)
+			return saveState(opts.StatePath, current)
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if err := verify(ctx, from, to, opts.BatchSize, &report); err != nil {
+		return report, err
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+	if report.SourceRows != report.TargetRows || report.SourceChecksum != report.TargetChecksum {
+		return report, fmt.Errorf("%w: source has %d rows with checksum %s, target has %d of them with checksum %s",
+			ErrVerificationFailed, report.SourceRows, report.SourceChecksum, report.TargetRows, report.TargetChecksum)
+	}
+	if opts.StatePath != "" {
+		if err := os.Remove(opts.StatePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// verify считает строки и контрольные суммы исходного хранилища и тех же строк в целевом.
+func verify(ctx context.Context, from, to Storage, batchSize int, report *Report) error {
+	sourceUUIDs := make(map[uuid.UUID]bool)
+	var sourceSum checksum
+	err := from.Read(ctx, "", batchSize, func(urlRows []models.URLRow, _ string) error {
+		for _, urlRow := range urlRows {
+			sourceUUIDs[urlRow.UUID] = true
+			sourceSum.add(urlRow)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var targetSum checksum
+	err = to.Read(ctx, "", batchSize, func(urlRows []models.URLRow, _ string) error {
+		for _, urlRow := range urlRows {
+			if sourceUUIDs[urlRow.UUID] {
+				targetSum.add(urlRow)
+			} else {
+				report.TargetExtraRows++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report.SourceRows, report.SourceChecksum = sourceSum.count, sourceSum.String()
+	report.TargetRows, report.TargetChecksum = targetSum.count, targetSum.String()
+	return nil
+}
+
+// loadState читает сохраненную позицию копирования тех же хранилищ.
+func loadState(opts Options) (state, error) {
+	current := state{From: fingerprint(opts.From), To: fingerprint(opts.To)}
+	if opts.StatePath == "" || opts.Restart {
+		return current, nil
+	}
+	data, err := os.ReadFile(opts.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return current, nil
+	}
+	if err != nil {
+		return current, err
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return current, fmt.Errorf("cannot decode copy state %s: %w", opts.StatePath, err)
+	}
+	if saved.From != current.From || saved.To != current.To {
+		return current, fmt.Errorf("copy state %s belongs to another pair of storages, use restart to discard it", opts.StatePath)
+	}
+	return saved, nil
+}
+
+// saveState атомарно сохраняет позицию копирования.
+func saveState(path string, current state) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,166 @@
+package storagecopy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+)
+
+// failingStorage целевое хранилище, которое отказывает после заданного количества записей.
+type failingStorage struct {
+	Storage
+	writesLeft int
+}
+
+func (s *failingStorage) Write(ctx context.Context, urlRows []models.URLRow) error {
+	if s.writesLeft == 0 {
+		return errors.New("connection lost")
+	}
+	s.writesLeft--
+	return s.Storage.Write(ctx, urlRows)
+}
+
+func writeSourceRows(t *testing.T, path string, count int) []models.URLRow {
+	t.Helper()
+	now := time.Now().UTC()
+	var urlRows []models.URLRow
+	for i := 0; i < count; i++ {
+		urlRow := models.URLRow{
+			UUID:        uuid.New(),
+			ShortURL:    string(rune('a'+i)) + "bcdefgh",
+			OriginalURL: "http://practicum.yandex.ru/" + string(rune('a'+i)),
+			UserID:      uuid.New(),
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if i%2 == 0 {
+			urlRow.MarkDeleted(now)
+		}
+		urlRows = append(urlRows, urlRow)
+	}
+	require.NoError(t, repository.WriteURLRowsFile(path, urlRows))
+	return urlRows
+}
+
+func readAllRows(t *testing.T, s Storage) []models.URLRow {
+	t.Helper()
+	var urlRows []models.URLRow
+	err := s.Read(context.Background(), "", 2, func(batch []models.URLRow, _ string) error {
+		urlRows = append(urlRows, batch...)
+		return nil
+	})
+	require.NoError(t, err)
+	return urlRows
+}
+
+func TestCopy_ResumesAndIsIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		From:      filepath.Join(dir, "source.json"),
+		To:        filepath.Join(dir, "target.json"),
+		BatchSize: 2,
+		StatePath: filepath.Join(dir, "copy.state"),
+	}
+	sourceRows := writeSourceRows(t, opts.From, 5)
+	ctx := context.Background()
+	sugar := logger.GetLogger()
+
+	from, err := Open(opts.From, false, sugar)
+	require.NoError(t, err)
+	to, err := Open("file:"+opts.To, true, sugar)
+	require.NoError(t, err)
+
+	_, err = Copy(ctx, from, &failingStorage{Storage: to, writesLeft: 1}, opts, sugar)
+	require.Error(t, err)
+	assert.FileExists(t, opts.StatePath)
+
+	report, err := Copy(ctx, from, to, opts, sugar)
+	require.NoError(t, err)
+	assert.True(t, report.Resumed)
+	assert.Equal(t, 3, report.Copied)
+	assert.Equal(t, 5, report.SourceRows)
+	assert.Equal(t, report.SourceChecksum, report.TargetChecksum)
+	assert.NoFileExists(t, opts.StatePath)
+
+	report, err = Copy(ctx, from, to, opts, sugar)
+	require.NoError(t, err)
+	assert.False(t, report.Resumed)
+	assert.Equal(t, 5, report.Copied)
+	assert.Equal(t, 0, report.TargetExtraRows)
+
+	targetRows := readAllRows(t, to)
+	require.Len(t, targetRows, len(sourceRows))
+	for i := range sourceRows {
+		assert.Equal(t, sourceRows[i].UserID, targetRows[i].UserID)
+		assert.Equal(t, sourceRows[i].DeletedFlag, targetRows[i].DeletedFlag)
+	}
+}
+
+func TestCopy_ReplacesChangedRows(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{From: filepath.Join(dir, "source.json"), To: filepath.Join(dir, "target.json")}
+	sourceRows := writeSourceRows(t, opts.From, 3)
+	stale := sourceRows[1]
+	stale.OriginalURL = "http://practicum.yandex.ru/stale"
+	require.NoError(t, repository.WriteURLRowsFile(opts.To, []models.URLRow{stale}))
+	sugar := logger.GetLogger()
+
+	from, _ := Open(opts.From, false, sugar)
+	to, _ := Open(opts.To, true, sugar)
+	report, err := Copy(context.Background(), from, to, opts, sugar)
+	require.NoError(t, err)
+	assert.Equal(t, report.SourceChecksum, report.TargetChecksum)
+	assert.Len(t, readAllRows(t, to), 3)
+}
+
+func TestCopy_DryRunDoesNotWrite(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{From: filepath.Join(dir, "source.json"), To: filepath.Join(dir, "target.json"), DryRun: true}
+	writeSourceRows(t, opts.From, 3)
+	sugar := logger.GetLogger()
+
+	from, _ := Open(opts.From, false, sugar)
+	to, _ := Open(opts.To, false, sugar)
+	report, err := Copy(context.Background(), from, to, opts, sugar)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Copied)
+	assert.Equal(t, 3, report.SourceRows)
+	assert.Equal(t, 0, report.TargetRows)
+	_, err = os.Stat(opts.To)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCopy_FromAndToMemorySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	snapshotPath := filepath.Join(dir, "snapshot.gz")
+	sourceRows := writeSourceRows(t, filepath.Join(dir, "rows.json"), 3)
+	require.NoError(t, repository.WriteSnapshot(snapshotPath, sourceRows[:2]))
+	ctx := context.Background()
+	sugar := logger.GetLogger()
+
+	from, err := Open(filepath.Join(dir, "rows.json"), false, sugar)
+	require.NoError(t, err)
+	to, err := Open(snapshotPath, true, sugar)
+	require.NoError(t, err)
+
+	report, err := Copy(ctx, from, to, Options{BatchSize: 2}, sugar)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.TargetRows)
+	assert.Equal(t, report.SourceChecksum, report.TargetChecksum)
+
+	// Снимок остается сжатым и загружается целиком.
+	loaded, err := repository.ReadSnapshot(snapshotPath)
+	require.NoError(t, err)
+	assert.Len(t, loaded, 3)
+}
@@ -0,0 +1,121 @@
+// Package tracing настраивает распределенную трассировку OpenTelemetry.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+)
+
+// Доступные экспортеры трассировки.
+const (
+	ExporterNone   = "none"   // Трассировка выключена.
+	ExporterOTLP   = "otlp"   // Отправка спанов по OTLP/HTTP.
+	ExporterStdout = "stdout" // Вывод спанов в stdout или файл для локального запуска.
+)
+
+const (
+	instrumentationName = "github.com/romanyakovlev/go-yandex-url-shortener"
+	serviceName         = "shortener"
+)
+
+// Tracer возвращает трейсер приложения.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start создает дочерний спан с указанным именем.
+func Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, spanName, opts...)
+}
+
+// RecordError отмечает спан как завершившийся с ошибкой, если она есть.
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// Init настраивает глобальный провайдер трассировки и W3C-пропагатор
+// и возвращает функцию для его корректной остановки.
+func Init(serverConfig config.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, closeOutput, err := newExporter(serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeOutput(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// newExporter создает экспортер спанов, выбранный в конфигурации.
+func newExporter(serverConfig config.Config) (sdktrace.SpanExporter, func() error, error) {
+	noopClose := func() error { return nil }
+	switch serverConfig.TracingExporter {
+	case "", ExporterNone:
+		return nil, noopClose, nil
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if serverConfig.TracingEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(serverConfig.TracingEndpoint), otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), opts...)
+		return exporter, noopClose, err
+	case ExporterStdout:
+		var output io.Writer = os.Stdout
+		closeOutput := noopClose
+		if serverConfig.TracingFile != "" {
+			file, err := os.OpenFile(serverConfig.TracingFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+			if err != nil {
+				return nil, noopClose, err
+			}
+			output = file
+			closeOutput = file.Close
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(output))
+		return exporter, closeOutput, err
+	default:
+		return nil, noopClose, fmt.Errorf("unknown tracing exporter: %s", serverConfig.TracingExporter)
+	}
+}
@@ -0,0 +1,72 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
+	shortener "github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
+)
+
+// defaultBloomRebuildInterval интервал перестроения фильтра коротких адресов, если он не задан в конфиге.
+const defaultBloomRebuildInterval = time.Hour
+
+// ShortURLFilterWorker фоновый процесс, который строит фильтр существующих коротких адресов
+// при запуске и затем перестраивает его, чтобы из фильтра пропадали стертые URL.
+type ShortURLFilterWorker struct {
+	shortener *shortener.URLShortenerService // Сервис сокращения URL.
+	logger    *logger.Logger                 // Логгер для регистрации событий.
+	interval  time.Duration                  // Интервал между перестроениями.
+}
+
+// StartShortURLFilterWorker строит фильтр сразу и затем каждые interval,
+// блокируясь до отмены контекста.
+func (w *ShortURLFilterWorker) StartShortURLFilterWorker(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.rebuild(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rebuild выполняет одно перестроение фильтра.
+func (w *ShortURLFilterWorker) rebuild(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "ShortURLFilterWorker.rebuild", trace.WithNewRoot())
+	defer span.End()
+
+	start := time.Now()
+	size, err := w.shortener.RebuildShortURLFilter(ctx)
+	if err != nil {
+		tracing.RecordError(span, err)
+		w.logger.With(ctx).Errorf("Error rebuilding short URL filter: %v", err)
+		return
+	}
+	span.SetAttributes(attribute.Int("bloom.size", size))
+	metrics.ShortURLFilterSize.Set(float64(size))
+	w.logger.With(ctx).Infof("Rebuilt short URL filter with %d URLs in %s", size, time.Since(start))
+}
+
+// InitShortURLFilterWorker инициализирует и возвращает новый процесс перестроения фильтра коротких адресов.
+func InitShortURLFilterWorker(s *shortener.URLShortenerService, serverConfig config.Config, sugar *logger.Logger) *ShortURLFilterWorker {
+	interval := serverConfig.BloomRebuildInterval
+	if interval <= 0 {
+		interval = defaultBloomRebuildInterval
+	}
+	return &ShortURLFilterWorker{
+		shortener: s,
+		logger:    sugar,
+		interval:  interval,
+	}
+}
@@ -1,79 +1,324 @@
 // Package workers выполняет роль фонового процесса, который
-// выполняет отложенное удаление URL отдельно от хендлера.
+// выполняет отложенное удаление и восстановление URL отдельно от хендлера.
 package workers
 
 import (
 	"context"
-	"fmt"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
 	shortener "github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
+)
+
+// Значения по умолчанию для пула удаления, если они не заданы в конфиге.
+const (
+	defaultDeletionWorkers       = 4
+	defaultDeletionFlushInterval = 500 * time.Millisecond
+	defaultDeletionBatchSize     = 100
+	defaultDeletionQueueSize     = 1000
+)
+
+// deletionFlushTimeout ограничивает время обработки одной пачки. Пачка выполняется
+// без отмены вместе с контекстом пула, чтобы остановка сервиса не обрывала начатое удаление.
+const deletionFlushTimeout = 30 * time.Second
+
+// Operation вид операции над URL, выполняемой пулом.
+type Operation int
+
+// Операции над URL. Нулевое значение означает удаление.
+const (
+	OperationDelete  Operation = iota // Пометить URL как удаленные.
+	OperationRestore                  // Снять с URL пометку об удалении.
 )
 
-// DeletionRequest структура запроса на удаление URL.
+// String возвращает название операции для логов и трассировки.
+func (o Operation) String() string {
+	if o == OperationRestore {
+		return "restore"
+	}
+	return "delete"
+}
+
+// DeletionRequest структура запроса на удаление или восстановление URL.
 type DeletionRequest struct {
-	User models.User // Пользователь, от имени которого производится удаление.
-	URLs []string    // Список URL для удаления.
+	Operation   Operation         // Операция над URL; по умолчанию удаление.
+	User        models.User       // Пользователь, от имени которого производится операция.
+	URLs        []string          // Список URL для удаления.
+	SpanContext trace.SpanContext // Спан HTTP-запроса, породившего удаление.
+	RequestID   string            // Идентификатор HTTP-запроса, породившего удаление.
+}
+
+// deletionError ошибка обработки пачки вместе с контекстом, в котором она произошла.
+type deletionError struct {
+	ctx context.Context // Контекст пачки с идентификаторами запросов и спаном.
+	err error           // Ошибка удаления.
 }
 
-// URLDeletionWorker структура фонового процесса для удаления URL.
+// URLDeletionWorker структура пула фоновых процессов для удаления и восстановления URL.
+// Запросы накапливаются в течение flushInterval или до batchSize URL,
+// группируются по пользователям и обрабатываются одним обращением к хранилищу
+// на каждую подряд идущую серию запросов с одной операцией.
+// Запросы одного пользователя всегда попадают в очередь одной и той же горутины,
+// поэтому удаление и последующее восстановление URL не переставляются.
 type URLDeletionWorker struct {
-	shortener            *shortener.URLShortenerService // Сервис сокращения URL.
-	errorChannel         chan error                     // Канал для передачи ошибок.
-	deletionRequestsChan chan DeletionRequest           // Канал для запросов на удаление.
+	shortener         *shortener.URLShortenerService // Сервис сокращения URL.
+	logger            *logger.Logger                 // Логгер для регистрации событий.
+	errorChannel      chan deletionError             // Канал для передачи ошибок.
+	errorListenerDone chan struct{}                  // Закрывается после остановки слушателя ошибок.
+	queues            []chan DeletionRequest         // Очереди запросов, по одной на горутину пула.
+	flushInterval     time.Duration                  // Интервал накопления запросов.
+	batchSize         int                            // Максимальное количество URL в пачке.
 }
 
-// StartDeletionWorker запускает фоновый процесс для обработки запросов на удаление.
+// StartDeletionWorker запускает пул горутин для обработки запросов на удаление
+// и блокируется до отмены контекста и завершения всех горутин пула.
 func (w *URLDeletionWorker) StartDeletionWorker(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, queue := range w.queues {
+		wg.Add(1)
+		go func(queue chan DeletionRequest) {
+			defer wg.Done()
+			w.collectDeletionRequests(ctx, queue)
+		}(queue)
+	}
+	wg.Wait()
+}
+
+// collectDeletionRequests накапливает запросы из очереди и отправляет их пачками.
+func (w *URLDeletionWorker) collectDeletionRequests(ctx context.Context, queue chan DeletionRequest) {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	var batch []DeletionRequest
+	urlsCount := 0
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		w.flushDeletionBatch(ctx, batch)
+		batch = nil
+		urlsCount = 0
+	}
+
 	for {
 		select {
-		case req := <-w.deletionRequestsChan: // Чтение запроса на удаление из канала.
-			go w.processDeletionRequest(ctx, req) // Асинхронная обработка запроса.
+		case req := <-queue: // Чтение запроса на удаление из канала.
+			metrics.DeletionQueueDepth.Set(float64(w.QueueLen()))
+			batch = append(batch, req)
+			urlsCount += len(req.URLs)
+			if urlsCount >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
 		case <-ctx.Done(): // Завершение работы при отмене контекста.
-			return
+			// Накопленные и оставшиеся в очереди запросы выполняем, чтобы не потерять их при остановке.
+			for {
+				select {
+				case req := <-queue:
+					metrics.DeletionQueueDepth.Set(float64(w.QueueLen()))
+					batch = append(batch, req)
+					urlsCount += len(req.URLs)
+					if urlsCount >= w.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
 		}
 	}
 }
 
+// flushDeletionBatch выполняет пачку в контексте, отвязанном от отмены пула и ограниченном deletionFlushTimeout.
+func (w *URLDeletionWorker) flushDeletionBatch(ctx context.Context, batch []DeletionRequest) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deletionFlushTimeout)
+	defer cancel()
+	w.processDeletionBatch(ctx, batch)
+}
+
+// queueFor возвращает очередь горутины, которая обрабатывает запросы пользователя.
+func (w *URLDeletionWorker) queueFor(userID uuid.UUID) chan DeletionRequest {
+	return w.queues[binary.BigEndian.Uint32(userID[12:])%uint32(len(w.queues))]
+}
+
 // SendDeletionRequestToWorker отправляет запрос на удаление в фоновый процесс.
 func (w *URLDeletionWorker) SendDeletionRequestToWorker(req DeletionRequest) error {
 	select {
-	case w.deletionRequestsChan <- req: // Попытка отправить запрос в канал.
+	case w.queueFor(req.User.UUID) <- req: // Попытка отправить запрос в канал.
+		metrics.DeletionQueueDepth.Set(float64(w.QueueLen()))
 		return nil
 	default:
-		return fmt.Errorf("the deletion request queue is currently full, please try again later")
+		return &apperrors.DeletionQueueIsFull{RetryAfter: w.flushInterval}
 	}
 }
 
-// processDeletionRequest обрабатывает запрос на удаление.
-func (w *URLDeletionWorker) processDeletionRequest(ctx context.Context, req DeletionRequest) {
-	if err := w.shortener.DeleteBatchURL(req.URLs, req.User); err != nil {
-		select {
-		case w.errorChannel <- err:
-		case <-ctx.Done():
-			fmt.Println("Operation canceled, skipping error reporting.")
+// QueueLen возвращает текущее количество запросов в очереди на удаление.
+func (w *URLDeletionWorker) QueueLen() int {
+	n := 0
+	for _, queue := range w.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// QueueCap возвращает вместимость очереди на удаление.
+func (w *URLDeletionWorker) QueueCap() int {
+	n := 0
+	for _, queue := range w.queues {
+		n += cap(queue)
+	}
+	return n
+}
+
+// processDeletionBatch разбивает пачку на подряд идущие серии запросов с одной операцией
+// и выполняет их по порядку, чтобы удаление и восстановление одного URL не переставлялись.
+func (w *URLDeletionWorker) processDeletionBatch(ctx context.Context, batch []DeletionRequest) {
+	for start := 0; start < len(batch); {
+		end := start + 1
+		for end < len(batch) && batch[end].Operation == batch[start].Operation {
+			end++
 		}
+		w.processOperationBatch(ctx, batch[start].Operation, batch[start:end])
+		start = end
 	}
 }
 
+// processOperationBatch группирует запросы по пользователям и выполняет операцию одним обращением к хранилищу.
+// Спан пачки связан со спанами всех HTTP-запросов, из которых она собрана.
+func (w *URLDeletionWorker) processOperationBatch(ctx context.Context, operation Operation, batch []DeletionRequest) {
+	urlsByUser := make(map[uuid.UUID][]string)
+	var links []trace.Link
+	var requestIDs []string
+	for _, req := range batch {
+		urlsByUser[req.User.UUID] = append(urlsByUser[req.User.UUID], req.URLs...)
+		if req.SpanContext.IsValid() {
+			links = append(links, trace.Link{SpanContext: req.SpanContext})
+		}
+		if req.RequestID != "" {
+			requestIDs = append(requestIDs, req.RequestID)
+		}
+	}
+	// В логах пачки перечисляются идентификаторы всех запросов, из которых она собрана.
+	ctx = logger.ContextWithRequestID(ctx, strings.Join(requestIDs, ","))
+	ctx, span := tracing.Start(ctx, "URLDeletionWorker.processDeletionBatch",
+		trace.WithNewRoot(),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("deletion.operation", operation.String()),
+			attribute.Int("deletion.requests", len(batch)),
+			attribute.Int("deletion.users", len(urlsByUser)),
+			attribute.StringSlice("deletion.request_ids", requestIDs),
+		),
+	)
+	defer span.End()
+
+	w.logger.With(ctx).Debugf("Processing %s batch: %d requests, %d users", operation, len(batch), len(urlsByUser))
+	var err error
+	if operation == OperationRestore {
+		err = w.shortener.RestoreBatchURLByUsers(ctx, urlsByUser)
+		if err != nil {
+			metrics.RestoreFailuresTotal.Inc()
+		}
+	} else {
+		err = w.shortener.DeleteBatchURLByUsers(ctx, urlsByUser)
+		if err != nil {
+			metrics.DeletionFailuresTotal.Inc()
+		}
+	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		w.reportError(deletionError{ctx: ctx, err: err})
+	}
+}
+
+// reportError передает ошибку слушателю, а после его остановки пишет ее в лог сама,
+// чтобы пачки, выполняемые при остановке сервиса, не блокировались на заполненном канале.
+func (w *URLDeletionWorker) reportError(deletionErr deletionError) {
+	select {
+	case <-w.errorListenerDone:
+		w.logDeletionError(deletionErr)
+		return
+	default:
+	}
+	select {
+	case w.errorChannel <- deletionErr:
+	case <-w.errorListenerDone:
+		w.logDeletionError(deletionErr)
+	}
+}
+
+// logDeletionError пишет ошибку обработки пачки в лог.
+func (w *URLDeletionWorker) logDeletionError(deletionErr deletionError) {
+	w.logger.With(deletionErr.ctx).Errorf("Error processing deletion or restore request: %v", deletionErr.err)
+}
+
 // StartErrorListener запускает прослушивание канала ошибок.
 func (w *URLDeletionWorker) StartErrorListener(ctx context.Context) {
 	for {
 		select {
-		case err := <-w.errorChannel:
-			fmt.Printf("Error processing deletion request: %v\n", err)
+		case deletionErr := <-w.errorChannel:
+			w.logDeletionError(deletionErr)
 		case <-ctx.Done():
-			fmt.Println("Error listener shutting down due to context cancellation.")
-			return
+			w.logger.Infoln("Error listener shutting down due to context cancellation.")
+			close(w.errorListenerDone)
+			// Ошибки, переданные до остановки, пишем в лог, чтобы они не потерялись в буфере канала.
+			for {
+				select {
+				case deletionErr := <-w.errorChannel:
+					w.logDeletionError(deletionErr)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
 
-// InitURLDeletionWorker инициализирует и возвращает новый экземпляр фонового процесса для удаления URL.
-func InitURLDeletionWorker(s *shortener.URLShortenerService) *URLDeletionWorker {
+// InitURLDeletionWorker инициализирует и возвращает новый экземпляр пула для удаления URL.
+// Незаданные в конфиге параметры пула принимают значения по умолчанию.
+func InitURLDeletionWorker(s *shortener.URLShortenerService, serverConfig config.Config, sugar *logger.Logger) *URLDeletionWorker {
+	workersCount := serverConfig.DeletionWorkers
+	if workersCount <= 0 {
+		workersCount = defaultDeletionWorkers
+	}
+	flushInterval := serverConfig.DeletionFlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultDeletionFlushInterval
+	}
+	batchSize := serverConfig.DeletionBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultDeletionBatchSize
+	}
+	queueSize := serverConfig.DeletionQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultDeletionQueueSize
+	}
+	// Общая вместимость очереди делится между горутинами пула.
+	queues := make([]chan DeletionRequest, workersCount)
+	for i := range queues {
+		queues[i] = make(chan DeletionRequest, (queueSize+workersCount-1)/workersCount)
+	}
 	return &URLDeletionWorker{
-		shortener:            s,
-		errorChannel:         make(chan error, 100),
-		deletionRequestsChan: make(chan DeletionRequest, 100),
+		shortener:         s,
+		logger:            sugar,
+		errorChannel:      make(chan deletionError, 100),
+		errorListenerDone: make(chan struct{}),
+		queues:            queues,
+		flushInterval:     flushInterval,
+		batchSize:         batchSize,
 	}
 }
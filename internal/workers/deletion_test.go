@@ -8,7 +8,9 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/apperrors"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
 	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
 	shortener "github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
@@ -16,7 +18,7 @@ import (
 
 func setupURLShortenerService() (*shortener.URLShortenerService, *models.SharedURLRows) {
 	sharedURLRows := models.NewSharedURLRows()
-	urlRepo, _ := repository.NewMemoryURLRepository(sharedURLRows)
+	urlRepo, _ := repository.NewMemoryURLRepository(sharedURLRows, repository.DedupScopeGlobal)
 	userRepo, _ := repository.NewMemoryUserRepository(sharedURLRows)
 	service := shortener.NewURLShortenerService(config.Config{}, urlRepo, userRepo)
 	return service, sharedURLRows
@@ -25,7 +27,7 @@ func setupURLShortenerService() (*shortener.URLShortenerService, *models.SharedU
 func TestURLDeletionWorker_SendDeletionRequestToWorker(t *testing.T) {
 	t.Parallel()
 	service, _ := setupURLShortenerService()
-	worker := InitURLDeletionWorker(service)
+	worker := InitURLDeletionWorker(service, config.Config{DeletionQueueSize: 10}, logger.GetLogger())
 	req := DeletionRequest{
 		User: models.User{UUID: uuid.New()},
 		URLs: []string{"url1", "url2"},
@@ -34,19 +36,21 @@ func TestURLDeletionWorker_SendDeletionRequestToWorker(t *testing.T) {
 	err := worker.SendDeletionRequestToWorker(req)
 	assert.NoError(t, err)
 
-	for i := 0; i < cap(worker.deletionRequestsChan)-1; i++ {
+	for i := 0; i < cap(worker.queueFor(req.User.UUID))-1; i++ {
 		err := worker.SendDeletionRequestToWorker(req)
 		assert.NoError(t, err)
 	}
 
 	err = worker.SendDeletionRequestToWorker(req)
 	assert.Error(t, err, "expected an error when the deletion request queue is full")
+	var queueErr *apperrors.DeletionQueueIsFull
+	assert.ErrorAs(t, err, &queueErr)
 }
 
-func TestURLDeletionWorker_ProcessDeletionRequest(t *testing.T) {
+func TestURLDeletionWorker_ProcessDeletionBatch(t *testing.T) {
 	t.Parallel()
 	service, sharedURLRows := setupURLShortenerService()
-	worker := InitURLDeletionWorker(service)
+	worker := InitURLDeletionWorker(service, config.Config{}, logger.GetLogger())
 	ctx := context.Background()
 
 	userID := uuid.New()
@@ -66,8 +70,7 @@ func TestURLDeletionWorker_ProcessDeletionRequest(t *testing.T) {
 	}
 	sharedURLRows.Mu.Unlock()
 
-	go worker.processDeletionRequest(ctx, req)
-	time.Sleep(100 * time.Millisecond)
+	worker.processDeletionBatch(ctx, []DeletionRequest{req})
 
 	sharedURLRows.Mu.Lock()
 	for _, urlRow := range sharedURLRows.URLRows {
@@ -81,7 +84,7 @@ func TestURLDeletionWorker_ProcessDeletionRequest(t *testing.T) {
 func TestURLDeletionWorker_StartDeletionWorker(t *testing.T) {
 	t.Parallel()
 	service, sharedURLRows := setupURLShortenerService()
-	worker := InitURLDeletionWorker(service)
+	worker := InitURLDeletionWorker(service, config.Config{DeletionFlushInterval: 10 * time.Millisecond}, logger.GetLogger())
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -117,3 +120,227 @@ func TestURLDeletionWorker_StartDeletionWorker(t *testing.T) {
 	}
 	sharedURLRows.Mu.Unlock()
 }
+
+func TestURLDeletionWorker_DrainsQueueOnStop(t *testing.T) {
+	t.Parallel()
+	service, sharedURLRows := setupURLShortenerService()
+	worker := InitURLDeletionWorker(service, config.Config{DeletionFlushInterval: time.Hour}, logger.GetLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	userID := uuid.New()
+	urls := []string{"url1", "url2", "url3"}
+	sharedURLRows.Mu.Lock()
+	for _, url := range urls {
+		sharedURLRows.URLRows = append(sharedURLRows.URLRows, models.URLRow{
+			UUID:        uuid.New(),
+			ShortURL:    url,
+			OriginalURL: "original-" + url,
+			UserID:      userID,
+		})
+	}
+	sharedURLRows.Mu.Unlock()
+
+	// Запросы попадают в очередь до запуска пула и не дожидаются отправки пачки по таймеру.
+	for _, url := range urls {
+		assert.NoError(t, worker.SendDeletionRequestToWorker(DeletionRequest{User: models.User{UUID: userID}, URLs: []string{url}}))
+	}
+	cancel()
+	worker.StartDeletionWorker(ctx)
+
+	assert.Zero(t, worker.QueueLen())
+	sharedURLRows.Mu.Lock()
+	for _, urlRow := range sharedURLRows.URLRows {
+		assert.True(t, urlRow.DeletedFlag, "expected url %s to be marked as deleted on stop", urlRow.ShortURL)
+	}
+	sharedURLRows.Mu.Unlock()
+}
+
+func TestURLDeletionWorker_ProcessDeletionBatchGroupsByUser(t *testing.T) {
+	t.Parallel()
+	service, sharedURLRows := setupURLShortenerService()
+	worker := InitURLDeletionWorker(service, config.Config{}, logger.GetLogger())
+	ctx := context.Background()
+
+	firstUserID, secondUserID := uuid.New(), uuid.New()
+	sharedURLRows.Mu.Lock()
+	sharedURLRows.URLRows = append(sharedURLRows.URLRows,
+		models.URLRow{UUID: uuid.New(), ShortURL: "url1", OriginalURL: "original-url1", UserID: firstUserID},
+		models.URLRow{UUID: uuid.New(), ShortURL: "url2", OriginalURL: "original-url2", UserID: secondUserID},
+		models.URLRow{UUID: uuid.New(), ShortURL: "url3", OriginalURL: "original-url3", UserID: secondUserID},
+	)
+	sharedURLRows.Mu.Unlock()
+
+	worker.processDeletionBatch(ctx, []DeletionRequest{
+		{User: models.User{UUID: firstUserID}, URLs: []string{"url1"}},
+		{User: models.User{UUID: secondUserID}, URLs: []string{"url2"}},
+		// Чужой URL не должен удаляться.
+		{User: models.User{UUID: firstUserID}, URLs: []string{"url3"}},
+	})
+
+	sharedURLRows.Mu.Lock()
+	defer sharedURLRows.Mu.Unlock()
+	deleted := make(map[string]bool)
+	for _, urlRow := range sharedURLRows.URLRows {
+		deleted[urlRow.ShortURL] = urlRow.DeletedFlag
+	}
+	assert.True(t, deleted["url1"])
+	assert.True(t, deleted["url2"])
+	assert.False(t, deleted["url3"])
+}
+
+func TestURLDeletionWorker_FlushesOnBatchSize(t *testing.T) {
+	t.Parallel()
+	service, sharedURLRows := setupURLShortenerService()
+	// Интервал заведомо больше времени теста, поэтому сработать может только размер пачки.
+	worker := InitURLDeletionWorker(service, config.Config{
+		DeletionWorkers:       1,
+		DeletionFlushInterval: time.Hour,
+		DeletionBatchSize:     2,
+	}, logger.GetLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userID := uuid.New()
+	sharedURLRows.Mu.Lock()
+	for _, url := range []string{"url1", "url2"} {
+		sharedURLRows.URLRows = append(sharedURLRows.URLRows, models.URLRow{
+			UUID:        uuid.New(),
+			ShortURL:    url,
+			OriginalURL: "original-" + url,
+			UserID:      userID,
+		})
+	}
+	sharedURLRows.Mu.Unlock()
+
+	go worker.StartDeletionWorker(ctx)
+
+	assert.NoError(t, worker.SendDeletionRequestToWorker(DeletionRequest{User: models.User{UUID: userID}, URLs: []string{"url1"}}))
+	assert.NoError(t, worker.SendDeletionRequestToWorker(DeletionRequest{User: models.User{UUID: userID}, URLs: []string{"url2"}}))
+
+	assert.Eventually(t, func() bool {
+		sharedURLRows.Mu.Lock()
+		defer sharedURLRows.Mu.Unlock()
+		for _, urlRow := range sharedURLRows.URLRows {
+			if !urlRow.DeletedFlag {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestURLDeletionWorker_ProcessRestoreBatch(t *testing.T) {
+	t.Parallel()
+	service, sharedURLRows := setupURLShortenerService()
+	worker := InitURLDeletionWorker(service, config.Config{}, logger.GetLogger())
+	ctx := context.Background()
+
+	userID, otherUserID := uuid.New(), uuid.New()
+	sharedURLRows.Mu.Lock()
+	sharedURLRows.URLRows = append(sharedURLRows.URLRows,
+		models.URLRow{UUID: uuid.New(), ShortURL: "url1", OriginalURL: "original-url1", UserID: userID, DeletedFlag: true},
+		// Оригинальный URL сокращен заново другим пользователем, восстанавливать нельзя.
+		models.URLRow{UUID: uuid.New(), ShortURL: "url2", OriginalURL: "original-url2", UserID: userID, DeletedFlag: true},
+		models.URLRow{UUID: uuid.New(), ShortURL: "url3", OriginalURL: "original-url2", UserID: otherUserID},
+		// Чужой URL не должен восстанавливаться.
+		models.URLRow{UUID: uuid.New(), ShortURL: "url4", OriginalURL: "original-url4", UserID: otherUserID, DeletedFlag: true},
+	)
+	sharedURLRows.Mu.Unlock()
+
+	worker.processDeletionBatch(ctx, []DeletionRequest{
+		{Operation: OperationRestore, User: models.User{UUID: userID}, URLs: []string{"url1", "url2", "url4"}},
+	})
+
+	sharedURLRows.Mu.Lock()
+	defer sharedURLRows.Mu.Unlock()
+	deleted := make(map[string]bool)
+	for _, urlRow := range sharedURLRows.URLRows {
+		deleted[urlRow.ShortURL] = urlRow.DeletedFlag
+	}
+	assert.False(t, deleted["url1"])
+	assert.True(t, deleted["url2"])
+	assert.False(t, deleted["url3"])
+	assert.True(t, deleted["url4"])
+}
+
+func TestURLDeletionWorker_ProcessDeletionBatchKeepsOperationOrder(t *testing.T) {
+	t.Parallel()
+	service, sharedURLRows := setupURLShortenerService()
+	worker := InitURLDeletionWorker(service, config.Config{}, logger.GetLogger())
+	ctx := context.Background()
+
+	userID := uuid.New()
+	sharedURLRows.Mu.Lock()
+	sharedURLRows.URLRows = append(sharedURLRows.URLRows,
+		models.URLRow{UUID: uuid.New(), ShortURL: "url1", OriginalURL: "original-url1", UserID: userID},
+	)
+	sharedURLRows.Mu.Unlock()
+
+	worker.processDeletionBatch(ctx, []DeletionRequest{
+		{Operation: OperationDelete, User: models.User{UUID: userID}, URLs: []string{"url1"}},
+		{Operation: OperationRestore, User: models.User{UUID: userID}, URLs: []string{"url1"}},
+	})
+
+	sharedURLRows.Mu.Lock()
+	defer sharedURLRows.Mu.Unlock()
+	assert.False(t, sharedURLRows.URLRows[0].DeletedFlag)
+	assert.Nil(t, sharedURLRows.URLRows[0].DeletedAt)
+}
+
+func TestURLDeletionWorker_KeepsUserOperationOrderAcrossWorkers(t *testing.T) {
+	t.Parallel()
+	service, sharedURLRows := setupURLShortenerService()
+	worker := InitURLDeletionWorker(service, config.Config{DeletionWorkers: 8, DeletionFlushInterval: time.Hour}, logger.GetLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	userIDs := make([]uuid.UUID, 16)
+	sharedURLRows.Mu.Lock()
+	for i := range userIDs {
+		userIDs[i] = uuid.New()
+		sharedURLRows.URLRows = append(sharedURLRows.URLRows, models.URLRow{
+			UUID:        uuid.New(),
+			ShortURL:    userIDs[i].String(),
+			OriginalURL: "original-" + userIDs[i].String(),
+			UserID:      userIDs[i],
+		})
+	}
+	sharedURLRows.Mu.Unlock()
+
+	// Восстановление, отправленное после удаления, должно выполниться после него в любой горутине пула.
+	for _, userID := range userIDs {
+		urls := []string{userID.String()}
+		assert.NoError(t, worker.SendDeletionRequestToWorker(DeletionRequest{Operation: OperationDelete, User: models.User{UUID: userID}, URLs: urls}))
+		assert.NoError(t, worker.SendDeletionRequestToWorker(DeletionRequest{Operation: OperationRestore, User: models.User{UUID: userID}, URLs: urls}))
+	}
+	cancel()
+	worker.StartDeletionWorker(ctx)
+
+	sharedURLRows.Mu.Lock()
+	defer sharedURLRows.Mu.Unlock()
+	for _, urlRow := range sharedURLRows.URLRows {
+		assert.False(t, urlRow.DeletedFlag, "expected url %s to be restored", urlRow.ShortURL)
+	}
+}
+
+func TestURLDeletionWorker_ReportErrorAfterListenerStop(t *testing.T) {
+	t.Parallel()
+	service, _ := setupURLShortenerService()
+	worker := InitURLDeletionWorker(service, config.Config{}, logger.GetLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	worker.StartErrorListener(ctx)
+
+	// Ошибок больше, чем вмещает буфер канала: без слушателя отправка не должна блокироваться.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*cap(worker.errorChannel); i++ {
+			worker.reportError(deletionError{ctx: context.Background(), err: assert.AnError})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reportError blocked after the error listener stopped")
+	}
+}
@@ -0,0 +1,88 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
+	shortener "github.com/romanyakovlev/go-yandex-url-shortener/internal/service"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
+)
+
+// Значения по умолчанию для окончательного удаления, если они не заданы в конфиге.
+const (
+	defaultPurgeInterval  = time.Hour
+	defaultPurgeBatchSize = 1000
+)
+
+// URLPurgeWorker фоновый процесс, который по расписанию окончательно стирает
+// URL, удаленные больше retention назад.
+type URLPurgeWorker struct {
+	shortener *shortener.URLShortenerService // Сервис сокращения URL.
+	logger    *logger.Logger                 // Логгер для регистрации событий.
+	retention time.Duration                  // Срок хранения удаленных URL.
+	interval  time.Duration                  // Интервал между запусками.
+	batchSize int                            // Количество строк, удаляемых за один запрос к хранилищу.
+}
+
+// StartPurgeWorker выполняет окончательное удаление сразу и затем каждые interval,
+// блокируясь до отмены контекста.
+func (w *URLPurgeWorker) StartPurgeWorker(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.purge(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// purge выполняет один проход окончательного удаления.
+func (w *URLPurgeWorker) purge(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "URLPurgeWorker.purge",
+		trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("purge.retention", w.retention.String())),
+	)
+	defer span.End()
+
+	purged, err := w.shortener.PurgeDeletedURLs(ctx, w.retention, w.batchSize)
+	metrics.PurgedURLsTotal.Add(float64(purged))
+	span.SetAttributes(attribute.Int("purge.purged", purged))
+	if err != nil {
+		tracing.RecordError(span, err)
+		w.logger.With(ctx).Errorf("Error purging deleted URLs: %v", err)
+		return
+	}
+	if purged > 0 {
+		w.logger.With(ctx).Infof("Purged %d URLs deleted more than %s ago", purged, w.retention)
+	}
+}
+
+// InitURLPurgeWorker инициализирует и возвращает новый процесс окончательного удаления URL.
+// Незаданные в конфиге параметры принимают значения по умолчанию.
+func InitURLPurgeWorker(s *shortener.URLShortenerService, serverConfig config.Config, sugar *logger.Logger) *URLPurgeWorker {
+	interval := serverConfig.PurgeInterval
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+	batchSize := serverConfig.PurgeBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultPurgeBatchSize
+	}
+	return &URLPurgeWorker{
+		shortener: s,
+		logger:    sugar,
+		retention: serverConfig.PurgeRetention,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
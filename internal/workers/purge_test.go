@@ -0,0 +1,47 @@
+package workers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+func TestURLPurgeWorker_Purge(t *testing.T) {
+	t.Parallel()
+	service, sharedURLRows := setupURLShortenerService()
+	worker := InitURLPurgeWorker(service, config.Config{PurgeRetention: 24 * time.Hour}, logger.GetLogger())
+
+	now := time.Now().UTC()
+	expired := now.Add(-48 * time.Hour)
+	recent := now.Add(-time.Hour)
+	sharedURLRows.URLRows = []models.URLRow{
+		{UUID: uuid.New(), ShortURL: "expired", DeletedFlag: true, DeletedAt: &expired},
+		{UUID: uuid.New(), ShortURL: "recent", DeletedFlag: true, DeletedAt: &recent},
+		{UUID: uuid.New(), ShortURL: "legacy", DeletedFlag: true},
+		{UUID: uuid.New(), ShortURL: "live"},
+	}
+
+	worker.purge(context.Background())
+
+	var shortURLs []string
+	for _, urlRow := range sharedURLRows.URLRows {
+		shortURLs = append(shortURLs, urlRow.ShortURL)
+	}
+	assert.Equal(t, []string{"recent", "legacy", "live"}, shortURLs)
+}
+
+func TestInitURLPurgeWorker_Defaults(t *testing.T) {
+	t.Parallel()
+	service, _ := setupURLShortenerService()
+	worker := InitURLPurgeWorker(service, config.Config{}, logger.GetLogger())
+
+	assert.Equal(t, defaultPurgeInterval, worker.interval)
+	assert.Equal(t, defaultPurgeBatchSize, worker.batchSize)
+}
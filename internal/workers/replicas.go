@@ -0,0 +1,76 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/db"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
+)
+
+// defaultReplicaCheckInterval интервал проверки реплик, если он не задан в конфиге.
+const defaultReplicaCheckInterval = 5 * time.Second
+
+// ReplicaMonitor фоновый процесс, который проверяет отставание реплик БД,
+// чтобы чтение шло только с исправных реплик.
+type ReplicaMonitor struct {
+	replicas *db.Replicas   // Проверяемые реплики.
+	logger   *logger.Logger // Логгер для регистрации событий.
+	interval time.Duration  // Интервал между проверками.
+	healthy  map[string]bool
+}
+
+// StartReplicaMonitor проверяет реплики сразу и затем каждые interval,
+// блокируясь до отмены контекста.
+func (m *ReplicaMonitor) StartReplicaMonitor(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.check(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check проверяет все реплики и сообщает в лог о смене их исправности.
+func (m *ReplicaMonitor) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.interval)
+	defer cancel()
+
+	for _, replica := range m.replicas.All() {
+		err := replica.Check(ctx)
+		lag, _ := replica.Status()
+		metrics.DBReplicaLagSeconds.WithLabelValues(replica.Host).Set(lag.Seconds())
+
+		healthy, known := m.healthy[replica.Host]
+		switch {
+		case err != nil && (healthy || !known):
+			metrics.DBReplicaHealthy.WithLabelValues(replica.Host).Set(0)
+			m.logger.Warnf("Replica %s is not used for reads: %v", replica.Host, err)
+		case err == nil && !healthy:
+			metrics.DBReplicaHealthy.WithLabelValues(replica.Host).Set(1)
+			m.logger.Infof("Replica %s is used for reads, lag %s", replica.Host, lag.Round(time.Millisecond))
+		}
+		m.healthy[replica.Host] = err == nil
+	}
+}
+
+// InitReplicaMonitor инициализирует и возвращает новый процесс проверки реплик.
+func InitReplicaMonitor(replicas *db.Replicas, serverConfig config.Config, sugar *logger.Logger) *ReplicaMonitor {
+	interval := serverConfig.DBReplicaCheckInterval
+	if interval <= 0 {
+		interval = defaultReplicaCheckInterval
+	}
+	return &ReplicaMonitor{
+		replicas: replicas,
+		logger:   sugar,
+		interval: interval,
+		healthy:  make(map[string]bool),
+	}
+}
@@ -0,0 +1,117 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/tracing"
+)
+
+// MemorySnapshotWorker фоновый процесс, который сохраняет снимок хранилища в памяти
+// каждые interval и по запросу. Последний снимок сохраняется при остановке сервера вызовом Snapshot.
+type MemorySnapshotWorker struct {
+	sharedURLRows *models.SharedURLRows // Хранилище в памяти.
+	logger        *logger.Logger        // Логгер для регистрации событий.
+	path          string                // Файл снимка.
+	interval      time.Duration         // Интервал между снимками; 0 - только по запросу.
+	trigger       chan struct{}         // Запросы внепланового снимка.
+	mu            sync.Mutex            // Не дает двум снимкам записываться одновременно.
+}
+
+// StartSnapshotWorker сохраняет снимок каждые interval и по запросам Trigger,
+// блокируясь до отмены контекста.
+func (w *MemorySnapshotWorker) StartSnapshotWorker(ctx context.Context) {
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-w.trigger:
+		case <-ctx.Done():
+			return
+		}
+		// Ошибка уже записана в лог и метрики, следующий снимок будет сделан по расписанию.
+		_, _ = w.Snapshot(ctx)
+	}
+}
+
+// Trigger запрашивает внеплановый снимок, не дожидаясь его сохранения.
+// Запрос, пришедший во время ожидания предыдущего, с ним объединяется.
+func (w *MemorySnapshotWorker) Trigger() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Snapshot сохраняет снимок и возвращает количество сохраненных строк.
+func (w *MemorySnapshotWorker) Snapshot(ctx context.Context) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ctx, span := tracing.Start(ctx, "MemorySnapshotWorker.Snapshot",
+		trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("snapshot.path", w.path)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	rows, err := repository.SaveMemorySnapshot(w.sharedURLRows, w.path)
+	if err != nil {
+		metrics.SnapshotsTotal.WithLabelValues("failure").Inc()
+		tracing.RecordError(span, err)
+		w.logger.With(ctx).Errorf("Error saving snapshot to %s: %v", w.path, err)
+		return 0, err
+	}
+	metrics.SnapshotsTotal.WithLabelValues("success").Inc()
+	metrics.SnapshotLastSuccessTimestamp.SetToCurrentTime()
+	span.SetAttributes(attribute.Int("snapshot.rows", rows))
+	w.logger.With(ctx).Debugf("Saved snapshot of %d URLs to %s in %s", rows, w.path, time.Since(start))
+	return rows, nil
+}
+
+// Handler возвращает http-обработчик, который по POST сохраняет снимок и возвращает
+// количество сохраненных строк, например: {"rows":42}.
+func (w *MemorySnapshotWorker) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rows, err := w.Snapshot(r.Context())
+		if err != nil {
+			http.Error(rw, "snapshot failed", http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, "{\"rows\":%d}\n", rows)
+	})
+}
+
+// InitMemorySnapshotWorker инициализирует и возвращает новый процесс сохранения снимков хранилища в памяти.
+func InitMemorySnapshotWorker(sharedURLRows *models.SharedURLRows, serverConfig config.Config, sugar *logger.Logger) *MemorySnapshotWorker {
+	return &MemorySnapshotWorker{
+		sharedURLRows: sharedURLRows,
+		logger:        sugar,
+		path:          serverConfig.SnapshotPath,
+		interval:      serverConfig.SnapshotInterval,
+		trigger:       make(chan struct{}, 1),
+	}
+}
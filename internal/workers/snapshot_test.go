@@ -0,0 +1,128 @@
+package workers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/config"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+)
+
+func TestMemorySnapshotWorker_SnapshotAndLoad(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "snapshot.gz")
+	now := time.Now().UTC()
+	sharedURLRows := models.NewSharedURLRows()
+	sharedURLRows.URLRows = []models.URLRow{
+		{UUID: uuid.New(), ShortURL: "abcdefgh", OriginalURL: "http://practicum.yandex.ru/", UserID: uuid.New(), CreatedAt: now, UpdatedAt: now},
+		{UUID: uuid.New(), ShortURL: "bcdefghi", OriginalURL: "http://ya.ru/", UserID: uuid.New(), CreatedAt: now, UpdatedAt: now},
+	}
+	sharedURLRows.URLRows[1].MarkDeleted(now)
+	sharedURLRows.URLRows[0].ChangeOriginalURL("http://yandex.ru/", now)
+	worker := InitMemorySnapshotWorker(sharedURLRows, config.Config{SnapshotPath: path}, logger.GetLogger())
+
+	rows, err := worker.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, rows)
+
+	restored := models.NewSharedURLRows()
+	rows, err = repository.LoadMemorySnapshot(restored, path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rows)
+	require.Len(t, restored.URLRows, 2)
+	for i, urlRow := range restored.URLRows {
+		expected := sharedURLRows.URLRows[i]
+		assert.Equal(t, expected.UUID, urlRow.UUID)
+		assert.Equal(t, expected.ShortURL, urlRow.ShortURL)
+		assert.Equal(t, expected.OriginalURL, urlRow.OriginalURL)
+		assert.Equal(t, expected.DeletedFlag, urlRow.DeletedFlag)
+		assert.Len(t, urlRow.History, len(expected.History))
+	}
+}
+
+func TestMemorySnapshotWorker_SnapshotAfterDeletionWorkerStop(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "snapshot.gz")
+	service, sharedURLRows := setupURLShortenerService()
+	userID := uuid.New()
+	sharedURLRows.URLRows = []models.URLRow{
+		{UUID: uuid.New(), ShortURL: "abcdefgh", OriginalURL: "http://practicum.yandex.ru/", UserID: userID},
+	}
+	deletionWorker := InitURLDeletionWorker(service, config.Config{DeletionFlushInterval: time.Hour}, logger.GetLogger())
+	snapshotWorker := InitMemorySnapshotWorker(sharedURLRows, config.Config{SnapshotPath: path}, logger.GetLogger())
+
+	// Удаление принято (202), но еще не выполнено; при остановке оно должно попасть в последний снимок.
+	require.NoError(t, deletionWorker.SendDeletionRequestToWorker(DeletionRequest{User: models.User{UUID: userID}, URLs: []string{"abcdefgh"}}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	deletionWorker.StartDeletionWorker(ctx)
+	_, err := snapshotWorker.Snapshot(context.Background())
+	require.NoError(t, err)
+
+	restored := models.NewSharedURLRows()
+	_, err = repository.LoadMemorySnapshot(restored, path)
+	require.NoError(t, err)
+	require.Len(t, restored.URLRows, 1)
+	assert.True(t, restored.URLRows[0].DeletedFlag)
+}
+
+func TestLoadMemorySnapshot_MissingAndCorrupted(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	sharedURLRows := models.NewSharedURLRows()
+
+	rows, err := repository.LoadMemorySnapshot(sharedURLRows, filepath.Join(dir, "missing.gz"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, rows)
+	assert.NotNil(t, sharedURLRows.URLRows)
+
+	corrupted := filepath.Join(dir, "corrupted.gz")
+	require.NoError(t, os.WriteFile(corrupted, []byte{0x1f, 0x8b, 0x08, 0x00, 0x01}, 0o644))
+	_, err = repository.LoadMemorySnapshot(sharedURLRows, corrupted)
+	assert.Error(t, err)
+}
+
+func TestMemorySnapshotWorker_Handler(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "snapshot.gz")
+	sharedURLRows := models.NewSharedURLRows()
+	sharedURLRows.URLRows = []models.URLRow{{UUID: uuid.New(), ShortURL: "abcdefgh", OriginalURL: "http://ya.ru/"}}
+	worker := InitMemorySnapshotWorker(sharedURLRows, config.Config{SnapshotPath: path}, logger.GetLogger())
+
+	w := httptest.NewRecorder()
+	worker.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.NoFileExists(t, path)
+
+	w = httptest.NewRecorder()
+	worker.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/snapshot", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"rows":1}`, w.Body.String())
+	assert.FileExists(t, path)
+}
+
+func TestMemorySnapshotWorker_Trigger(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "snapshot.gz")
+	worker := InitMemorySnapshotWorker(models.NewSharedURLRows(), config.Config{SnapshotPath: path}, logger.GetLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.StartSnapshotWorker(ctx)
+
+	worker.Trigger()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
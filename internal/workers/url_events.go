@@ -0,0 +1,75 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/metrics"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/repository"
+)
+
+// Пауза перед повторным подключением к каналу событий: растет вдвое после каждой неудачи.
+const (
+	minURLEventsRetryInterval = time.Second
+	maxURLEventsRetryInterval = 30 * time.Second
+)
+
+// URLEventHandler применяет события об изменении URL к локальным кэшам.
+type URLEventHandler interface {
+	HandleURLEvent(ctx context.Context, event models.URLEvent) // HandleURLEvent применяет одно событие.
+	Flush(ctx context.Context)                                 // Flush сбрасывает все локальные кэши.
+	Disconnected(ctx context.Context)                          // Disconnected сообщает, что события могут теряться до следующей подписки.
+}
+
+// URLEventListener фоновый процесс, который слушает события об изменении URL от других
+// экземпляров сервиса и сбрасывает по ним локальные кэши.
+type URLEventListener struct {
+	dsn              string          // Строка подключения к БД.
+	handler          URLEventHandler // Получатель событий.
+	logger           *logger.Logger  // Логгер для регистрации событий.
+	minRetryInterval time.Duration   // Пауза перед первым повторным подключением.
+	maxRetryInterval time.Duration   // Максимальная пауза между повторными подключениями.
+	listen           func(ctx context.Context, dsn string, onListen func(), fn func(models.URLEvent)) error
+}
+
+// StartURLEventListener слушает события до отмены контекста, переподключаясь при обрывах.
+// После каждой подписки, включая первую, кэши сбрасываются целиком:
+// события, отправленные, пока подписки не было, потеряны.
+func (l *URLEventListener) StartURLEventListener(ctx context.Context) {
+	retryInterval := l.minRetryInterval
+	for {
+		err := l.listen(ctx, l.dsn, func() {
+			retryInterval = l.minRetryInterval
+			l.handler.Flush(ctx)
+		}, func(event models.URLEvent) {
+			metrics.URLEventsReceivedTotal.WithLabelValues(event.Op).Inc()
+			l.handler.HandleURLEvent(ctx, event)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		l.handler.Disconnected(ctx)
+		metrics.URLEventsReconnectsTotal.Inc()
+		l.logger.Errorf("URL events listener disconnected, reconnecting in %s: %v", retryInterval, err)
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return
+		}
+		retryInterval = min(2*retryInterval, l.maxRetryInterval)
+	}
+}
+
+// InitURLEventListener инициализирует и возвращает новый процесс прослушивания событий об изменении URL.
+func InitURLEventListener(dsn string, handler URLEventHandler, sugar *logger.Logger) *URLEventListener {
+	return &URLEventListener{
+		dsn:              dsn,
+		handler:          handler,
+		logger:           sugar,
+		minRetryInterval: minURLEventsRetryInterval,
+		maxRetryInterval: maxURLEventsRetryInterval,
+		listen:           repository.ListenURLEvents,
+	}
+}
@@ -0,0 +1,72 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/logger"
+	"github.com/romanyakovlev/go-yandex-url-shortener/internal/models"
+)
+
+// recordingURLEventHandler запоминает полученные события и сбросы кэшей.
+type recordingURLEventHandler struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (h *recordingURLEventHandler) HandleURLEvent(ctx context.Context, event models.URLEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, event.Op)
+}
+
+func (h *recordingURLEventHandler) Flush(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, "flush")
+}
+
+func (h *recordingURLEventHandler) Disconnected(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, "disconnected")
+}
+
+func TestURLEventListener_ReconnectsAndFlushes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := &recordingURLEventHandler{}
+	listener := InitURLEventListener("", handler, logger.GetLogger())
+	listener.minRetryInterval = time.Millisecond
+	listener.maxRetryInterval = time.Millisecond
+
+	attempts := 0
+	listener.listen = func(ctx context.Context, dsn string, onListen func(), fn func(models.URLEvent)) error {
+		attempts++
+		switch attempts {
+		case 1:
+			return errors.New("connection refused")
+		case 2:
+			onListen()
+			fn(models.URLEvent{Op: models.URLEventSaved, ShortURLs: []string{"abcdefgh"}})
+			return errors.New("connection reset")
+		default:
+			onListen()
+			fn(models.URLEvent{Op: models.URLEventChanged, ShortURLs: []string{"abcdefgh"}})
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	}
+
+	listener.StartURLEventListener(ctx)
+
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []string{"disconnected", "flush", models.URLEventSaved, "disconnected", "flush", models.URLEventChanged}, handler.calls,
+		"После каждой подписки кэши должны сбрасываться, ведь события за время обрыва потеряны")
+}
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE users (
+    uuid UUID PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO users (uuid)
+SELECT DISTINCT user_id FROM url_rows WHERE user_id IS NOT NULL;
+
+ALTER TABLE url_rows
+    ADD CONSTRAINT fk_url_rows_user_id FOREIGN KEY (user_id) REFERENCES users (uuid);
+
+CREATE INDEX idx_url_rows_user_id ON url_rows (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_url_rows_user_id;
+
+ALTER TABLE url_rows
+    DROP CONSTRAINT fk_url_rows_user_id;
+
+DROP TABLE users;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE url_rows
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN deleted_at TIMESTAMPTZ;
+
+UPDATE url_rows SET deleted_at = now() WHERE is_deleted;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_rows
+    DROP COLUMN created_at,
+    DROP COLUMN updated_at,
+    DROP COLUMN deleted_at;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+DROP INDEX idx_unique_original_url;
+
+CREATE UNIQUE INDEX idx_unique_live_original_url ON url_rows (original_url) WHERE NOT is_deleted;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Откат не пройдет, если после удаления ссылки тот же URL был сокращен повторно.
+DROP INDEX idx_unique_live_original_url;
+
+CREATE UNIQUE INDEX idx_unique_original_url ON url_rows (original_url);
+-- +goose StatementEnd
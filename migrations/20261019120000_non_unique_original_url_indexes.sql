@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Дедупликация оригинальных URL выполняется приложением в выбранной области (global, user или none).
+-- Уникальный индекс idx_unique_live_original_url нужен только области global: при запуске сервер
+-- проверяет, что он есть только в ней, а в режиме автоматических миграций создает или удаляет его.
+CREATE INDEX idx_live_original_url ON url_rows (original_url) WHERE NOT is_deleted;
+
+CREATE INDEX idx_live_user_original_url ON url_rows (user_id, original_url) WHERE NOT is_deleted;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_live_user_original_url;
+
+DROP INDEX idx_live_original_url;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Индекс для окончательного удаления ссылок, удаленных раньше срока хранения.
+CREATE INDEX idx_url_rows_deleted_at ON url_rows (deleted_at) WHERE is_deleted;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_url_rows_deleted_at;
+-- +goose StatementEnd
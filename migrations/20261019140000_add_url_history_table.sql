@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- История исходных URL, замененных через PATCH /api/user/urls/{shortURL}.
+-- Записи стираются вместе со ссылкой при окончательном удалении.
+CREATE TABLE url_history (
+    id           BIGSERIAL PRIMARY KEY,
+    url_uuid     UUID NOT NULL REFERENCES url_rows (uuid) ON DELETE CASCADE,
+    original_url TEXT NOT NULL,
+    replaced_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_url_history_url_uuid ON url_history (url_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE url_history;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Индексы для keyset-пагинации списка URL пользователя по времени создания и по исходному URL.
+-- Они начинаются с user_id и заменяют собой idx_url_rows_user_id.
+CREATE INDEX idx_url_rows_user_created_at ON url_rows (user_id, created_at, uuid);
+
+CREATE INDEX idx_url_rows_user_original_url ON url_rows (user_id, original_url COLLATE "C", uuid);
+
+DROP INDEX idx_url_rows_user_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE INDEX idx_url_rows_user_id ON url_rows (user_id);
+
+DROP INDEX idx_url_rows_user_original_url;
+
+DROP INDEX idx_url_rows_user_created_at;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Короткий адрес должен быть уникален: при импорте ссылок он задается пользователем.
+-- Миграция не пройдет, если в таблице уже есть совпадающие короткие адреса.
+CREATE UNIQUE INDEX idx_unique_short_url ON url_rows (short_url);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_unique_short_url;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+// Package migrations содержит SQL-миграции БД, встроенные в бинарник.
+package migrations
+
+import "embed"
+
+// FS файловая система с SQL-миграциями.
+//
+//go:embed *.sql
+var FS embed.FS